@@ -95,6 +95,11 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
+func (b *API) EditsMessages()    {}
+func (b *API) PreservesThreads() {}
+func (b *API) UploadsFiles()     {}
+func (b *API) RecordsMessages()  {}
+
 func (b *API) Connect() error {
 	return nil
 }
@@ -110,16 +115,12 @@ func (b *API) JoinChannel(channel config.ChannelInfo) error {
 func (b *API) Send(msg config.Message) (string, error) {
 	b.Lock()
 	defer b.Unlock()
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
 	b.Log.Debugf("enqueueing message from %s on ring buffer", msg.Username)
 	b.Messages.Enqueue(msg)
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		b.Log.Errorf("failed to encode message  '%s'", msg)
+		b.Log.Errorf("failed to encode message  '%#v'", msg)
 	}
 	_ = b.mrouter.Broadcast(data)
 	return "", nil
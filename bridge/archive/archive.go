@@ -0,0 +1,191 @@
+// Package barchive is an outgoing only bridge that archives all messages
+// relayed to it in SQLite, and serves them on a query API.
+package barchive
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type Barchive struct {
+	*bridge.Config
+
+	// RWMutex guards store, it's nil while disconnected.
+	sync.RWMutex
+	store *store
+	e     *echo.Echo
+}
+
+func New(cfg *bridge.Config) bridge.Bridger {
+	b := &Barchive{Config: cfg}
+	// archive the nicks as they are
+	if !b.IsKeySet("RemoteNickFormat") {
+		b.Config.Config.Viper().Set(b.GetConfigKey("RemoteNickFormat"), "{NICK}")
+	}
+	return b
+}
+
+func (b *Barchive) EditsMessages()    {}
+func (b *Barchive) DeletesMessages()  {}
+func (b *Barchive) PreservesThreads() {}
+func (b *Barchive) UploadsFiles()     {}
+func (b *Barchive) RecordsMessages()  {}
+
+func (b *Barchive) Connect() error {
+	if !sqliteSupport {
+		return fmt.Errorf("sqlite support not compiled in, rebuild matterbridge with -tags sqlite")
+	}
+	path := b.GetString("ArchivePath")
+	if path == "" {
+		return errors.New("no ArchivePath configured")
+	}
+	// the query API serves all archived messages
+	addr, token := b.GetString("BindAddress"), b.GetString("Token")
+	if addr != "" && token == "" {
+		return errors.New("BindAddress is configured without a Token")
+	}
+	s, err := openStore(path)
+	if err != nil {
+		return fmt.Errorf("opening archive %s failed: %s", path, err)
+	}
+	b.Lock()
+	b.store = s
+	b.Unlock()
+	b.Log.Infof("Archiving to %s", path)
+
+	if addr != "" {
+		b.e = echo.New()
+		b.e.HideBanner = true
+		b.e.HidePort = true
+		b.e.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+			return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+		}))
+		b.e.GET("/api/messages", b.handleMessages)
+		go func() {
+			b.Log.Infof("Listening on %s", addr)
+			if err := b.e.Start(addr); err != nil && err != http.ErrServerClosed {
+				b.Log.Errorf("Archive API failed: %s", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *Barchive) Disconnect() error {
+	if b.e != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := b.e.Shutdown(ctx); err != nil {
+			b.Log.Errorf("Stopping archive API failed: %s", err)
+		}
+		b.e = nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.store == nil {
+		return nil
+	}
+	err := b.store.Close()
+	b.store = nil
+	return err
+}
+
+func (b *Barchive) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+// Send archives msg and returns the ID of its row, edits and deletes update
+// the row of the message they refer to.
+func (b *Barchive) Send(msg config.Message) (string, error) {
+	b.RLock()
+	defer b.RUnlock()
+	if b.store == nil {
+		return "", errors.New("archive is closed")
+	}
+	switch msg.Event {
+	case config.EventMsgDelete:
+		id, err := strconv.ParseInt(msg.ID, 10, 64)
+		if err != nil {
+			return "", nil
+		}
+		return "", b.store.delete(id)
+	}
+
+	if msg.ID != "" {
+		if id, err := strconv.ParseInt(msg.ID, 10, 64); err == nil {
+			ok, err := b.store.edit(id, msg.Text)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				return msg.ID, nil
+			}
+		}
+	}
+	id, err := b.store.add(&msg)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// handleMessages returns the archived messages matching the channel, user,
+// since, until and q parameters, newest first. since and until are RFC 3339
+// times, q is a full-text search query.
+func (b *Barchive) handleMessages(c echo.Context) error {
+	q := &Query{
+		Channel: c.QueryParam("channel"),
+		User:    c.QueryParam("user"),
+		Search:  c.QueryParam("q"),
+		Limit:   defaultLimit,
+	}
+	var err error
+	if since := c.QueryParam("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "since is not an RFC 3339 time")
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "until is not an RFC 3339 time")
+		}
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit is not a positive number")
+		}
+		if q.Limit > maxLimit {
+			q.Limit = maxLimit
+		}
+	}
+	b.RLock()
+	defer b.RUnlock()
+	if b.store == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "archive is closed")
+	}
+	msgs, err := b.store.query(q)
+	if err != nil {
+		if q.Search != "" {
+			// most likely a syntax error in the search query
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, msgs)
+}
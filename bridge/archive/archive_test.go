@@ -0,0 +1,137 @@
+//go:build sqlite
+// +build sqlite
+
+package barchive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestArchive(t *testing.T) *Barchive {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "archive.test"})
+	br.Log = logrus.NewEntry(logger)
+	br.Config = config.NewConfigFromString(logger, []byte(fmt.Sprintf(`
+[archive.test]
+ArchivePath=%q
+`, filepath.Join(t.TempDir(), "archive.db"))))
+	b := New(&bridge.Config{Bridge: br}).(*Barchive)
+	require.NoError(t, b.Connect())
+	t.Cleanup(func() {
+		assert.NoError(t, b.Disconnect())
+	})
+	return b
+}
+
+func TestArchiveAPIWithoutToken(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "archive.test"})
+	br.Log = logrus.NewEntry(logger)
+	br.Config = config.NewConfigFromString(logger, []byte(fmt.Sprintf(`
+[archive.test]
+ArchivePath=%q
+BindAddress="127.0.0.1:0"
+`, filepath.Join(t.TempDir(), "archive.db"))))
+	b := New(&bridge.Config{Bridge: br}).(*Barchive)
+	assert.EqualError(t, b.Connect(), "BindAddress is configured without a Token")
+}
+
+func query(t *testing.T, b *Barchive, params string) (int, []Message) {
+	req := httptest.NewRequest(http.MethodGet, "/api/messages?"+params, nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	err := b.handleMessages(c)
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code, nil
+	}
+	require.NoError(t, err)
+	var msgs []Message
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msgs))
+	return rec.Code, msgs
+}
+
+func TestArchive(t *testing.T) {
+	b := newTestArchive(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	send := func(msg config.Message) string {
+		id, err := b.Send(msg)
+		require.NoError(t, err)
+		return id
+	}
+
+	data := []byte("data")
+	id1 := send(config.Message{
+		Text: "hello world", Username: "alice", UserID: "U1", Account: "slack.test", Protocol: "slack",
+		Channel: "general", Gateway: "gw", Timestamp: start,
+	})
+	id2 := send(config.Message{
+		Text: "a file", Username: "bob", UserID: "U2", Account: "irc.test", Protocol: "irc",
+		Channel: "#general", Gateway: "gw", Timestamp: start.Add(time.Minute), ParentID: id1,
+		Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "file.txt", Data: &data, URL: "https://example.org/file.txt"}}},
+	})
+	send(config.Message{
+		Text: "goodbye world", Username: "alice", UserID: "U1", Account: "slack.test", Protocol: "slack",
+		Channel: "general", Gateway: "gw", Timestamp: start.Add(2 * time.Minute),
+	})
+	_, err := b.Send(config.Message{Event: config.EventUserTyping, Username: "alice"})
+	require.NoError(t, err)
+
+	code, msgs := query(t, b, "")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "goodbye world", msgs[0].Text, "newest first")
+	assert.Equal(t, id1, msgs[1].ParentID)
+	assert.Equal(t, []File{{Name: "file.txt", Size: 4, URL: "https://example.org/file.txt"}}, msgs[1].Files)
+	assert.True(t, start.Equal(msgs[2].Timestamp))
+	assert.Equal(t, "slack.test", msgs[2].Account)
+
+	_, msgs = query(t, b, "channel=general&user=U1")
+	assert.Len(t, msgs, 2)
+	_, msgs = query(t, b, "user=bob")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, id2, fmt.Sprint(msgs[0].ID))
+	_, msgs = query(t, b, "since=2024-01-01T12:01:00Z&until=2024-01-01T12:02:00Z")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "a file", msgs[0].Text)
+	_, msgs = query(t, b, "q=world&limit=1")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "goodbye world", msgs[0].Text)
+
+	// edits and deletes update the row
+	assert.Equal(t, id1, send(config.Message{ID: id1, Text: "hello there", Username: "alice"}))
+	send(config.Message{ID: id2, Event: config.EventMsgDelete, Text: config.EventMsgDelete})
+	_, msgs = query(t, b, "q=hello")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "hello there", msgs[0].Text)
+	assert.NotNil(t, msgs[0].Edited)
+	_, msgs = query(t, b, "q=world")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "goodbye world", msgs[0].Text)
+	_, msgs = query(t, b, "user=bob")
+	require.Len(t, msgs, 1)
+	assert.NotNil(t, msgs[0].Deleted, "deleted messages stay archived")
+
+	// an edit of a message that isn't archived is archived as a new message
+	assert.NotEqual(t, "1234", send(config.Message{ID: "1234", Text: "edited", Username: "carol"}))
+
+	for _, params := range []string{"since=yesterday", "limit=0", "q=%22unbalanced"} {
+		code, _ = query(t, b, params)
+		assert.Equal(t, http.StatusBadRequest, code, params)
+	}
+}
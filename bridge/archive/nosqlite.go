@@ -0,0 +1,7 @@
+//go:build !sqlite
+// +build !sqlite
+
+package barchive
+
+// sqliteSupport is false as matterbridge is built without sqlite support.
+const sqliteSupport = false
@@ -0,0 +1,10 @@
+//go:build sqlite
+// +build sqlite
+
+package barchive
+
+import (
+	_ "modernc.org/sqlite" // needed for sqlite
+)
+
+const sqliteSupport = true
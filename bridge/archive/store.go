@@ -0,0 +1,265 @@
+package barchive
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	text      TEXT NOT NULL,
+	username  TEXT NOT NULL,
+	userid    TEXT NOT NULL,
+	account   TEXT NOT NULL,
+	protocol  TEXT NOT NULL,
+	channel   TEXT NOT NULL,
+	gateway   TEXT NOT NULL,
+	event     TEXT NOT NULL,
+	parent_id TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	archived  INTEGER NOT NULL,
+	edited    INTEGER,
+	deleted   INTEGER
+);
+CREATE TABLE IF NOT EXISTS files (
+	message_id INTEGER NOT NULL REFERENCES messages (id),
+	name       TEXT NOT NULL,
+	size       INTEGER NOT NULL,
+	url        TEXT NOT NULL,
+	sha        TEXT NOT NULL,
+	comment    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_channel ON messages (channel, timestamp);
+CREATE INDEX IF NOT EXISTS messages_timestamp ON messages (timestamp);
+CREATE INDEX IF NOT EXISTS files_message_id ON files (message_id);
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (text, content='messages', content_rowid='id');
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+END;
+`
+
+// Message is an archived message as returned by the query API.
+type Message struct {
+	ID        int64      `json:"id"`
+	Text      string     `json:"text"`
+	Username  string     `json:"username"`
+	UserID    string     `json:"userid"`
+	Account   string     `json:"account"`
+	Protocol  string     `json:"protocol"`
+	Channel   string     `json:"channel"`
+	Gateway   string     `json:"gateway"`
+	Event     string     `json:"event"`
+	ParentID  string     `json:"parent_id"`
+	Timestamp time.Time  `json:"timestamp"`
+	Archived  time.Time  `json:"archived"`
+	Edited    *time.Time `json:"edited,omitempty"`
+	Deleted   *time.Time `json:"deleted,omitempty"`
+	Files     []File     `json:"files,omitempty"`
+}
+
+// File is the metadata of a file of an archived message, the data isn't
+// archived.
+type File struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	URL     string `json:"url"`
+	SHA     string `json:"sha"`
+	Comment string `json:"comment"`
+}
+
+// Query selects archived messages, empty fields match all messages.
+type Query struct {
+	Channel string
+	// User matches the username or the user ID.
+	User  string
+	Since time.Time
+	Until time.Time
+	// Search is a SQLite full-text search query on the text.
+	Search string
+	Limit  int
+}
+
+type store struct {
+	db *sql.DB
+}
+
+func openStore(path string) (*store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows one writer, serialize access instead of retrying on SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// unixMilli returns t in milliseconds since the epoch, the current time for
+// the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixMilli()
+}
+
+// add archives msg and returns the ID of its row.
+func (s *store) add(msg *config.Message) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.Exec(`INSERT INTO messages (text, username, userid, account, protocol, channel, gateway,
+		event, parent_id, timestamp, archived) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.Text, msg.Username, msg.UserID, msg.Account, msg.Protocol, msg.Channel, msg.Gateway,
+		msg.Event, msg.ParentID, unixMilli(msg.Timestamp), unixMilli(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		size := fi.Size
+		if size == 0 && fi.Data != nil {
+			size = int64(len(*fi.Data))
+		}
+		if _, err := tx.Exec(`INSERT INTO files (message_id, name, size, url, sha, comment) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, fi.Name, size, fi.URL, fi.SHA, fi.Comment); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// edit replaces the text of the message with the ID. Returns false if there
+// is no such message.
+func (s *store) edit(id int64, text string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE messages SET text = ?, edited = ? WHERE id = ?`,
+		text, unixMilli(time.Now()), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// delete marks the message with the ID as deleted, it stays archived.
+func (s *store) delete(id int64) error {
+	_, err := s.db.Exec(`UPDATE messages SET deleted = ? WHERE id = ? AND deleted IS NULL`,
+		unixMilli(time.Now()), id)
+	return err
+}
+
+// query returns the messages matching q, newest first.
+func (s *store) query(q *Query) ([]Message, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if q.Channel != "" {
+		where = append(where, "channel = ?")
+		args = append(args, q.Channel)
+	}
+	if q.User != "" {
+		where = append(where, "(username = ? OR userid = ?)")
+		args = append(args, q.User, q.User)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, unixMilli(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, unixMilli(q.Until))
+	}
+	if q.Search != "" {
+		where = append(where, "id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)")
+		args = append(args, q.Search)
+	}
+	stmt := `SELECT id, text, username, userid, account, protocol, channel, gateway, event, parent_id,
+		timestamp, archived, edited, deleted FROM messages`
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY timestamp DESC, id DESC LIMIT " + strconv.Itoa(q.Limit)
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []Message{}
+	for rows.Next() {
+		var (
+			msg                 Message
+			timestamp, archived int64
+			edited, deleted     sql.NullInt64
+		)
+		if err := rows.Scan(&msg.ID, &msg.Text, &msg.Username, &msg.UserID, &msg.Account, &msg.Protocol,
+			&msg.Channel, &msg.Gateway, &msg.Event, &msg.ParentID, &timestamp, &archived, &edited, &deleted); err != nil {
+			return nil, err
+		}
+		msg.Timestamp = time.UnixMilli(timestamp)
+		msg.Archived = time.UnixMilli(archived)
+		if edited.Valid {
+			t := time.UnixMilli(edited.Int64)
+			msg.Edited = &t
+		}
+		if deleted.Valid {
+			t := time.UnixMilli(deleted.Int64)
+			msg.Deleted = &t
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for i := range msgs {
+		if msgs[i].Files, err = s.files(msgs[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (s *store) files(id int64) ([]File, error) {
+	rows, err := s.db.Query(`SELECT name, size, url, sha, comment FROM files WHERE message_id = ? ORDER BY rowid`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := rows.Scan(&f.Name, &f.Size, &f.URL, &f.SHA, &f.Comment); err != nil {
+			return nil, fmt.Errorf("reading files of message %d failed: %s", id, err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -30,6 +30,8 @@ type Bridge struct {
 	Log            *logrus.Entry
 	Config         config.Config
 	General        *config.Protocol
+
+	connected bool
 }
 
 type Config struct {
@@ -71,6 +73,64 @@ func (b *Bridge) SetChannelMembers(newMembers *config.ChannelMembers) {
 	b.Unlock()
 }
 
+// GetChannelMembers returns the members synced with EventGetChannelMembers.
+func (b *Bridge) GetChannelMembers() config.ChannelMembers {
+	b.RLock()
+	defer b.RUnlock()
+	if b.ChannelMembers == nil {
+		return nil
+	}
+	return *b.ChannelMembers
+}
+
+// SendChannelMembers sends the members of the channels to the gateway, the
+// answer to EventGetChannelMembers.
+func (b *Config) SendChannelMembers(members config.ChannelMembers) {
+	b.Remote <- config.Message{
+		Account: b.Account,
+		Event:   config.EventGetChannelMembers,
+		Extra:   map[string][]interface{}{config.EventGetChannelMembers: {members}},
+	}
+}
+
+// RequestBackfill asks the gateway to backfill the channels of the bridge, for
+// bridges that reconnect by themselves and missed messages meanwhile.
+func (b *Config) RequestBackfill() {
+	b.Remote <- config.Message{
+		Account: b.Account,
+		Event:   config.EventBackfill,
+	}
+}
+
+// DefaultBackfillLimit is the number of messages backfilled per channel when
+// BackfillLimit isn't set.
+const DefaultBackfillLimit = 100
+
+// BackfillLimit returns the maximum number of messages to backfill per channel.
+func (b *Bridge) BackfillLimit() int {
+	if limit := b.GetInt("BackfillLimit"); limit > 0 {
+		return limit
+	}
+	return DefaultBackfillLimit
+}
+
+// SetConnected records if the bridge is connected.
+func (b *Bridge) SetConnected(connected bool) {
+	b.Lock()
+	b.connected = connected
+	b.Unlock()
+}
+
+// Connected returns true if the bridge is connected.
+func (b *Bridge) Connected() bool {
+	if b.RWMutex == nil {
+		return false
+	}
+	b.RLock()
+	defer b.RUnlock()
+	return b.connected
+}
+
 func (b *Bridge) joinChannels(channels map[string]config.ChannelInfo, exists map[string]bool) error {
 	for ID, channel := range channels {
 		if !exists[ID] {
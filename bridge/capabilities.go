@@ -0,0 +1,97 @@
+package bridge
+
+// The optional interfaces below tell the gateway what a Bridger can do with
+// the messages it sends. The gateway drops or downgrades the events a
+// destination doesn't support, so Send only gets messages it can handle.
+// Their methods are markers and never called, unless they return a value.
+
+// Editor is implemented by bridges that edit the messages they sent. They get
+// messages with the ID of the message to edit, other bridges get the edit as
+// a new message.
+type Editor interface {
+	EditsMessages()
+}
+
+// Deleter is implemented by bridges that delete the messages they sent on
+// EventMsgDelete.
+type Deleter interface {
+	DeletesMessages()
+}
+
+// Threader is implemented by bridges that reply in threads when ParentID is
+// set. The other bridges don't get a ParentID.
+type Threader interface {
+	PreservesThreads()
+}
+
+// Reactor is implemented by bridges that send EventReaction as native
+// reactions.
+type Reactor interface {
+	SendsReactions()
+}
+
+// ReactionTexter is implemented by bridges without native reactions that get
+// them as text messages instead, like `reacted 👍 to "hello"`.
+type ReactionTexter interface {
+	ReactionsAsText()
+}
+
+// TypingSender is implemented by bridges that show EventUserTyping.
+type TypingSender interface {
+	SendsTyping()
+}
+
+// TopicSetter is implemented by bridges that set the topic of their channel on
+// EventTopicChange when SyncTopic is enabled.
+type TopicSetter interface {
+	SetsTopic()
+}
+
+// FileUploader is implemented by bridges that upload the files of a message.
+// The other bridges get a text message with the URL and comment of every
+// file.
+type FileUploader interface {
+	UploadsFiles()
+}
+
+// FileSeparator is implemented by bridges without FileUploader that separate
+// the comment and the URL of a file with something else than ": ".
+type FileSeparator interface {
+	FileCommentSeparator() string
+}
+
+// AvatarCacher is implemented by bridges that cache the avatars of their
+// users with EventAvatarDownload.
+type AvatarCacher interface {
+	CachesAvatars()
+}
+
+// NoticeSender is implemented by bridges that send EventNoticeIRC as notices.
+type NoticeSender interface {
+	SendsNotices()
+}
+
+// MemberLister is implemented by bridges that answer EventGetChannelMembers
+// with the members of their channels.
+type MemberLister interface {
+	ListsMembers()
+}
+
+// PluginRelay is implemented by the mattermost.plugin account, the gateway
+// hands the messages sent to it to Router.MattermostPlugin.
+type PluginRelay interface {
+	RelaysToPlugin()
+}
+
+// Recorder is implemented by bridges that keep the messages as they were
+// received, like the API and the archive. They get the channel the message
+// came from, and backfilled messages without BackfillFormat.
+type Recorder interface {
+	RecordsMessages()
+}
+
+// Backfiller is implemented by bridges that answer EventBackfill with the
+// messages of a channel they missed.
+type Backfiller interface {
+	Backfills()
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -28,6 +30,8 @@ const (
 	EventUserTyping        = "user_typing"
 	EventGetChannelMembers = "get_channel_members"
 	EventNoticeIRC         = "notice_irc"
+	EventReaction          = "reaction"
+	EventBackfill          = "backfill"
 )
 
 const ParentIDNotFound = "msg-parent-not-found"
@@ -43,9 +47,35 @@ type Message struct {
 	Protocol  string    `json:"protocol"`
 	Gateway   string    `json:"gateway"`
 	ParentID  string    `json:"parent_id"`
-	Timestamp time.Time `json:"timestamp"`
+	Timestamp time.Time `json:"timestamp"` // when it was sent, the time of the native event if the bridge has it
 	ID        string    `json:"id"`
-	Extra     map[string][]interface{}
+	Reaction  *Reaction `json:"reaction,omitempty"`
+	// Formatted is the formatting of Text, for bridges that parse it.
+	Formatted *richtext.Document `json:"formatted,omitempty"`
+	// Backfill is set on the messages a bridge fetched from its history after
+	// a reconnect or restart, their Timestamp is when they were sent.
+	Backfill bool `json:"backfill,omitempty"`
+	// ReceivedAt is when the gateway received the message, Timestamp is set
+	// to it when the bridge didn't set it.
+	ReceivedAt time.Time `json:"received_at"`
+	Extra      map[string][]interface{}
+}
+
+// Reaction is the reaction of an EventReaction message. The reacted message
+// is in the ParentID of the message.
+type Reaction struct {
+	// Emoji is an unicode emoji or the :name: of a custom emoji.
+	Emoji   string `json:"emoji"`
+	Removed bool   `json:"removed"`
+}
+
+// RichText returns the formatting of Text, or nil when the bridge didn't
+// parse it or Text was changed afterwards.
+func (m Message) RichText() *richtext.Document {
+	if m.Formatted == nil || m.Formatted.Source != m.Text {
+		return nil
+	}
+	return m.Formatted
 }
 
 func (m Message) ParentNotFound() bool {
@@ -76,6 +106,9 @@ type ChannelInfo struct {
 	Options     ChannelOptions
 }
 
+// ChannelMember is a member of a channel of a bridge. ChannelID and
+// ChannelName are empty for members of all its channels, like the members of
+// a Discord server.
 type ChannelMember struct {
 	Username    string
 	Nick        string
@@ -87,18 +120,30 @@ type ChannelMember struct {
 type ChannelMembers []ChannelMember
 
 type Protocol struct {
+	AdminBindAddress       string   // general
+	AdminToken             string   // general
+	AppToken               string   // slack, app-level token for Socket Mode
 	AllowMention           []string // discord
+	ArchivePath            string   // archive
 	AuthCode               string   // steam
-	BindAddress            string   // mattermost, slack // DEPRECATED
+	Backfill               bool     // discord, matrix, mattermost, slack, zulip, relay the messages missed while disconnected
+	BackfillFormat         string   // all protocols, prefix of backfilled messages, {TIME} is when they were sent
+	BackfillLimit          int      // discord, matrix, mattermost, slack, zulip, maximum number of messages backfilled per channel
+	BackfillPath           string   // general, file keeping the last relayed message of every channel
+	BindAddress            string   // api, archive, mattermost, slack // DEPRECATED for mattermost and slack
 	Buffer                 int      // api
+	ChannelMembersInterval int      // general, minutes between the updates of the channel members, negative disables them
 	Charset                string   // irc
 	ClientID               string   // msteams
 	ColorNicks             bool     // only irc for now
+	CommandPrefix          string   // all protocols, prefix of the gateway commands like !who
 	Debug                  bool     // general
 	DebugLevel             int      // only for irc now
 	DisableWebPagePreview  bool     // telegram
 	EditSuffix             string   // mattermost, slack, discord, telegram, gitter
 	EditDisable            bool     // mattermost, slack, discord, telegram, gitter
+	EventsBindAddress      string   // slack, address of the Events API server
+	EventsPath             string   // slack, path of the Events API server
 	HTMLDisable            bool     // matrix
 	IconURL                string   // mattermost, slack
 	IgnoreFailureOnStart   bool     // general
@@ -112,8 +157,21 @@ type Protocol struct {
 	MediaDownloadBlackList []string
 	MediaDownloadPath      string // Basically MediaServerUpload, but instead of uploading it, just write it to a file on the same server.
 	MediaDownloadSize      int    // all protocols
+	MediaServerBindAddress string // general
 	MediaServerDownload    string
+	MediaServerSigningKey  string // general
+	MediaServerToken       string // general
 	MediaServerUpload      string
+	MediaServerURLExpiry   int        // general, hours
+	MediaStore             string     // general, local, webdav or s3
+	MediaRetentionMaxAge   int        // general, hours
+	MediaRetentionMaxSize  int        // general, megabytes
+	MediaS3Endpoint        string     // general
+	MediaS3Region          string     // general
+	MediaS3Bucket          string     // general
+	MediaS3Prefix          string     // general
+	MediaS3AccessKey       string     // general
+	MediaS3SecretKey       string     // general
 	MediaConvertTgs        string     // telegram
 	MediaConvertWebPToPNG  bool       // telegram
 	MessageDelay           int        // IRC, time in millisecond to wait between messages
@@ -122,6 +180,10 @@ type Protocol struct {
 	MessageQueue           int        // IRC, size of message queue for flood control
 	MessageSplit           bool       // IRC, split long messages with newlines on MessageLength instead of clipping
 	MessageSplitMaxCount   int        // discord, split long messages into at most this many messages instead of clipping (MessageLength=1950 cannot be configured)
+	MetricsBindAddress     string     // general
+	MessageStore           string     // general, memory or sqlite
+	MessageStorePath       string     // general, path of the sqlite message store
+	MessageStoreRetention  int        // general, hours to keep message ID mappings in the sqlite store
 	Muc                    string     // xmpp
 	MxID                   string     // matrix
 	Name                   string     // all protocols
@@ -134,6 +196,8 @@ type Protocol struct {
 	NoHomeServerSuffix     bool       // matrix
 	NoSendJoinPart         bool       // all protocols
 	NoTLS                  bool       // mattermost, xmpp
+	OutboundQueuePath      string     // general
+	OutboundQueueRetries   int        // all protocols
 	Password               string     // IRC,mattermost,XMPP,matrix
 	PrefixMessagesWithNick bool       // mattemost, slack
 	PreserveThreading      bool       // slack
@@ -149,9 +213,10 @@ type Protocol struct {
 	RunCommands            []string   // IRC
 	Server                 string     // IRC,mattermost,XMPP,discord,matrix
 	SessionFile            string     // msteams,whatsapp
+	SigningSecret          string     // slack, verifies the requests of the Events API
 	ShowJoinPart           bool       // all protocols
 	ShowTopicChange        bool       // slack
-	ShowUserTyping         bool       // slack
+	ShowUserTyping         bool       // discord, slack, matrix, mattermost, telegram, xmpp, rocketchat
 	ShowEmbeds             bool       // discord
 	SkipTLSVerify          bool       // IRC, mattermost
 	SkipVersionCheck       bool       // mattermost
@@ -162,11 +227,12 @@ type Protocol struct {
 	Team                   string     // mattermost, keybase
 	TeamID                 string     // msteams
 	TenantID               string     // msteams
-	Token                  string     // gitter, slack, discord, api, matrix
+	Token                  string     // gitter, slack, discord, api, archive, matrix
 	Topic                  string     // zulip
 	URL                    string     // mattermost, slack // DEPRECATED
 	UseAPI                 bool       // mattermost, slack
 	UseLocalAvatar         []string   // discord
+	UseSocketMode          bool       // slack
 	UseSASL                bool       // IRC
 	UseTLS                 bool       // IRC
 	UseDiscriminator       bool       // discord
@@ -247,21 +313,22 @@ type Config interface {
 	GetString(key string) (string, bool)
 	GetStringSlice(key string) ([]string, bool)
 	GetStringSlice2D(key string) ([][]string, bool)
+	OnReload(f func())
 }
 
 type config struct {
 	sync.RWMutex
 
-	logger *logrus.Entry
-	v      *viper.Viper
-	cv     *BridgeValues
+	logger   *logrus.Entry
+	v        *viper.Viper
+	cv       *BridgeValues
+	onReload []func()
 }
 
 // NewConfig instantiates a new configuration based on the specified configuration file path.
 func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "config"})
 
-	viper.SetConfigFile(cfgfile)
 	input, err := ioutil.ReadFile(cfgfile)
 	if err != nil {
 		logger.Fatalf("Failed to read configuration file: %#v", err)
@@ -269,6 +336,7 @@ func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 
 	cfgtype := detectConfigType(cfgfile)
 	mycfg := newConfigFromString(logger, input, cfgtype)
+	mycfg.v.SetConfigFile(cfgfile)
 	if mycfg.cv.General.LogFile != "" {
 		logfile, err := os.OpenFile(mycfg.cv.General.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 		if err == nil {
@@ -278,16 +346,98 @@ func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 			logger.Warn("Failed to open ", mycfg.cv.General.LogFile)
 		}
 	}
-	if mycfg.cv.General.MediaDownloadSize == 0 {
-		mycfg.cv.General.MediaDownloadSize = 1000000
+	setDefaults(mycfg.cv)
+	if err := mycfg.watch(cfgfile); err != nil {
+		logger.Errorf("Watching the configuration file failed: %s", err)
 	}
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		logger.Println("Config file changed:", e.Name)
-	})
 	return mycfg
 }
 
+// watch reloads the configuration when cfgfile changes. It's like
+// viper.WatchConfig, but viper reads the file in there without our lock.
+func (c *config) watch(cfgfile string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file := filepath.Clean(cfgfile)
+	// editors replace the file, so watch its directory
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+	realFile, _ := filepath.EvalSymlinks(file)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// the real path changes when a symlink is replaced, like a
+				// kubernetes ConfigMap
+				currentFile, _ := filepath.EvalSymlinks(file)
+				switch {
+				case filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0,
+					currentFile != "" && currentFile != realFile:
+					realFile = currentFile
+					c.logger.Println("Config file changed:", event.Name)
+					c.reload()
+				case filepath.Clean(event.Name) == file && event.Op&fsnotify.Remove != 0:
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.logger.Errorf("Watching the configuration file failed: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func setDefaults(cv *BridgeValues) {
+	if cv.General.MediaDownloadSize == 0 {
+		cv.General.MediaDownloadSize = 1000000
+	}
+}
+
+// reload updates the BridgeValues with the changed configuration file and
+// calls the functions registered with OnReload.
+func (c *config) reload() {
+	c.Lock()
+	if err := c.v.ReadInConfig(); err != nil {
+		c.Unlock()
+		c.logger.Errorf("Failed to reload the configuration: %s", err)
+		return
+	}
+	cfg := &BridgeValues{}
+	if err := c.v.Unmarshal(cfg); err != nil {
+		c.Unlock()
+		c.logger.Errorf("Failed to reload the configuration: %s", err)
+		return
+	}
+	setDefaults(cfg)
+	// debug is set by the commandline, not by the configuration file
+	cfg.General.Debug = c.cv.General.Debug
+	// the values aren't changed, readers keep the ones they got
+	c.cv = cfg
+	onReload := c.onReload
+	c.Unlock()
+	for _, f := range onReload {
+		f()
+	}
+}
+
+// OnReload registers f to be called after the configuration file has been changed and reloaded.
+func (c *config) OnReload(f func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.onReload = append(c.onReload, f)
+}
+
 // detectConfigType detects JSON and YAML formats, defaults to TOML.
 func detectConfigType(cfgfile string) string {
 	fileExt := filepath.Ext(cfgfile)
@@ -307,27 +457,58 @@ func NewConfigFromString(rootLogger *logrus.Logger, input []byte) Config {
 }
 
 func newConfigFromString(logger *logrus.Entry, input []byte, cfgtype string) *config {
-	viper.SetConfigType(cfgtype)
-	viper.SetEnvPrefix("matterbridge")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
-	viper.AutomaticEnv()
+	cfg, err := parseConfig(logger, input, cfgtype)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return cfg
+}
+
+// LoadConfig reads the configuration file like NewConfig, but returns an error
+// instead of exiting when it can't be parsed and doesn't watch it for changes.
+func LoadConfig(rootLogger *logrus.Logger, cfgfile string) (Config, error) {
+	logger := rootLogger.WithFields(logrus.Fields{"prefix": "config"})
+	input, err := ioutil.ReadFile(cfgfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %s", err)
+	}
+	cfg, err := parseConfig(logger, input, detectConfigType(cfgfile))
+	if err != nil {
+		return nil, err
+	}
+	setDefaults(cfg.cv)
+	return cfg, nil
+}
 
-	if err := viper.ReadConfig(bytes.NewBuffer(input)); err != nil {
-		logger.Fatalf("Failed to parse the configuration: %s", err)
+func parseConfig(logger *logrus.Entry, input []byte, cfgtype string) (*config, error) {
+	// every configuration has its own viper, a reload doesn't change the others
+	v := viper.New()
+	v.SetConfigType(cfgtype)
+	v.SetEnvPrefix("matterbridge")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+
+	if err := v.ReadConfig(bytes.NewBuffer(input)); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration: %s", err)
 	}
 
 	cfg := &BridgeValues{}
-	if err := viper.Unmarshal(cfg); err != nil {
-		logger.Fatalf("Failed to load the configuration: %s", err)
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to load the configuration: %s", err)
 	}
 	return &config{
 		logger: logger,
-		v:      viper.GetViper(),
+		v:      v,
 		cv:     cfg,
-	}
+	}, nil
 }
 
+// BridgeValues returns the values of the configuration. They must not be
+// changed once the bridges run, a reload replaces them. Don't keep them,
+// they're outdated after a reload.
 func (c *config) BridgeValues() *BridgeValues {
+	c.RLock()
+	defer c.RUnlock()
 	return c.cv
 }
 
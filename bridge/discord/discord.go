@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/discord/transmitter"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/bwmarrin/discordgo"
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -27,6 +29,8 @@ type Bdiscord struct {
 	nick    string
 	userID  string
 	guildID string
+	// sessions is the number of sessions discordgo started since Connect
+	sessions int32
 
 	channelsMutex  sync.RWMutex
 	channels       []*discordgo.Channel
@@ -64,6 +68,15 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
+func (b *Bdiscord) EditsMessages()    {}
+func (b *Bdiscord) DeletesMessages()  {}
+func (b *Bdiscord) PreservesThreads() {}
+func (b *Bdiscord) SendsReactions()   {}
+func (b *Bdiscord) SendsTyping()      {}
+func (b *Bdiscord) UploadsFiles()     {}
+func (b *Bdiscord) ListsMembers()     {}
+func (b *Bdiscord) Backfills()        {}
+
 func (b *Bdiscord) Connect() error {
 	var err error
 	token := b.GetString("Token")
@@ -86,6 +99,8 @@ func (b *Bdiscord) Connect() error {
 	b.c.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAllWithoutPrivileged |
 		discordgo.IntentsGuildMembers)
 
+	atomic.StoreInt32(&b.sessions, 0)
+	b.c.AddHandler(b.ready)
 	err = b.c.Open()
 	if err != nil {
 		return err
@@ -228,6 +243,8 @@ func (b *Bdiscord) Connect() error {
 	b.c.AddHandler(b.messageUpdate)
 	b.c.AddHandler(b.messageDelete)
 	b.c.AddHandler(b.messageDeleteBulk)
+	b.c.AddHandler(b.messageReactionAdd)
+	b.c.AddHandler(b.messageReactionRemove)
 	b.c.AddHandler(b.memberAdd)
 	b.c.AddHandler(b.memberRemove)
 	b.c.AddHandler(b.memberUpdate)
@@ -253,6 +270,15 @@ func (b *Bdiscord) JoinChannel(channel config.ChannelInfo) error {
 func (b *Bdiscord) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		b.sendChannelMembers()
+		return "", nil
+	}
+
+	if msg.Event == config.EventBackfill {
+		return "", b.backfill(&msg)
+	}
+
 	channelID := b.getChannelID(msg.Channel)
 	if channelID == "" {
 		return "", fmt.Errorf("Could not find channelID for %v", msg.Channel)
@@ -266,6 +292,14 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg, channelID)
+	}
+
+	if doc := msg.RichText(); doc != nil {
+		msg.Text = richtext.RenderMarkdown(doc)
+	}
+
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
 		msg.Text = "_" + msg.Text + "_"
@@ -403,3 +437,16 @@ func (b *Bdiscord) handleUploadFile(msg *config.Message, channelID string) (stri
 
 	return "", nil
 }
+
+// sendReaction adds or removes the reaction of the bot to the message in ParentID.
+func (b *Bdiscord) sendReaction(msg *config.Message, channelID string) error {
+	emojiID := b.getEmojiID(msg.Reaction.Emoji)
+	if emojiID == "" {
+		b.Log.Debugf("Emoji %s not found in the guild, ignoring reaction", msg.Reaction.Emoji)
+		return nil
+	}
+	if msg.Reaction.Removed {
+		return b.c.MessageReactionRemove(channelID, msg.ParentID, emojiID, "@me")
+	}
+	return b.c.MessageReactionAdd(channelID, msg.ParentID, emojiID)
+}
@@ -1,7 +1,10 @@
 package bdiscord
 
 import (
+	"sync/atomic"
+
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/bwmarrin/discordgo"
 	"github.com/davecgh/go-spew/spew"
 )
@@ -40,6 +43,64 @@ func (b *Bdiscord) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageD
 	}
 }
 
+func (b *Bdiscord) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) { //nolint:unparam
+	var user *discordgo.User
+	if m.Member != nil {
+		user = m.Member.User
+	}
+	b.messageReaction(m.MessageReaction, user, false)
+}
+
+func (b *Bdiscord) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) { //nolint:unparam
+	b.messageReaction(m.MessageReaction, nil, true)
+}
+
+func (b *Bdiscord) messageReaction(m *discordgo.MessageReaction, user *discordgo.User, removed bool) {
+	if m.GuildID != b.guildID {
+		b.Log.Debugf("Ignoring messageReaction because it originates from a different guild")
+		return
+	}
+	// Ignore the reactions we relayed
+	if m.UserID == b.userID {
+		return
+	}
+	if user == nil {
+		var err error
+		if user, err = b.c.User(m.UserID); err != nil {
+			b.Log.Errorf("Could not get user %s of reaction: %s", m.UserID, err)
+			return
+		}
+	}
+
+	emoji := m.Emoji.Name
+	if m.Emoji.ID != "" {
+		emoji = ":" + m.Emoji.Name + ":"
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		Event:    config.EventReaction,
+		Channel:  b.getChannelName(m.ChannelID),
+		Username: b.getNick(user, m.GuildID),
+		UserID:   m.UserID,
+		ParentID: m.MessageID,
+		Reaction: &config.Reaction{Emoji: emoji, Removed: removed},
+	}
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// ready is called when discordgo started a new session. The first one is
+// started by Connect and the router backfills after connecting, the later ones
+// replace a lost connection whose events weren't resumed.
+func (b *Bdiscord) ready(s *discordgo.Session, m *discordgo.Ready) { //nolint:unparam
+	if atomic.AddInt32(&b.sessions, 1) == 1 {
+		return
+	}
+	b.Log.Info("Reconnected")
+	b.RequestBackfill()
+}
+
 func (b *Bdiscord) messageEvent(s *discordgo.Session, m *discordgo.Event) {
 	b.Log.Debug(spew.Sdump(m.Struct))
 }
@@ -83,6 +144,12 @@ func (b *Bdiscord) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdat
 }
 
 func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) { //nolint:unparam
+	b.relayMessage(m.Message, false)
+}
+
+// relayMessage sends a new message to the gateway, backfill marks the
+// messages fetched from the channel history.
+func (b *Bdiscord) relayMessage(m *discordgo.Message, backfill bool) {
 	if m.GuildID != b.guildID {
 		b.Log.Debugf("Ignoring messageCreate because it originates from a different guild")
 		return
@@ -107,14 +174,14 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 
 	rmsg := config.Message{Account: b.Account, Avatar: "https://cdn.discordapp.com/avatars/" + m.Author.ID + "/" + m.Author.Avatar + ".jpg", UserID: m.Author.ID, ID: m.ID}
 
-	b.Log.Debugf("== Receiving event %#v", m.Message)
+	b.Log.Debugf("== Receiving event %#v", m)
 	if m.WebhookID != "" {
 		b.Log.Debugf("Ignoring message from webhook ID %s", m.WebhookID)
 		return
 	}
 
 	if m.Content != "" {
-		m.Message.Content = b.replaceChannelMentions(m.Message.Content)
+		m.Content = b.replaceChannelMentions(m.Content)
 		rmsg.Text, err = m.ContentWithMoreMentionsReplaced(b.c)
 		if err != nil {
 			b.Log.Errorf("ContentWithMoreMentionsReplaced failed: %s", err)
@@ -139,8 +206,8 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	}
 
 	// if we have embedded content add it to text
-	if b.GetBool("ShowEmbeds") && m.Message.Embeds != nil {
-		for _, embed := range m.Message.Embeds {
+	if b.GetBool("ShowEmbeds") && m.Embeds != nil {
+		for _, embed := range m.Embeds {
 			rmsg.Text += handleEmbed(embed)
 		}
 	}
@@ -159,12 +226,19 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 
 	// Replace emotes
 	rmsg.Text = replaceEmotes(rmsg.Text)
+	rmsg.Formatted = richtext.ParseMarkdown(rmsg.Text)
 
 	// Add our parent id if it exists, and if it's not referring to a message in another channel
 	if ref := m.MessageReference; ref != nil && ref.ChannelID == m.ChannelID {
 		rmsg.ParentID = ref.MessageID
 	}
 
+	rmsg.Timestamp = m.Timestamp
+	if m.EditedTimestamp != nil && !backfill {
+		rmsg.Timestamp = *m.EditedTimestamp
+	}
+	rmsg.Backfill = backfill
+
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", m.Author.Username, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 	b.Remote <- rmsg
@@ -206,13 +280,20 @@ func (b *Bdiscord) memberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd)
 		b.Log.Debugf("Ignoring memberAdd because it originates from a different guild")
 		return
 	}
-	if b.GetBool("nosendjoinpart") {
-		return
-	}
 	if m.Member == nil {
 		b.Log.Warnf("Received member update with no member information: %#v", m)
 		return
 	}
+	b.membersMutex.Lock()
+	b.userMemberMap[m.Member.User.ID] = m.Member
+	b.nickMemberMap[m.Member.User.Username] = m.Member
+	if m.Member.Nick != "" {
+		b.nickMemberMap[m.Member.Nick] = m.Member
+	}
+	b.membersMutex.Unlock()
+	if b.GetBool("nosendjoinpart") {
+		return
+	}
 	username := m.Member.User.Username
 	if m.Member.Nick != "" {
 		username = m.Member.Nick
@@ -234,13 +315,20 @@ func (b *Bdiscord) memberRemove(s *discordgo.Session, m *discordgo.GuildMemberRe
 		b.Log.Debugf("Ignoring memberRemove because it originates from a different guild")
 		return
 	}
-	if b.GetBool("nosendjoinpart") {
-		return
-	}
 	if m.Member == nil {
 		b.Log.Warnf("Received member update with no member information: %#v", m)
 		return
 	}
+	b.membersMutex.Lock()
+	if member, ok := b.userMemberMap[m.Member.User.ID]; ok {
+		delete(b.nickMemberMap, member.User.Username)
+		delete(b.nickMemberMap, member.Nick)
+		delete(b.userMemberMap, m.Member.User.ID)
+	}
+	b.membersMutex.Unlock()
+	if b.GetBool("nosendjoinpart") {
+		return
+	}
 	username := m.Member.User.Username
 	if m.Member.Nick != "" {
 		username = m.Member.Nick
@@ -1,9 +1,13 @@
 package bdiscord
 
 import (
+	"io/ioutil"
 	"testing"
 
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,3 +60,21 @@ func TestHandleEmbed(t *testing.T) {
 		assert.Equalf(t, tc.result, handleEmbed(tc.embed), "Testcases %s", name)
 	}
 }
+
+func TestReadyBackfillsAfterReconnect(t *testing.T) {
+	remote := make(chan config.Message, 10)
+	br := bridge.New(&config.Bridge{Account: "discord.test"})
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br.Log = logrus.NewEntry(logger)
+	b := &Bdiscord{Config: &bridge.Config{Bridge: br, Remote: remote}}
+
+	// the session of Connect is backfilled by the router
+	b.ready(nil, &discordgo.Ready{})
+	assert.Empty(t, remote)
+
+	b.ready(nil, &discordgo.Ready{})
+	if assert.Len(t, remote, 1) {
+		assert.Equal(t, config.EventBackfill, (<-remote).Event)
+	}
+}
@@ -2,10 +2,14 @@ package bdiscord
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
+	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -64,6 +68,87 @@ func (b *Bdiscord) getNick(user *discordgo.User, guildID string) string {
 	return user.Username
 }
 
+// sendChannelMembers sends the members of the guild to the gateway, they're
+// members of all channels.
+func (b *Bdiscord) sendChannelMembers() {
+	b.membersMutex.RLock()
+	members := make(config.ChannelMembers, 0, len(b.userMemberMap))
+	for _, member := range b.userMemberMap {
+		if member.User.ID == b.userID {
+			continue
+		}
+		members = append(members, config.ChannelMember{
+			Username: member.User.Username,
+			Nick:     member.Nick,
+			UserID:   member.User.ID,
+		})
+	}
+	b.membersMutex.RUnlock()
+	b.SendChannelMembers(members)
+}
+
+// discordEpoch is the time in milliseconds the snowflake IDs count from.
+const discordEpoch = 1420070400000
+
+// backfill relays the messages of the channel of an EventBackfill that were
+// posted after the message with its ID.
+func (b *Bdiscord) backfill(msg *config.Message) error {
+	channelID := b.getChannelID(msg.Channel)
+	if channelID == "" {
+		return fmt.Errorf("could not find channelID for %v", msg.Channel)
+	}
+	after := msg.ID
+	if after == "" {
+		// a snowflake starts with its time
+		after = strconv.FormatInt((msg.Timestamp.UnixMilli()-discordEpoch)<<22, 10)
+	}
+	var missed []*discordgo.Message
+	for limit := b.BackfillLimit(); len(missed) < limit; {
+		n := limit - len(missed)
+		if n > 100 {
+			n = 100
+		}
+		msgs, err := b.c.ChannelMessages(channelID, n, "", after, "")
+		if err != nil {
+			return err
+		}
+		sort.Slice(msgs, func(i, j int) bool {
+			return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+		})
+		missed = append(missed, msgs...)
+		if len(msgs) < n {
+			break
+		}
+		after = msgs[len(msgs)-1].ID
+	}
+	b.Log.Debugf("Backfilling %d messages of %s", len(missed), msg.Channel)
+	for _, m := range missed {
+		// the messages of the REST API don't have it
+		m.GuildID = b.guildID
+		b.relayMessage(m, true)
+	}
+	return nil
+}
+
+// getEmojiID returns the ID used by the API for a unicode emoji or the :name:
+// of a custom emoji of the guild. Returns an empty string for unknown custom emoji.
+func (b *Bdiscord) getEmojiID(emoji string) string {
+	if !strings.HasPrefix(emoji, ":") {
+		return emoji
+	}
+	guild, err := b.c.State.Guild(b.guildID)
+	if err != nil {
+		return ""
+	}
+	name := strings.Trim(emoji, ":")
+	for _, e := range guild.Emojis {
+		if e.Name == name {
+			return e.APIName()
+		}
+	}
+	return ""
+}
+
 func (b *Bdiscord) getGuildMemberByNick(nick string) (*discordgo.Member, error) {
 	b.membersMutex.RLock()
 	defer b.membersMutex.RUnlock()
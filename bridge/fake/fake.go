@@ -0,0 +1,227 @@
+// Package bfake is an in-memory bridge for tests. It records the messages
+// the gateway sends to it and sends the messages given to Receive to the
+// gateway, so the message flow can be tested without any chat service.
+//
+// Messages given to Receive and Post make up the history of their channel,
+// EventBackfill sends the messages after the requested one to the gateway.
+// It gets edits, deletes, replies and files like the bridges supporting them.
+//
+// Build matterbridge with the fake tag to use it as the fake protocol in a
+// configuration.
+package bfake
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// ErrSend is returned by Send for the failures set with FailSends.
+var ErrSend = errors.New("fake send failure")
+
+// lastID makes the message ID's unique over all fake bridges, the gateway
+// keys them by protocol.
+var lastID int64
+
+type Bfake struct {
+	*bridge.Config
+
+	sync.Mutex
+	sent         []config.Message
+	history      map[string][]config.Message
+	ids          []string
+	joined       []string
+	connected    bool
+	connectErr   error
+	sendFailures int
+	delay        time.Duration
+}
+
+func New(cfg *bridge.Config) bridge.Bridger {
+	return &Bfake{Config: cfg, history: make(map[string][]config.Message)}
+}
+
+func (b *Bfake) EditsMessages()    {}
+func (b *Bfake) DeletesMessages()  {}
+func (b *Bfake) PreservesThreads() {}
+func (b *Bfake) UploadsFiles()     {}
+func (b *Bfake) Backfills()        {}
+
+func (b *Bfake) Connect() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.connectErr != nil {
+		return b.connectErr
+	}
+	b.connected = true
+	return nil
+}
+
+func (b *Bfake) Disconnect() error {
+	b.Lock()
+	defer b.Unlock()
+	b.connected = false
+	return nil
+}
+
+func (b *Bfake) JoinChannel(channel config.ChannelInfo) error {
+	b.Lock()
+	defer b.Unlock()
+	b.joined = append(b.joined, channel.Name)
+	return nil
+}
+
+// Send records msg and returns a new message ID, or the ID of msg for edits
+// and deletes.
+func (b *Bfake) Send(msg config.Message) (string, error) {
+	if msg.Event == config.EventBackfill {
+		b.backfill(msg.Channel, msg.ID)
+		return "", nil
+	}
+
+	b.Lock()
+	delay := b.delay
+	b.Unlock()
+	time.Sleep(delay)
+
+	b.Lock()
+	defer b.Unlock()
+	if b.sendFailures > 0 {
+		b.sendFailures--
+		return "", ErrSend
+	}
+	b.Log.Debugf("=> Receiving %#v", msg)
+	id := msg.ID
+	if id == "" {
+		id = strconv.FormatInt(atomic.AddInt64(&lastID, 1), 10)
+	}
+	b.sent = append(b.sent, msg)
+	b.ids = append(b.ids, id)
+	return id, nil
+}
+
+// Receive sends msg to the gateway as a message of the bridge on channel.
+// The Account of msg is set, an empty ID is replaced by a new one. Returns
+// the ID of msg.
+func (b *Bfake) Receive(channel string, msg config.Message) string {
+	msg = b.record(channel, msg)
+	b.Remote <- msg
+	return msg.ID
+}
+
+// Post adds msg to the history of channel without sending it to the gateway,
+// like a message posted while the bridge was disconnected. Returns the ID of
+// msg.
+func (b *Bfake) Post(channel string, msg config.Message) string {
+	return b.record(channel, msg).ID
+}
+
+// record completes msg and adds new messages to the history of channel.
+func (b *Bfake) record(channel string, msg config.Message) config.Message {
+	msg.Account = b.Account
+	msg.Channel = channel
+	if msg.Event != "" {
+		return msg
+	}
+	if msg.ID == "" {
+		msg.ID = strconv.FormatInt(atomic.AddInt64(&lastID, 1), 10)
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.history[channel] = append(b.history[channel], msg)
+	return msg
+}
+
+// backfill sends the messages of the history of channel after the message
+// with the ID to the gateway, at most BackfillLimit.
+func (b *Bfake) backfill(channel, id string) {
+	b.Lock()
+	var missed []config.Message
+	for i, msg := range b.history[channel] {
+		if msg.ID == id {
+			missed = append(missed, b.history[channel][i+1:]...)
+			break
+		}
+	}
+	b.Unlock()
+	if limit := b.BackfillLimit(); len(missed) > limit {
+		missed = missed[len(missed)-limit:]
+	}
+	for _, msg := range missed {
+		msg.Backfill = true
+		b.Remote <- msg
+	}
+}
+
+// Sent returns the messages sent to the bridge.
+func (b *Bfake) Sent() []config.Message {
+	b.Lock()
+	defer b.Unlock()
+	return append([]config.Message(nil), b.sent...)
+}
+
+// SentIDs returns the message ID's returned by Send, in the order of Sent.
+func (b *Bfake) SentIDs() []string {
+	b.Lock()
+	defer b.Unlock()
+	return append([]string(nil), b.ids...)
+}
+
+// WaitSent waits until n messages are sent to the bridge and returns them,
+// or returns nil after the timeout.
+func (b *Bfake) WaitSent(n int, timeout time.Duration) []config.Message {
+	deadline := time.Now().Add(timeout)
+	for {
+		if sent := b.Sent(); len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Joined returns the names of the channels joined by the bridge.
+func (b *Bfake) Joined() []string {
+	b.Lock()
+	defer b.Unlock()
+	return append([]string(nil), b.joined...)
+}
+
+// IsConnected returns true between Connect and Disconnect.
+func (b *Bfake) IsConnected() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.connected
+}
+
+// FailConnect makes Connect return err, nil lets it succeed again.
+func (b *Bfake) FailConnect(err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.connectErr = err
+}
+
+// FailSends makes the next n calls of Send return ErrSend.
+func (b *Bfake) FailSends(n int) {
+	b.Lock()
+	defer b.Unlock()
+	b.sendFailures = n
+}
+
+// SetDelay makes Send wait for d before it does anything, like a slow chat
+// service.
+func (b *Bfake) SetDelay(d time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.delay = d
+}
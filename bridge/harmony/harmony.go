@@ -151,6 +151,8 @@ func (b *Bharmony) GetUint64(conf string) uint64 {
 	return num
 }
 
+func (b *Bharmony) DeletesMessages() {}
+
 func (b *Bharmony) Connect() (err error) {
 	b.c, err = shibshib.NewClient(b.GetString("Homeserver"), b.GetString("Token"), b.GetUint64("UserID"))
 	if err != nil {
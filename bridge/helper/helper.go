@@ -2,6 +2,9 @@ package helper
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image/png"
 	"io"
@@ -17,6 +20,7 @@ import (
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
+	"github.com/kyokomi/emoji/v2"
 	"github.com/sirupsen/logrus"
 )
 
@@ -138,11 +142,35 @@ func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message
 // GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
 func GetAvatar(av map[string]string, userid string, general *config.Protocol) string {
 	if sha, ok := av[userid]; ok {
-		return general.MediaServerDownload + "/" + sha + "/" + userid + ".png"
+		return MediaURL(general, sha+"/"+userid+".png")
 	}
 	return ""
 }
 
+// MediaURL returns the URL of the file stored under key ("<sha>/<name>") on
+// MediaServerDownload. The URL is signed and expires after MediaServerURLExpiry
+// hours when MediaServerSigningKey is configured.
+func MediaURL(general *config.Protocol, key string) string {
+	url := general.MediaServerDownload + "/" + key
+	if general.MediaServerSigningKey == "" {
+		return url
+	}
+	expiry := time.Duration(general.MediaServerURLExpiry) * time.Hour
+	if expiry == 0 {
+		expiry = 24 * time.Hour
+	}
+	expires := time.Now().Add(expiry).Unix()
+	return fmt.Sprintf("%s?expires=%d&signature=%s", url, expires, MediaSignature(general.MediaServerSigningKey, key, expires))
+}
+
+// MediaSignature returns the signature of a media URL for the key that
+// expires at the unix time expires.
+func MediaSignature(signingKey, key string, expires int64) string {
+	h := hmac.New(sha256.New, []byte(signingKey))
+	fmt.Fprintf(h, "%s\n%d", key, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // HandleDownloadSize checks a specified filename against the configured download blacklist
 // and checks a specified file-size against the configure limit.
 func HandleDownloadSize(logger *logrus.Entry, msg *config.Message, name string, size int64, general *config.Protocol) error {
@@ -270,6 +298,32 @@ func ParseMarkdown(input string) string {
 	return res
 }
 
+// EmojiName returns the short name without colons of an unicode emoji, like
+// "+1" for "👍", as used by the reactions of Slack and Mattermost.
+// Custom emoji like ":party:" are returned without the colons.
+func EmojiName(e string) string {
+	codes := emoji.RevCodeMap()
+	// prefer the emoji presentation, it has the common names like "heart"
+	names, ok := codes[strings.TrimSuffix(e, "\ufe0f")+"\ufe0f"]
+	if !ok {
+		names = codes[e]
+	}
+	if len(names) > 0 {
+		return strings.Trim(names[0], ":")
+	}
+	return strings.Trim(e, ":")
+}
+
+// EmojiFromName returns the unicode emoji for a short name like "+1" or
+// ":+1:", or the :name: when it's a custom emoji. Skin tones are dropped.
+func EmojiFromName(name string) string {
+	name = strings.SplitN(strings.Trim(name, ":"), "::", 2)[0]
+	if e, ok := emoji.CodeMap()[":"+name+":"]; ok {
+		return e
+	}
+	return ":" + name + ":"
+}
+
 // ConvertWebPToPNG converts input data (which should be WebP format) to PNG format
 func ConvertWebPToPNG(data *[]byte) error {
 	r := bytes.NewReader(*data)
@@ -1,10 +1,14 @@
 package helper
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -236,3 +240,29 @@ func TestClipOrSplitMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestEmojiName(t *testing.T) {
+	assert.Equal(t, "+1", EmojiName("\U0001f44d"))
+	assert.Equal(t, "heart", EmojiName("\u2764"))
+	assert.Equal(t, "party", EmojiName(":party:"))
+
+	assert.Equal(t, "\U0001f44d", EmojiFromName("thumbsup"))
+	assert.Equal(t, "\U0001f44d", EmojiFromName(":+1::skin-tone-2:"))
+	assert.Equal(t, ":party:", EmojiFromName("party"))
+	assert.Equal(t, "\U0001f44d", EmojiFromName(EmojiName("\U0001f44d")))
+}
+
+func TestMediaURL(t *testing.T) {
+	general := &config.Protocol{MediaServerDownload: "https://example.com/media"}
+	assert.Equal(t, "https://example.com/media/1234abcd/cat.png", MediaURL(general, "1234abcd/cat.png"))
+
+	general.MediaServerSigningKey = "key"
+	general.MediaServerURLExpiry = 1
+	expires := time.Now().Add(time.Hour).Unix()
+	url := MediaURL(general, "1234abcd/cat.png")
+	assert.Regexp(t, `^https://example.com/media/1234abcd/cat.png\?expires=\d+&signature=[0-9a-f]{64}$`, url)
+	assert.Contains(t, []string{
+		fmt.Sprintf("expires=%d&signature=%s", expires, MediaSignature("key", "1234abcd/cat.png", expires)),
+		fmt.Sprintf("expires=%d&signature=%s", expires+1, MediaSignature("key", "1234abcd/cat.png", expires+1)),
+	}, strings.SplitN(url, "?", 2)[1])
+}
@@ -9,7 +9,7 @@ import (
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/lrstanley/girc"
 	"github.com/paulrosania/go-charset/charset"
 	"github.com/saintfish/chardet"
@@ -39,33 +39,6 @@ func (b *Birc) handleCharset(msg *config.Message) error {
 	return nil
 }
 
-// handleFiles returns true if we have handled the files, otherwise return false
-func (b *Birc) handleFiles(msg *config.Message) bool {
-	if msg.Extra == nil {
-		return false
-	}
-	for _, rmsg := range helper.HandleExtra(msg, b.General) {
-		b.Local <- rmsg
-	}
-	if len(msg.Extra["file"]) == 0 {
-		return false
-	}
-	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + " : "
-		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + " : " + fi.URL
-			}
-		}
-		b.Local <- config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event}
-	}
-	return true
-}
-
 func (b *Birc) handleInvite(client *girc.Client, event girc.Event) {
 	if len(event.Params) != 2 {
 		return
@@ -250,6 +223,10 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		output, _ := ioutil.ReadAll(r)
 		rmsg.Text = string(output)
 	}
+	// the text is passed on without the formatting codes
+	rmsg.Formatted = richtext.ParseIRC(rmsg.Text)
+	rmsg.Text = rmsg.Formatted.Plain()
+	rmsg.Formatted.Source = rmsg.Text
 
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", event.Params[0], b.Account)
 	b.Remote <- rmsg
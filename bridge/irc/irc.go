@@ -15,6 +15,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/lrstanley/girc"
 	stripmd "github.com/writeas/go-strip-markdown"
 
@@ -72,6 +73,15 @@ func (b *Birc) Command(msg *config.Message) string {
 	return ""
 }
 
+func (b *Birc) ReactionsAsText() {}
+func (b *Birc) SendsNotices()    {}
+func (b *Birc) ListsMembers()    {}
+
+// FileCommentSeparator returns the separator irc always used for files.
+func (b *Birc) FileCommentSeparator() string {
+	return " : "
+}
+
 func (b *Birc) Connect() error {
 	if b.GetBool("UseSASL") && b.GetString("TLSClientCertificate") != "" {
 		return errors.New("you can't enable SASL and TLSClientCertificate at the same time")
@@ -138,11 +148,6 @@ func (b *Birc) JoinChannel(channel config.ChannelInfo) error {
 }
 
 func (b *Birc) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
-
 	b.Log.Debugf("=> Receiving %#v", msg)
 
 	// we can be in between reconnects #385
@@ -151,25 +156,40 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	if msg.Event == config.EventGetChannelMembers {
+		b.sendChannelMembers()
+		return "", nil
+	}
+
 	// Execute a command
 	if strings.HasPrefix(msg.Text, "!") {
 		b.Command(&msg)
 	}
 
+	// render the formatting before the charset conversion changes the text
+	if doc := msg.RichText(); doc != nil {
+		if b.GetBool("StripMarkdown") {
+			msg.Text = doc.Plain()
+		} else {
+			msg.Text = richtext.RenderIRC(doc)
+		}
+	} else if b.GetBool("StripMarkdown") {
+		msg.Text = stripmd.Strip(msg.Text)
+	}
+
 	// convert to specified charset
 	if err := b.handleCharset(&msg); err != nil {
 		return "", err
 	}
 
-	// handle files, return if we're done here
-	if ok := b.handleFiles(&msg); ok {
-		return "", nil
+	// tell about the files that were too big to relay
+	if msg.Extra != nil {
+		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
+			b.Local <- rmsg
+		}
 	}
 
 	var msgLines []string
-	if b.GetBool("StripMarkdown") {
-		msg.Text = stripmd.Strip(msg.Text)
-	}
 
 	if b.GetBool("MessageSplit") {
 		msgLines = helper.GetSubLines(msg.Text, b.MessageLength, b.GetString("MessageClipped"))
@@ -349,6 +369,29 @@ func (b *Birc) endNames(client *girc.Client, event girc.Event) {
 	b.i.Handlers.Clear(girc.RPL_ENDOFNAMES)
 }
 
+// sendChannelMembers sends the users in the channels we joined to the
+// gateway, like girc tracks them from NAMES and the joins and parts.
+func (b *Birc) sendChannelMembers() {
+	members := config.ChannelMembers{}
+	nick := b.i.GetNick()
+	for _, channel := range b.i.Channels() {
+		name := strings.ToLower(channel.Name)
+		for _, user := range channel.Users(b.i) {
+			if user.Nick == nick {
+				continue
+			}
+			members = append(members, config.ChannelMember{
+				Username:    user.Nick,
+				Nick:        user.Nick,
+				UserID:      user.Ident + "@" + user.Host,
+				ChannelID:   name,
+				ChannelName: name,
+			})
+		}
+	}
+	b.SendChannelMembers(members)
+}
+
 func (b *Birc) skipPrivMsg(event girc.Event) bool {
 	// Our nick can be changed
 	b.Nick = b.i.GetNick()
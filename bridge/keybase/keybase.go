@@ -27,6 +27,8 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
+func (b *Bkeybase) UploadsFiles() {}
+
 // Connect starts keybase API and listener loop
 func (b *Bkeybase) Connect() error {
 	var err error
@@ -8,6 +8,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	matrix "github.com/matterbridge/gomatrix"
 )
 
@@ -40,6 +43,95 @@ func (b *Bmatrix) getRoomID(channel string) string {
 	return ""
 }
 
+// sendChannelMembers sends the joined members of the rooms to the gateway.
+func (b *Bmatrix) sendChannelMembers() error {
+	b.RLock()
+	rooms := make(map[string]string, len(b.RoomMap))
+	for ID, name := range b.RoomMap {
+		rooms[ID] = name
+	}
+	b.RUnlock()
+
+	members := config.ChannelMembers{}
+	for roomID, name := range rooms {
+		resp, err := b.mc.JoinedMembers(roomID)
+		if err != nil {
+			return fmt.Errorf("getting the members of %s failed: %w", name, err)
+		}
+		for mxid, member := range resp.Joined {
+			if mxid == b.UserID {
+				continue
+			}
+			nick := ""
+			if member.DisplayName != nil {
+				nick = *member.DisplayName
+			}
+			members = append(members, config.ChannelMember{
+				Username:    localpart(mxid),
+				Nick:        nick,
+				UserID:      mxid,
+				ChannelID:   roomID,
+				ChannelName: name,
+			})
+		}
+	}
+	b.SendChannelMembers(members)
+	return nil
+}
+
+// backfill relays the messages of the room that were sent after the message
+// with the ID of msg, or after its timestamp.
+func (b *Bmatrix) backfill(msg *config.Message, roomID string) error {
+	if roomID == "" {
+		return fmt.Errorf("could not find room for %v", msg.Channel)
+	}
+	limit := b.BackfillLimit()
+	since := msg.Timestamp.UnixMilli()
+	var missed []*matrix.Event
+	// the history is paginated backwards, from the newest event
+	from := ""
+	for done := false; !done && len(missed) < limit; {
+		resp, err := b.mc.Messages(roomID, from, "", 'b', limit)
+		if err != nil {
+			return err
+		}
+		for i := range resp.Chunk {
+			ev := &resp.Chunk[i]
+			if ev.ID == msg.ID || ev.Timestamp <= since || len(missed) == limit {
+				done = true
+				break
+			}
+			if ev.Type == "m.room.message" {
+				ev.RoomID = roomID
+				missed = append(missed, ev)
+			}
+		}
+		if len(resp.Chunk) == 0 || resp.End == "" || resp.End == from {
+			break
+		}
+		from = resp.End
+	}
+	b.Log.Debugf("Backfilling %d messages of %s", len(missed), msg.Channel)
+	for i := len(missed) - 1; i >= 0; i-- {
+		b.relayEvent(missed[i], true)
+	}
+	return nil
+}
+
+// localpart returns the user name of a Matrix user ID like @user:example.org.
+func localpart(mxid string) string {
+	return strings.TrimPrefix(strings.SplitN(mxid, ":", 2)[0], "@")
+}
+
+// messageText returns the plain text and the HTML of the message, rendered from
+// its formatting when the bridge it came from parsed it.
+func messageText(msg *config.Message) (string, string) {
+	if doc := msg.RichText(); doc != nil {
+		return doc.Plain(), richtext.RenderHTML(doc, "<br>")
+	}
+	return msg.Text, helper.ParseMarkdown(msg.Text)
+}
+
 // interface2Struct marshals and immediately unmarshals an interface.
 // Useful for converting map[string]interface{} to a struct.
 func interface2Struct(in interface{}, out interface{}) error {
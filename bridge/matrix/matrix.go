@@ -12,6 +12,8 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
+	lru "github.com/hashicorp/golang-lru"
 	matrix "github.com/matterbridge/gomatrix"
 )
 
@@ -20,6 +22,10 @@ var (
 	htmlReplacementTag = regexp.MustCompile("<[^>]*>")
 )
 
+// typingTimeout is how long we show as typing for a typing event, unless
+// the next event comes first.
+const typingTimeout = 10 * time.Second
+
 type NicknameCacheEntry struct {
 	displayName string
 	lastUpdated time.Time
@@ -30,7 +36,10 @@ type Bmatrix struct {
 	UserID      string
 	NicknameMap map[string]NicknameCacheEntry
 	RoomMap     map[string]string
-	rateMutex   sync.RWMutex
+	// reactions maps the reactions we sent to their event ID and the event
+	// ID of the reactions we received to the reaction, to handle redactions.
+	reactions *lru.Cache
+	rateMutex sync.RWMutex
 	sync.RWMutex
 	*bridge.Config
 }
@@ -80,13 +89,41 @@ type ReplyMessage struct {
 	matrix.TextMessage
 }
 
+// AnnotationRelation is the relation of a reaction to the reacted message.
+type AnnotationRelation struct {
+	EventID string `json:"event_id"`
+	Type    string `json:"rel_type"`
+	Key     string `json:"key"`
+}
+
+type ReactionMessage struct {
+	RelatedTo AnnotationRelation `json:"m.relates_to"`
+}
+
+// receivedReaction is a reaction received from matrix.
+type receivedReaction struct {
+	channel string
+	target  string
+	key     string
+}
+
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmatrix{Config: cfg}
 	b.RoomMap = make(map[string]string)
 	b.NicknameMap = make(map[string]NicknameCacheEntry)
+	b.reactions, _ = lru.New(5000)
 	return b
 }
 
+func (b *Bmatrix) EditsMessages()    {}
+func (b *Bmatrix) DeletesMessages()  {}
+func (b *Bmatrix) PreservesThreads() {}
+func (b *Bmatrix) SendsReactions()   {}
+func (b *Bmatrix) SendsTyping()      {}
+func (b *Bmatrix) UploadsFiles()     {}
+func (b *Bmatrix) ListsMembers()     {}
+func (b *Bmatrix) Backfills()        {}
+
 func (b *Bmatrix) Connect() error {
 	var err error
 	b.Log.Infof("Connecting %s", b.GetString("Server"))
@@ -143,13 +180,34 @@ func (b *Bmatrix) JoinChannel(channel config.ChannelInfo) error {
 func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		return "", b.sendChannelMembers()
+	}
+
 	channel := b.getRoomID(msg.Channel)
 	b.Log.Debugf("Channel %s maps to channel id %s", msg.Channel, channel)
 
+	if msg.Event == config.EventBackfill {
+		return "", b.backfill(&msg, channel)
+	}
+
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg, channel)
+	}
+
+	if msg.Event == config.EventUserTyping {
+		if b.GetBool("ShowUserTyping") {
+			_, err := b.mc.UserTyping(channel, true, typingTimeout.Milliseconds())
+			return "", err
+		}
+		return "", nil
+	}
+
 	username := newMatrixUsername(msg.Username)
 
-	body := username.plain + msg.Text
-	formattedBody := username.formatted + helper.ParseMarkdown(msg.Text)
+	text, formattedText := messageText(&msg)
+	body := username.plain + text
+	formattedBody := username.formatted + formattedText
 
 	if b.GetBool("SpoofUsername") {
 		// https://spec.matrix.org/v1.3/client-server-api/#mroommember
@@ -168,8 +226,8 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 
 		_, err := b.mc.SendStateEvent(channel, "m.room.member", b.UserID, m)
 		if err == nil {
-			body = msg.Text
-			formattedBody = helper.ParseMarkdown(msg.Text)
+			body = text
+			formattedBody = formattedText
 		}
 	}
 
@@ -396,7 +454,9 @@ func (b *Bmatrix) handlematrix() {
 	syncer := b.mc.Syncer.(*matrix.DefaultSyncer)
 	syncer.OnEventType("m.room.redaction", b.handleEvent)
 	syncer.OnEventType("m.room.message", b.handleEvent)
+	syncer.OnEventType("m.reaction", b.handleReaction)
 	syncer.OnEventType("m.room.member", b.handleMemberChange)
+	syncer.OnEventType("m.typing", b.handleTyping)
 	go func() {
 		for {
 			if b == nil {
@@ -404,6 +464,8 @@ func (b *Bmatrix) handlematrix() {
 			}
 			if err := b.mc.Sync(); err != nil {
 				b.Log.Println("Sync() returned ", err)
+				// the events of a gap in the timeline aren't synced
+				b.RequestBackfill()
 			}
 		}
 	}()
@@ -434,6 +496,10 @@ func (b *Bmatrix) handleEdit(ev *matrix.Event, rmsg config.Message) bool {
 
 	rmsg.ID = relation.EventID
 	rmsg.Text = newContent.Body
+	if newContent.Format == "org.matrix.custom.html" {
+		rmsg.Formatted = richtext.ParseHTML(newContent.FormattedBody)
+		rmsg.Formatted.Source = rmsg.Text
+	}
 	b.Remote <- rmsg
 
 	return true
@@ -464,6 +530,10 @@ func (b *Bmatrix) handleReply(ev *matrix.Event, rmsg config.Message) bool {
 		}
 	}
 
+	if rmsg.Formatted != nil && !b.GetBool("keepquotedreply") {
+		// the reply fallback isn't parsed
+		rmsg.Formatted.Source = body
+	}
 	rmsg.Text = body
 	rmsg.ParentID = relation.InReplyTo.EventID
 	b.Remote <- rmsg
@@ -480,8 +550,35 @@ func (b *Bmatrix) handleMemberChange(ev *matrix.Event) {
 	}
 }
 
+// handleTyping sends a typing event when others than us are typing. m.typing
+// lists the users typing in the room.
+func (b *Bmatrix) handleTyping(ev *matrix.Event) {
+	if !b.GetBool("ShowUserTyping") {
+		return
+	}
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		return
+	}
+	userIDs, _ := ev.Content["user_ids"].([]interface{})
+	for _, userID := range userIDs {
+		if userID != b.UserID {
+			b.Remote <- config.Message{Account: b.Account, Event: config.EventUserTyping, Channel: channel}
+			return
+		}
+	}
+}
+
 func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 	b.Log.Debugf("== Receiving event: %#v", ev)
+	b.relayEvent(ev, false)
+}
+
+// relayEvent sends the message of ev to the gateway, backfill is true for the
+// events of the room history that were missed.
+func (b *Bmatrix) relayEvent(ev *matrix.Event, backfill bool) {
 	if ev.Sender != b.UserID {
 		b.RLock()
 		channel, ok := b.RoomMap[ev.RoomID]
@@ -493,12 +590,14 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 
 		// Create our message
 		rmsg := config.Message{
-			Username: b.getDisplayName(ev.Sender),
-			Channel:  channel,
-			Account:  b.Account,
-			UserID:   ev.Sender,
-			ID:       ev.ID,
-			Avatar:   b.getAvatarURL(ev.Sender),
+			Username:  b.getDisplayName(ev.Sender),
+			Channel:   channel,
+			Account:   b.Account,
+			UserID:    ev.Sender,
+			ID:        ev.ID,
+			Avatar:    b.getAvatarURL(ev.Sender),
+			Timestamp: time.UnixMilli(ev.Timestamp),
+			Backfill:  backfill,
 		}
 
 		// Remove homeserver suffix if configured
@@ -509,6 +608,13 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 
 		// Delete event
 		if ev.Type == "m.room.redaction" {
+			// removing a reaction redacts the reaction event
+			if r, ok := b.reactions.Get(ev.Redacts); ok {
+				reaction := r.(receivedReaction)
+				b.reactions.Remove(ev.Redacts)
+				b.sendReactionToGateway(ev, reaction, true)
+				return
+			}
 			rmsg.Event = config.EventMsgDelete
 			rmsg.ID = ev.Redacts
 			rmsg.Text = config.EventMsgDelete
@@ -523,6 +629,13 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 			return
 		}
 
+		if format, _ := ev.Content["format"].(string); format == "org.matrix.custom.html" {
+			if formatted, ok := ev.Content["formatted_body"].(string); ok {
+				rmsg.Formatted = richtext.ParseHTML(formatted)
+				rmsg.Formatted.Source = rmsg.Text
+			}
+		}
+
 		// Do we have a /me action
 		if ev.Content["msgtype"].(string) == "m.emote" {
 			rmsg.Event = config.EventUserAction
@@ -556,6 +669,74 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 	}
 }
 
+// handleReaction handles m.reaction events.
+func (b *Bmatrix) handleReaction(ev *matrix.Event) {
+	b.Log.Debugf("== Receiving event: %#v", ev)
+	if ev.Sender == b.UserID {
+		return
+	}
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		b.Log.Debugf("Unknown room %s", ev.RoomID)
+		return
+	}
+	var content ReactionMessage
+	if err := interface2Struct(ev.Content, &content); err != nil || content.RelatedTo.Type != "m.annotation" {
+		return
+	}
+	reaction := receivedReaction{channel: channel, target: content.RelatedTo.EventID, key: content.RelatedTo.Key}
+	b.reactions.Add(ev.ID, reaction)
+	b.sendReactionToGateway(ev, reaction, false)
+}
+
+func (b *Bmatrix) sendReactionToGateway(ev *matrix.Event, reaction receivedReaction, removed bool) {
+	rmsg := config.Message{
+		Username: b.getDisplayName(ev.Sender),
+		Channel:  reaction.channel,
+		Account:  b.Account,
+		UserID:   ev.Sender,
+		Event:    config.EventReaction,
+		ParentID: reaction.target,
+		Reaction: &config.Reaction{Emoji: reaction.key, Removed: removed},
+	}
+	b.Log.Debugf("<= Sending reaction from %s on %s to gateway", ev.Sender, b.Account)
+	b.Remote <- rmsg
+}
+
+// sendReaction sends the reaction to the message in ParentID, or redacts the
+// reaction we sent before when it's removed.
+func (b *Bmatrix) sendReaction(msg *config.Message, channel string) error {
+	key := channel + " " + msg.ParentID + " " + msg.Reaction.Emoji
+	if msg.Reaction.Removed {
+		eventID, ok := b.reactions.Get(key)
+		if !ok {
+			return nil
+		}
+		b.reactions.Remove(key)
+		return b.retry(func() error {
+			_, err := b.mc.RedactEvent(channel, eventID.(string), &matrix.ReqRedact{})
+			return err
+		})
+	}
+	m := ReactionMessage{
+		RelatedTo: AnnotationRelation{
+			EventID: msg.ParentID,
+			Type:    "m.annotation",
+			Key:     msg.Reaction.Emoji,
+		},
+	}
+	return b.retry(func() error {
+		resp, err := b.mc.SendMessageEvent(channel, "m.reaction", m)
+		if err != nil {
+			return err
+		}
+		b.reactions.Add(key, resp.EventID)
+		return nil
+	})
+}
+
 // handleDownloadFile handles file download
 func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content map[string]interface{}) error {
 	var (
@@ -26,3 +26,8 @@ func TestFancyUsername(t *testing.T) {
 	assert.Equal(t, "&lt;MyUser&gt;", uut.formatted)
 	assert.Equal(t, "<MyUser>", uut.plain)
 }
+
+func TestLocalpart(t *testing.T) {
+	assert.Equal(t, "alice", localpart("@alice:example.org"))
+	assert.Equal(t, "bob", localpart("@bob:example.org:8448"))
+}
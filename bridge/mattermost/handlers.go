@@ -2,9 +2,12 @@ package bmattermost
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/matterbridge/matterclient"
 	"github.com/mattermost/mattermost/server/public/model"
 )
@@ -63,7 +66,7 @@ func (b *Bmattermost) handleDownloadFile(rmsg *config.Message, id string) error
 }
 
 func (b *Bmattermost) handleMatter() {
-	messages := make(chan *config.Message)
+	messages := b.messages
 	if b.GetString("WebhookBindAddress") != "" {
 		b.Log.Debugf("Choosing webhooks based receiving")
 		go b.handleMatterHook(messages)
@@ -87,71 +90,175 @@ func (b *Bmattermost) handleMatter() {
 		if ok {
 			message.Event = config.EventUserAction
 		}
+		if message.Event == "" || message.Event == config.EventUserAction {
+			message.Formatted = richtext.ParseMarkdown(message.Text)
+		}
 		b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
 		b.Log.Debugf("<= Message is %#v", message)
 		b.Remote <- *message
 	}
 }
 
-//nolint:cyclop
 func (b *Bmattermost) handleMatterClient(messages chan *config.Message) {
 	for message := range b.mc.MessageChan {
 		b.Log.Debugf("%#v %#v", message.Raw.GetData(), message.Raw.EventType())
 
-		if b.skipMessage(message) {
-			b.Log.Debugf("Skipped message: %#v", message)
+		if message.Raw.EventType() == model.WebsocketEventTyping {
+			if rmsg := b.handleTypingEvent(message); rmsg != nil {
+				messages <- rmsg
+			}
 			continue
 		}
 
-		channelName := b.getChannelName(message.Post.ChannelId)
-		if channelName == "" {
-			channelName = message.Channel
+		if message.Raw.EventType() == model.WebsocketEventReactionAdded ||
+			message.Raw.EventType() == model.WebsocketEventReactionRemoved {
+			if rmsg := b.handleReactionEvent(message); rmsg != nil {
+				messages <- rmsg
+			}
+			continue
 		}
 
-		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || b.General.MediaDownloadPath != "" {
-			b.handleDownloadAvatar(message.UserID, channelName)
+		if rmsg := b.handlePostEvent(message); rmsg != nil {
+			messages <- rmsg
 		}
+	}
+}
 
-		b.Log.Debugf("== Receiving event %#v", message)
+// handlePostEvent returns the message for a posted, edited or deleted post, or
+// nil if it must be ignored.
+//
+//nolint:cyclop
+func (b *Bmattermost) handlePostEvent(message *matterclient.Message) *config.Message {
+	if b.skipMessage(message) {
+		b.Log.Debugf("Skipped message: %#v", message)
+		return nil
+	}
 
-		rmsg := &config.Message{
-			Username: message.Username,
-			UserID:   message.UserID,
-			Channel:  channelName,
-			Text:     message.Text,
-			ID:       message.Post.Id,
-			ParentID: message.Post.RootId, // ParentID is obsolete with mattermost
-			Extra:    make(map[string][]interface{}),
-		}
+	channelName := b.getChannelName(message.Post.ChannelId)
+	if channelName == "" {
+		channelName = message.Channel
+	}
+
+	// only download avatars if we have a place to upload them (configured mediaserver)
+	if b.General.MediaServerUpload != "" || b.General.MediaDownloadPath != "" || b.General.MediaStore != "" {
+		b.handleDownloadAvatar(message.UserID, channelName)
+	}
 
-		// handle mattermost post properties (override username and attachments)
-		b.handleProps(rmsg, message)
+	b.Log.Debugf("== Receiving event %#v", message)
 
-		// create a text for bridges that don't support native editing
-		if message.Raw.EventType() == model.WebsocketEventPostEdited && !b.GetBool("EditDisable") {
-			rmsg.Text = message.Text + b.GetString("EditSuffix")
-		}
+	rmsg := &config.Message{
+		Username:  message.Username,
+		UserID:    message.UserID,
+		Channel:   channelName,
+		Text:      message.Text,
+		ID:        message.Post.Id,
+		ParentID:  message.Post.RootId, // ParentID is obsolete with mattermost
+		Timestamp: time.UnixMilli(message.Post.CreateAt),
+		Extra:     make(map[string][]interface{}),
+	}
+
+	// handle mattermost post properties (override username and attachments)
+	b.handleProps(rmsg, message)
+
+	// create a text for bridges that don't support native editing
+	if message.Raw.EventType() == model.WebsocketEventPostEdited && !b.GetBool("EditDisable") {
+		rmsg.Text = message.Text + b.GetString("EditSuffix")
+		rmsg.Timestamp = time.UnixMilli(message.Post.EditAt)
+	}
+
+	if message.Raw.EventType() == model.WebsocketEventPostDeleted {
+		rmsg.Event = config.EventMsgDelete
+	}
 
-		if message.Raw.EventType() == model.WebsocketEventPostDeleted {
-			rmsg.Event = config.EventMsgDelete
+	for _, id := range message.Post.FileIds {
+		err := b.handleDownloadFile(rmsg, id)
+		if err != nil {
+			b.Log.Errorf("download failed: %s", err)
 		}
+	}
 
-		for _, id := range message.Post.FileIds {
-			err := b.handleDownloadFile(rmsg, id)
-			if err != nil {
-				b.Log.Errorf("download failed: %s", err)
-			}
+	// Use nickname instead of username if defined
+	if !b.GetBool("useusername") {
+		if nick := b.mc.GetNickName(rmsg.UserID); nick != "" {
+			rmsg.Username = nick
 		}
+	}
 
-		// Use nickname instead of username if defined
-		if !b.GetBool("useusername") {
-			if nick := b.mc.GetNickName(rmsg.UserID); nick != "" {
-				rmsg.Username = nick
-			}
+	return rmsg
+}
+
+// handleReactionEvent returns the message for a reaction_added or
+// reaction_removed event, or nil if it must be ignored.
+func (b *Bmattermost) handleReactionEvent(message *matterclient.Message) *config.Message {
+	data, ok := message.Raw.GetData()["reaction"].(string)
+	if !ok {
+		return nil
+	}
+	var reaction model.Reaction
+	if err := json.Unmarshal([]byte(data), &reaction); err != nil {
+		b.Log.Errorf("parsing reaction failed: %s", err)
+		return nil
+	}
+	// Ignore the reactions we relayed
+	if reaction.UserId == b.mc.User.Id {
+		return nil
+	}
+	channelID := reaction.ChannelId
+	if channelID == "" {
+		channelID = message.Raw.GetBroadcast().ChannelId
+	}
+	if b.mc.GetChannelTeamID(channelID) != b.TeamID {
+		b.Log.Debug("reaction from other team, ignoring")
+		return nil
+	}
+	channelName := b.getChannelName(channelID)
+	if channelName == "" {
+		channelName = b.mc.GetChannelName(channelID)
+	}
+
+	rmsg := &config.Message{
+		Username: b.mc.GetUserName(reaction.UserId),
+		UserID:   reaction.UserId,
+		Channel:  channelName,
+		Event:    config.EventReaction,
+		ParentID: reaction.PostId,
+		Reaction: &config.Reaction{
+			Emoji:   helper.EmojiFromName(reaction.EmojiName),
+			Removed: message.Raw.EventType() == model.WebsocketEventReactionRemoved,
+		},
+	}
+	// Use nickname instead of username if defined
+	if !b.GetBool("useusername") {
+		if nick := b.mc.GetNickName(rmsg.UserID); nick != "" {
+			rmsg.Username = nick
 		}
+	}
+	return rmsg
+}
 
-		messages <- rmsg
+// handleTypingEvent returns the message for a typing event, or nil if it must
+// be ignored.
+func (b *Bmattermost) handleTypingEvent(message *matterclient.Message) *config.Message {
+	if !b.GetBool("ShowUserTyping") {
+		return nil
+	}
+	userID, _ := message.Raw.GetData()["user_id"].(string)
+	// Ignore our own typing
+	if userID == "" || userID == b.mc.User.Id {
+		return nil
+	}
+	channelID := message.Raw.GetBroadcast().ChannelId
+	if b.mc.GetChannelTeamID(channelID) != b.TeamID {
+		return nil
+	}
+	channelName := b.getChannelName(channelID)
+	if channelName == "" {
+		channelName = b.mc.GetChannelName(channelID)
+	}
+	return &config.Message{
+		UserID:  userID,
+		Channel: channelName,
+		Event:   config.EventUserTyping,
 	}
 }
 
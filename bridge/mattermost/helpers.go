@@ -1,6 +1,8 @@
 package bmattermost
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -88,6 +90,8 @@ func (b *Bmattermost) apiLogin() error {
 
 	b.Log.Info("Connection succeeded")
 	b.TeamID = b.mc.GetTeamID()
+	// set after the first login, the websocket reconnects log in again
+	b.mc.OnWsConnect = b.RequestBackfill
 	return nil
 }
 
@@ -233,8 +237,8 @@ func (b *Bmattermost) skipMessage(message *matterclient.Message) bool {
 		return true
 	}
 
-	// if the message has reactions don't repost it (for now, until we can correlate reaction with message)
-	if message.Post.HasReactions {
+	// reactions update the post too, only relay actual edits
+	if message.Raw.EventType() == model.WebsocketEventPostEdited && message.Post.EditAt == 0 {
 		return true
 	}
 
@@ -252,6 +256,59 @@ func (b *Bmattermost) skipMessage(message *matterclient.Message) bool {
 	return false
 }
 
+// backfill relays the posts of the channel of msg that were created after the
+// post with its ID, or after its timestamp.
+func (b *Bmattermost) backfill(msg *config.Message) error {
+	// webhooks can't read the channel history
+	if b.mc == nil {
+		return nil
+	}
+	channelID := b.getChannelID(msg.Channel)
+	if channelID == "" {
+		return fmt.Errorf("could not find channel ID for channel %s", msg.Channel)
+	}
+	var (
+		posts *model.PostList
+		err   error
+	)
+	if msg.ID != "" {
+		posts, _, err = b.mc.Client.GetPostsAfter(context.TODO(), channelID, msg.ID, 0, b.BackfillLimit(), "", false, false)
+	} else {
+		posts, _, err = b.mc.Client.GetPostsSince(context.TODO(), channelID, msg.Timestamp.UnixMilli(), false)
+	}
+	if err != nil {
+		return err
+	}
+	posts.SortByCreateAt()
+	order := posts.Order
+	if len(order) > b.BackfillLimit() {
+		// keep the oldest ones, the next backfill continues after them
+		order = order[len(order)-b.BackfillLimit():]
+	}
+	b.Log.Debugf("Backfilling %d posts of %s", len(order), msg.Channel)
+	// the order is newest first
+	for i := len(order) - 1; i >= 0; i-- {
+		post := posts.Posts[order[i]]
+		event := model.NewWebSocketEvent(model.WebsocketEventPosted, b.TeamID, channelID, post.UserId, nil, "")
+		message := &matterclient.Message{
+			Raw:      event.SetData(map[string]interface{}{"team_id": b.TeamID}),
+			Post:     post,
+			Channel:  b.mc.GetChannelName(channelID),
+			Username: b.mc.GetUserName(post.UserId),
+			UserID:   post.UserId,
+			Type:     post.Type,
+			Text:     post.Message,
+		}
+		rmsg := b.handlePostEvent(message)
+		if rmsg == nil {
+			continue
+		}
+		rmsg.Backfill = true
+		b.messages <- rmsg
+	}
+	return nil
+}
+
 func (b *Bmattermost) getVersion() string {
 	proto := "https"
 
@@ -270,6 +327,52 @@ func (b *Bmattermost) getVersion() string {
 	return resp.Header.Get("X-Version-Id")
 }
 
+// sendChannelMembers sends the members of the channels to the gateway. Only
+// the API knows them, not the webhooks.
+func (b *Bmattermost) sendChannelMembers() error {
+	if b.mc == nil {
+		return nil
+	}
+	b.channelsMutex.RLock()
+	channels := make([]string, 0, len(b.channelInfoMap))
+	for _, channel := range b.channelInfoMap {
+		channels = append(channels, channel.Name)
+	}
+	b.channelsMutex.RUnlock()
+
+	const perPage = 200
+	members := config.ChannelMembers{}
+	for _, name := range channels {
+		id := b.getChannelID(name)
+		if id == "" {
+			continue
+		}
+		for page := 0; ; page++ {
+			users, _, err := b.mc.Client.GetUsersInChannel(context.TODO(), id, page, perPage, "")
+			if err != nil {
+				return fmt.Errorf("getting the members of %s failed: %w", name, err)
+			}
+			for _, user := range users {
+				if user.Id == b.mc.User.Id {
+					continue
+				}
+				members = append(members, config.ChannelMember{
+					Username:    user.Username,
+					Nick:        user.Nickname,
+					UserID:      user.Id,
+					ChannelID:   id,
+					ChannelName: name,
+				})
+			}
+			if len(users) < perPage {
+				break
+			}
+		}
+	}
+	b.SendChannelMembers(members)
+	return nil
+}
+
 func (b *Bmattermost) getChannelID(name string) string {
 	idcheck := strings.Split(name, "ID:")
 	if len(idcheck) > 1 {
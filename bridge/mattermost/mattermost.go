@@ -10,8 +10,10 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/42wim/matterbridge/matterhook"
 	"github.com/matterbridge/matterclient"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/rs/xid"
 )
 
@@ -25,6 +27,8 @@ type Bmattermost struct {
 	avatarMap      map[string]string
 	channelsMutex  sync.RWMutex
 	channelInfoMap map[string]*config.ChannelInfo
+	// messages are sent to the gateway by handleMatter
+	messages chan *config.Message
 }
 
 const mattermostPlugin = "mattermost.plugin"
@@ -34,23 +38,53 @@ func New(cfg *bridge.Config) bridge.Bridger {
 		Config:         cfg,
 		avatarMap:      make(map[string]string),
 		channelInfoMap: make(map[string]*config.ChannelInfo),
+		messages:       make(chan *config.Message),
 	}
 
 	b.v6 = b.GetBool("v6")
 	b.uuid = xid.New().String()
 
+	if cfg.Account == mattermostPlugin {
+		return &Bplugin{b}
+	}
 	return b
 }
 
+// Bplugin is the mattermost.plugin account. It doesn't connect to mattermost,
+// the gateway hands its messages to the mattermost matterbridge plugin.
+type Bplugin struct {
+	*Bmattermost
+}
+
+func (b *Bplugin) RelaysToPlugin() {}
+
+func (b *Bplugin) Connect() error {
+	return nil
+}
+
+func (b *Bplugin) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+func (b *Bplugin) Send(msg config.Message) (string, error) {
+	return "", nil
+}
+
 func (b *Bmattermost) Command(cmd string) string {
 	return ""
 }
 
-func (b *Bmattermost) Connect() error {
-	if b.Account == mattermostPlugin {
-		return nil
-	}
+func (b *Bmattermost) EditsMessages()    {}
+func (b *Bmattermost) DeletesMessages()  {}
+func (b *Bmattermost) PreservesThreads() {}
+func (b *Bmattermost) SendsReactions()   {}
+func (b *Bmattermost) SendsTyping()      {}
+func (b *Bmattermost) UploadsFiles()     {}
+func (b *Bmattermost) CachesAvatars()    {}
+func (b *Bmattermost) ListsMembers()     {}
+func (b *Bmattermost) Backfills()        {}
 
+func (b *Bmattermost) Connect() error {
 	if strings.HasPrefix(b.getVersion(), "6.") || strings.HasPrefix(b.getVersion(), "7.") {
 		if !b.v6 {
 			b.v6 = true
@@ -99,10 +133,6 @@ func (b *Bmattermost) Disconnect() error {
 }
 
 func (b *Bmattermost) JoinChannel(channel config.ChannelInfo) error {
-	if b.Account == mattermostPlugin {
-		return nil
-	}
-
 	b.channelsMutex.Lock()
 	b.channelInfoMap[channel.ID] = &channel
 	b.channelsMutex.Unlock()
@@ -121,10 +151,27 @@ func (b *Bmattermost) JoinChannel(channel config.ChannelInfo) error {
 }
 
 func (b *Bmattermost) Send(msg config.Message) (string, error) {
-	if b.Account == mattermostPlugin {
+	b.Log.Debugf("=> Receiving %#v", msg)
+
+	if msg.Event == config.EventGetChannelMembers {
+		return "", b.sendChannelMembers()
+	}
+
+	if msg.Event == config.EventBackfill {
+		return "", b.backfill(&msg)
+	}
+
+	// only the API websocket can send typing
+	if msg.Event == config.EventUserTyping {
+		if b.GetBool("ShowUserTyping") && b.mc != nil && b.mc.WsClient != nil {
+			b.mc.WsClient.UserTyping(b.getChannelID(msg.Channel), "")
+		}
 		return "", nil
 	}
-	b.Log.Debugf("=> Receiving %#v", msg)
+
+	if doc := msg.RichText(); doc != nil {
+		msg.Text = richtext.RenderMarkdown(doc)
+	}
 
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
@@ -150,6 +197,10 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 		return msg.ID, b.mc.DeleteMessage(msg.ID)
 	}
 
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg)
+	}
+
 	// Handle prefix hint for unthreaded messages.
 	if msg.ParentNotFound() {
 		msg.ParentID = ""
@@ -192,3 +243,18 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 	// Post normal message
 	return b.mc.PostMessage(b.getChannelID(msg.Channel), msg.Text, msg.ParentID)
 }
+
+// sendReaction adds or removes the reaction of our user to the post in ParentID.
+func (b *Bmattermost) sendReaction(msg *config.Message) error {
+	reaction := &model.Reaction{
+		UserId:    b.mc.User.Id,
+		PostId:    msg.ParentID,
+		EmojiName: helper.EmojiName(msg.Reaction.Emoji),
+	}
+	if msg.Reaction.Removed {
+		_, err := b.mc.Client.DeleteReaction(context.TODO(), reaction)
+		return err
+	}
+	_, _, err := b.mc.Client.SaveReaction(context.TODO(), reaction)
+	return err
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/davecgh/go-spew/spew"
 
 	"github.com/mattn/godown"
@@ -35,6 +36,8 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return &Bmsteams{Config: cfg}
 }
 
+func (b *Bmsteams) PreservesThreads() {}
+
 func (b *Bmsteams) Connect() error {
 	tokenCachePath := b.GetString("sessionFile")
 	if tokenCachePath == "" {
@@ -196,6 +199,14 @@ func (b *Bmsteams) poll(channelName string) error {
 				ID:       *msg.ID,
 				Extra:    make(map[string][]interface{}),
 			}
+			rmsg.Timestamp = *msg.CreatedDateTime
+			if msg.LastModifiedDateTime != nil {
+				rmsg.Timestamp = *msg.LastModifiedDateTime
+			}
+			if strings.Contains(*msg.Body.Content, "<div>") {
+				rmsg.Formatted = richtext.ParseHTML(*msg.Body.Content)
+				rmsg.Formatted.Source = text
+			}
 
 			b.handleAttachments(&rmsg, msg)
 			b.Log.Debugf("<= Message is %#v", rmsg)
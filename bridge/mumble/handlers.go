@@ -8,6 +8,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 )
 
 func (b *Bmumble) handleServerConfig(event *gumble.ServerConfigEvent) {
@@ -41,6 +42,7 @@ func (b *Bmumble) handleTextMessage(event *gumble.TextMessageEvent) {
 		}
 		if part.Image == nil {
 			rmsg.Text = part.Text
+			rmsg.Formatted = richtext.ParseMarkdown(part.Text)
 		} else {
 			fileExt := part.FileExtension
 			if fileExt == ".jfif" {
@@ -17,6 +17,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	stripmd "github.com/writeas/go-strip-markdown"
 
 	// We need to import the 'data' package as an implicit dependency.
@@ -50,6 +51,8 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
+func (b *Bmumble) UploadsFiles() {}
+
 func (b *Bmumble) Connect() error {
 	b.Log.Infof("Connecting %s", b.GetString("Server"))
 	host, portstr, err := net.SplitHostPort(b.GetString("Server"))
@@ -241,9 +244,15 @@ func (b *Bmumble) processMessage(msg *config.Message) {
 		return
 	}
 	// If HTML is allowed, convert markdown into HTML, otherwise strip markdown
-	if allowHTML {
+	doc := msg.RichText()
+	switch {
+	case allowHTML && doc != nil:
+		msg.Text = richtext.RenderHTML(doc, "<br>")
+	case allowHTML:
 		msg.Text = helper.ParseMarkdown(msg.Text)
-	} else {
+	case doc != nil:
+		msg.Text = doc.Plain()
+	default:
 		msg.Text = stripmd.Strip(msg.Text)
 	}
 
@@ -30,6 +30,9 @@ type Broom struct {
 	ctxCancel context.CancelFunc
 }
 
+func (b *Btalk) DeletesMessages() {}
+func (b *Btalk) UploadsFiles()    {}
+
 func (b *Btalk) Connect() error {
 	b.Log.Info("Connecting")
 	tconfig := &user.TalkUserConfig{
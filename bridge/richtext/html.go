@@ -0,0 +1,181 @@
+package richtext
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// matrixUserPrefix is the prefix of the links of Matrix mention pills.
+const matrixUserPrefix = "https://matrix.to/#/@"
+
+// ParseHTML parses the HTML of Matrix, Mumble and MS Teams messages. The
+// reply fallback of Matrix (<mx-reply>) is dropped, links to Matrix users
+// become mentions.
+func ParseHTML(text string) *Document {
+	nodes, err := html.ParseFragment(strings.NewReader(text), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return &Document{Source: text, Nodes: []*Node{{Kind: Text, Text: text}}}
+	}
+	var b builder
+	for _, n := range nodes {
+		convertHTML(&b, n)
+	}
+	return &Document{Source: text, Nodes: trimNewlines(b.result())}
+}
+
+func convertHTML(b *builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		// whitespace between blocks
+		if strings.TrimSpace(n.Data) == "" && strings.Contains(n.Data, "\n") {
+			return
+		}
+		b.writeString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		b.writeString("\n")
+	case atom.Strong, atom.B:
+		convertHTMLInline(b, n, Bold)
+	case atom.Em, atom.I:
+		convertHTMLInline(b, n, Italic)
+	case atom.Del, atom.S, atom.Strike:
+		convertHTMLInline(b, n, Strike)
+	case atom.Code:
+		b.add(&Node{Kind: Code, Text: textContent(n)})
+	case atom.Pre:
+		pre := &Node{Kind: Pre, Text: strings.TrimSuffix(textContent(n), "\n")}
+		if c := n.FirstChild; c != nil && c.DataAtom == atom.Code {
+			for _, attr := range c.Attr {
+				if attr.Key == "class" && strings.HasPrefix(attr.Val, "language-") {
+					pre.Lang = strings.TrimPrefix(attr.Val, "language-")
+				}
+			}
+		}
+		convertHTMLBlock(b, pre)
+	case atom.Blockquote:
+		var quote builder
+		convertHTMLChildren(&quote, n)
+		convertHTMLBlock(b, &Node{Kind: Quote, Children: trimNewlines(quote.result())})
+	case atom.A:
+		convertHTMLLink(b, n)
+	case atom.Img:
+		b.writeString(attribute(n, "alt"))
+	case atom.Script, atom.Style, atom.Head:
+	case atom.P, atom.Div, atom.Ul, atom.Ol, atom.Tr, atom.Table:
+		newlineBefore(b)
+		convertHTMLChildren(b, n)
+		b.writeString("\n")
+	case atom.Li:
+		newlineBefore(b)
+		b.writeString("- ")
+		convertHTMLChildren(b, n)
+		b.writeString("\n")
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		newlineBefore(b)
+		convertHTMLInline(b, n, Bold)
+		b.writeString("\n")
+	default:
+		if n.Data == "mx-reply" {
+			return
+		}
+		convertHTMLChildren(b, n)
+	}
+}
+
+func convertHTMLChildren(b *builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		convertHTML(b, c)
+	}
+}
+
+func convertHTMLInline(b *builder, n *html.Node, kind Kind) {
+	var children builder
+	convertHTMLChildren(&children, n)
+	if nodes := children.result(); len(nodes) > 0 {
+		b.add(&Node{Kind: kind, Children: nodes})
+	}
+}
+
+// convertHTMLBlock adds a pre or quote block on lines of its own.
+func convertHTMLBlock(b *builder, block *Node) {
+	newlineBefore(b)
+	b.add(block)
+	b.writeString("\n")
+}
+
+func convertHTMLLink(b *builder, n *html.Node) {
+	href := attribute(n, "href")
+	if strings.HasPrefix(href, matrixUserPrefix) {
+		b.add(&Node{
+			Kind:   Mention,
+			UserID: strings.TrimPrefix(href, matrixUserPrefix[:len(matrixUserPrefix)-1]),
+			Text:   strings.TrimPrefix(textContent(n), "@"),
+		})
+		return
+	}
+	if href == "" {
+		convertHTMLChildren(b, n)
+		return
+	}
+	var label builder
+	convertHTMLChildren(&label, n)
+	b.add(&Node{Kind: Link, URL: href, Children: label.result()})
+}
+
+func newlineBefore(b *builder) {
+	if !b.endsWithNewline() {
+		b.writeString("\n")
+	}
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Br {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// trimNewlines removes the line breaks at the start and the end.
+func trimNewlines(nodes []*Node) []*Node {
+	if len(nodes) > 0 && nodes[0].Kind == Text {
+		nodes[0].Text = strings.TrimLeft(nodes[0].Text, "\n")
+		if nodes[0].Text == "" {
+			nodes = nodes[1:]
+		}
+	}
+	if last := len(nodes) - 1; last >= 0 && nodes[last].Kind == Text {
+		nodes[last].Text = strings.TrimRight(nodes[last].Text, "\n")
+		if nodes[last].Text == "" {
+			nodes = nodes[:last]
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,126 @@
+package richtext
+
+import "strings"
+
+// ircStyle is a set of IRC formatting codes in effect.
+type ircStyle uint8
+
+const (
+	styleBold ircStyle = 1 << iota
+	styleItalic
+	styleStrike
+	styleMonospace
+)
+
+// ircStyles are the styles that become nodes, outermost first.
+var ircStyles = []struct {
+	style ircStyle
+	kind  Kind
+}{
+	{styleBold, Bold},
+	{styleItalic, Italic},
+	{styleStrike, Strike},
+}
+
+// ircRun is text with the same formatting.
+type ircRun struct {
+	style ircStyle
+	text  string
+}
+
+// ParseIRC parses the IRC formatting control codes. Colors, underline and
+// reverse have no equivalent and are dropped.
+func ParseIRC(text string) *Document {
+	var (
+		runs  []ircRun
+		style ircStyle
+		cur   strings.Builder
+	)
+	toggle := func(s ircStyle) {
+		if cur.Len() > 0 {
+			runs = append(runs, ircRun{style, cur.String()})
+			cur.Reset()
+		}
+		style ^= s
+	}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case ircBold[0]:
+			toggle(styleBold)
+		case ircItalic[0]:
+			toggle(styleItalic)
+		case ircStrike[0]:
+			toggle(styleStrike)
+		case ircMonospace[0]:
+			toggle(styleMonospace)
+		case ircReset[0]:
+			toggle(style)
+		case '\x03':
+			i += colorCodeLen(text[i+1:], isDigit, 2)
+		case '\x04':
+			i += colorCodeLen(text[i+1:], isHexDigit, 6)
+		case '\x16', '\x1f':
+			// reverse and underline
+		default:
+			cur.WriteByte(text[i])
+		}
+	}
+	toggle(0)
+	return &Document{Source: text, Nodes: ircNodes(runs, 0)}
+}
+
+// colorCodeLen returns the length of the colors after a color code, a
+// foreground and optionally a background color of up to max digits.
+func colorCodeLen(s string, digit func(byte) bool, max int) int {
+	n := 0
+	for n < len(s) && n < max && digit(s[n]) {
+		n++
+	}
+	if n == 0 || n+1 >= len(s) || s[n] != ',' || !digit(s[n+1]) {
+		return n
+	}
+	bg := 0
+	for n+1+bg < len(s) && bg < max && digit(s[n+1+bg]) {
+		bg++
+	}
+	return n + 1 + bg
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// ircNodes nests the runs, grouping the runs that share the style of level.
+func ircNodes(runs []ircRun, level int) []*Node {
+	var b builder
+	if level == len(ircStyles) {
+		for _, run := range runs {
+			if run.style&styleMonospace != 0 {
+				b.add(&Node{Kind: Code, Text: run.text})
+			} else {
+				b.writeString(run.text)
+			}
+		}
+		return b.result()
+	}
+	style := ircStyles[level]
+	for i := 0; i < len(runs); {
+		j := i + 1
+		set := runs[i].style&style.style != 0
+		for j < len(runs) && (runs[j].style&style.style != 0) == set {
+			j++
+		}
+		children := ircNodes(runs[i:j], level+1)
+		if set {
+			b.add(&Node{Kind: style.kind, Children: children})
+		} else {
+			b.addAll(children...)
+		}
+		i = j
+	}
+	return b.result()
+}
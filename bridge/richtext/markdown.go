@@ -0,0 +1,349 @@
+package richtext
+
+import (
+	"html"
+	"strings"
+)
+
+// delimiter is an emphasis delimiter like ** and the kind it formats.
+type delimiter struct {
+	s    string
+	kind Kind
+}
+
+// dialect is a flavour of the markdown-like formats of the chat protocols.
+type dialect struct {
+	// delimiters are tried in order, so longer delimiters come first.
+	delimiters []delimiter
+	// wordBoundary requires all delimiters to be outside of words, otherwise
+	// only _ must be.
+	wordBoundary bool
+	// escapes allows escaping punctuation with a backslash.
+	escapes bool
+	// markdownLinks parses [text](url), slackLinks the Slack <url|text> and
+	// <@user> references instead of <url> autolinks.
+	markdownLinks bool
+	slackLinks    bool
+	// fenceLang parses the language after an opening ``` fence.
+	fenceLang bool
+	// quotePrefix starts a quoted line, quoteSpace requires a space after it.
+	quotePrefix string
+	quoteSpace  bool
+	// resolve returns the name of a Slack user ID.
+	resolve func(id string) string
+}
+
+var markdownDialect = dialect{
+	delimiters: []delimiter{
+		{"**", Bold}, {"__", Bold}, {"~~", Strike}, {"*", Italic}, {"_", Italic},
+	},
+	escapes:       true,
+	markdownLinks: true,
+	fenceLang:     true,
+	quotePrefix:   ">",
+	quoteSpace:    true,
+}
+
+// ParseMarkdown parses the markdown as used by Discord, Mattermost and
+// Rocket.Chat: **bold**, *italic*, ~~strike~~, `code`, ``` blocks, [links](url)
+// and > quotes.
+func ParseMarkdown(text string) *Document {
+	return &Document{Source: text, Nodes: markdownDialect.parse(text)}
+}
+
+// ParseSlack parses the mrkdwn of Slack: *bold*, _italic_, ~strike~, `code`,
+// ``` blocks, <url|links> and &gt; quotes. The names of mentioned users are
+// looked up with resolve, the ID is used when it returns nothing.
+func ParseSlack(text string, resolve func(id string) string) *Document {
+	d := dialect{
+		delimiters: []delimiter{
+			{"*", Bold}, {"_", Italic}, {"~", Strike},
+		},
+		wordBoundary: true,
+		slackLinks:   true,
+		quotePrefix:  "&gt;",
+		resolve:      resolve,
+	}
+	nodes := d.parse(text)
+	unescapeSlack(nodes)
+	return &Document{Source: text, Nodes: nodes}
+}
+
+// unescapeSlack replaces the &amp;, &lt; and &gt; Slack escapes.
+func unescapeSlack(nodes []*Node) {
+	for _, n := range nodes {
+		n.Text = html.UnescapeString(n.Text)
+		n.URL = html.UnescapeString(n.URL)
+		unescapeSlack(n.Children)
+	}
+}
+
+// parse splits the text in quotes and paragraphs. Quotes in code blocks
+// aren't quotes.
+func (d *dialect) parse(text string) []*Node {
+	var (
+		b       builder
+		para    []string
+		quote   []string
+		inFence bool
+		started bool
+	)
+	newline := func() {
+		if started {
+			b.writeString("\n")
+		}
+		started = true
+	}
+	flushPara := func() {
+		if para == nil {
+			return
+		}
+		newline()
+		b.addAll(d.parseInline(strings.Join(para, "\n"))...)
+		para = nil
+	}
+	flushQuote := func() {
+		if quote == nil {
+			return
+		}
+		newline()
+		b.add(&Node{Kind: Quote, Children: d.parse(strings.Join(quote, "\n"))})
+		quote = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if !inFence && d.isQuote(line) {
+			flushPara()
+			line = strings.TrimPrefix(line, d.quotePrefix)
+			quote = append(quote, strings.TrimPrefix(line, " "))
+			continue
+		}
+		if strings.Count(line, "```")%2 == 1 {
+			inFence = !inFence
+		}
+		flushQuote()
+		para = append(para, line)
+	}
+	flushPara()
+	flushQuote()
+	return b.result()
+}
+
+func (d *dialect) isQuote(line string) bool {
+	if !strings.HasPrefix(line, d.quotePrefix) {
+		return false
+	}
+	return !d.quoteSpace || len(line) == len(d.quotePrefix) || line[len(d.quotePrefix)] == ' '
+}
+
+// parseInline parses the formatting within a paragraph.
+func (d *dialect) parseInline(s string) []*Node {
+	var b builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		var (
+			n   *Node
+			end int
+		)
+		switch {
+		case c == '\\' && d.escapes && i+1 < len(s) && isPunct(s[i+1]):
+			b.writeByte(s[i+1])
+			i += 2
+			continue
+		case c == '`':
+			n, end = d.parseCode(s, i)
+		case c == '[' && d.markdownLinks:
+			n, end = d.parseLink(s, i)
+		case c == '<':
+			n, end = d.parseAngle(s, i)
+		default:
+			n, end = d.parseEmphasis(s, i)
+		}
+		if n == nil {
+			b.writeByte(c)
+			i++
+			continue
+		}
+		b.addAll(n)
+		i = end
+	}
+	return b.result()
+}
+
+// parseCode parses a `code` span or a ```pre``` block starting at i.
+func (d *dialect) parseCode(s string, i int) (*Node, int) {
+	n := 0
+	for i+n < len(s) && s[i+n] == '`' {
+		n++
+	}
+	fence := s[i : i+n]
+	start := i + n
+	j := strings.Index(s[start:], fence)
+	if j <= 0 {
+		return nil, 0
+	}
+	content := s[start : start+j]
+	end := start + j + n
+	if n < 3 {
+		if strings.Contains(content, "\n") {
+			return nil, 0
+		}
+		return &Node{Kind: Code, Text: content}, end
+	}
+	pre := &Node{Kind: Pre}
+	if d.fenceLang {
+		if nl := strings.Index(content, "\n"); nl > 0 && isWord(content[:nl]) {
+			pre.Lang = content[:nl]
+			content = content[nl:]
+		}
+	}
+	content = strings.TrimPrefix(content, "\n")
+	pre.Text = strings.TrimSuffix(content, "\n")
+	return pre, end
+}
+
+// parseLink parses a [text](url) link starting at i.
+func (d *dialect) parseLink(s string, i int) (*Node, int) {
+	j := strings.Index(s[i:], "](")
+	if j < 0 {
+		return nil, 0
+	}
+	label := s[i+1 : i+j]
+	rest := s[i+j+2:]
+	k := strings.IndexByte(rest, ')')
+	if label == "" || strings.ContainsAny(label, "[\n") || k <= 0 || strings.ContainsAny(rest[:k], " \n") {
+		return nil, 0
+	}
+	return &Node{Kind: Link, URL: rest[:k], Children: d.parseInline(label)}, i + j + 2 + k + 1
+}
+
+// parseAngle parses <url> and the Slack <url|text>, <@user>, <#channel> and
+// <!here> references starting at i.
+func (d *dialect) parseAngle(s string, i int) (*Node, int) {
+	j := strings.IndexAny(s[i+1:], "<>\n")
+	if j <= 0 || s[i+1+j] != '>' {
+		return nil, 0
+	}
+	inner := s[i+1 : i+1+j]
+	end := i + j + 2
+	if !d.slackLinks {
+		if !strings.HasPrefix(inner, "http://") && !strings.HasPrefix(inner, "https://") ||
+			strings.Contains(inner, " ") {
+			return nil, 0
+		}
+		return &Node{Kind: Link, URL: inner, Children: []*Node{{Kind: Text, Text: inner}}}, end
+	}
+	target, label := inner, ""
+	if k := strings.IndexByte(inner, '|'); k >= 0 {
+		target, label = inner[:k], inner[k+1:]
+	}
+	if target == "" {
+		return nil, 0
+	}
+	switch target[0] {
+	case '@':
+		id := target[1:]
+		if label == "" && d.resolve != nil {
+			label = d.resolve(id)
+		}
+		if label == "" {
+			label = id
+		}
+		return &Node{Kind: Mention, UserID: id, Text: strings.TrimPrefix(label, "@")}, end
+	case '#':
+		if label == "" {
+			label = target[1:]
+		}
+		return &Node{Kind: Text, Text: "#" + label}, end
+	case '!':
+		if label == "" {
+			label = target[1:]
+		}
+		return &Node{Kind: Text, Text: "@" + strings.TrimPrefix(label, "@")}, end
+	}
+	if label == "" {
+		label = strings.TrimPrefix(target, "mailto:")
+	}
+	return &Node{Kind: Link, URL: target, Children: []*Node{{Kind: Text, Text: label}}}, end
+}
+
+// parseEmphasis parses bold, italic and strikethrough text starting at i.
+func (d *dialect) parseEmphasis(s string, i int) (*Node, int) {
+	for _, delim := range d.delimiters {
+		if !strings.HasPrefix(s[i:], delim.s) {
+			continue
+		}
+		open := i + len(delim.s)
+		if open >= len(s) || isSpace(s[open]) {
+			return nil, 0
+		}
+		boundary := d.wordBoundary || delim.s[0] == '_'
+		if boundary && i > 0 && isAlnum(s[i-1]) {
+			return nil, 0
+		}
+		if j := findClose(s, open, delim.s, boundary); j > 0 {
+			return &Node{Kind: delim.kind, Children: d.parseInline(s[open:j])}, j + len(delim.s)
+		}
+		// a ** without closing can still open a *
+	}
+	return nil, 0
+}
+
+// findClose returns the index of the delimiter closing the emphasis that
+// starts at open, or -1.
+func findClose(s string, open int, delim string, boundary bool) int {
+	c := delim[0]
+	for j := open + 1; j+len(delim) <= len(s); j++ {
+		switch {
+		case s[j] == '\n':
+			return -1
+		case s[j] == '\\':
+			j++
+			continue
+		case s[j] == '`':
+			// don't close within code
+			if k := strings.IndexByte(s[j+1:], '`'); k >= 0 {
+				j += k + 1
+			}
+			continue
+		case !strings.HasPrefix(s[j:], delim) || isSpace(s[j-1]):
+			continue
+		}
+		run := 0
+		for j+run < len(s) && s[j+run] == c {
+			run++
+		}
+		if len(delim) == 1 && run == 2 {
+			// a nested ** or __
+			j++
+			continue
+		}
+		// prefer the last delimiters of a run like ***
+		j += run - len(delim)
+		if boundary && j+len(delim) < len(s) && isAlnum(s[j+len(delim)]) {
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isWord(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAlnum(s[i]) && s[i] != '+' && s[i] != '-' && s[i] != '#' {
+			return false
+		}
+	}
+	return s != ""
+}
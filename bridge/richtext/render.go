@@ -0,0 +1,285 @@
+package richtext
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// renderer writes the nodes of one kind in a format.
+type renderer struct {
+	text    func(s string) string
+	wrap    map[Kind][2]string
+	code    func(s string) string
+	pre     func(n *Node) string
+	link    func(url, label string) string
+	quote   func(s string) string
+	mention func(n *Node) string
+	// block trims the line breaks around pre and quote blocks, for formats
+	// where they are blocks already.
+	block bool
+}
+
+func (r *renderer) render(nodes []*Node) string {
+	var b strings.Builder
+	for i, n := range nodes {
+		switch n.Kind {
+		case Text:
+			text := n.Text
+			if r.block && i > 0 && isBlock(nodes[i-1]) {
+				text = strings.TrimPrefix(text, "\n")
+			}
+			if r.block && i+1 < len(nodes) && isBlock(nodes[i+1]) {
+				text = strings.TrimSuffix(text, "\n")
+			}
+			b.WriteString(r.text(text))
+		case Code:
+			b.WriteString(r.code(n.Text))
+		case Pre, Quote:
+			var s string
+			if n.Kind == Pre {
+				s = r.pre(n)
+			} else {
+				s = r.quote(r.render(n.Children))
+			}
+			// keep blocks on lines of their own
+			if !r.block && b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString("\n")
+			}
+			b.WriteString(s)
+			if !r.block && i+1 < len(nodes) && !strings.HasPrefix(nodes[i+1].Text, "\n") {
+				b.WriteString("\n")
+			}
+		case Link:
+			b.WriteString(r.link(n.URL, r.render(n.Children)))
+		case Mention:
+			b.WriteString(r.mention(n))
+		default:
+			w := r.wrap[n.Kind]
+			b.WriteString(w[0] + r.render(n.Children) + w[1])
+		}
+	}
+	return b.String()
+}
+
+func mentionName(n *Node) string {
+	if n.Native != "" {
+		return n.Native
+	}
+	return "@" + n.Text
+}
+
+var urlRE = regexp.MustCompile(`https?://\S+`)
+
+// escapeMarkdown escapes the characters that would be formatting, but not
+// in URLs or in the middle of words like snake_case and @user_name.
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range urlRE.FindAllStringIndex(s, -1) {
+		b.WriteString(escapeMarkdownText(s[last:loc[0]]))
+		b.WriteString(s[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(escapeMarkdownText(s[last:]))
+	return b.String()
+}
+
+func escapeMarkdownText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '*', '`':
+			b.WriteByte('\\')
+		case '\\':
+			if i+1 < len(s) && isPunct(s[i+1]) {
+				b.WriteByte('\\')
+			}
+		case '_':
+			if i == 0 || i+1 == len(s) || !isAlnum(s[i-1]) || !isAlnum(s[i+1]) {
+				b.WriteByte('\\')
+			}
+		case '~':
+			// a single ~ is a channel link on Mattermost
+			if i+1 < len(s) && s[i+1] == '~' || i > 0 && s[i-1] == '~' {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// codeSpan returns s as code with a fence that isn't in s.
+func codeSpan(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if len(fence) > 1 {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
+
+var markdownRenderer = renderer{
+	text: escapeMarkdown,
+	wrap: map[Kind][2]string{
+		Bold:   {"**", "**"},
+		Italic: {"*", "*"},
+		Strike: {"~~", "~~"},
+	},
+	code: codeSpan,
+	pre: func(n *Node) string {
+		return "```" + n.Lang + "\n" + n.Text + "\n```"
+	},
+	link: func(url, label string) string {
+		if label == url {
+			return url
+		}
+		return "[" + label + "](" + url + ")"
+	},
+	quote: func(s string) string {
+		return prefixLines(s, "> ")
+	},
+	mention: mentionName,
+}
+
+// RenderMarkdown renders the document as the markdown of Discord and
+// Mattermost.
+func RenderMarkdown(d *Document) string {
+	return markdownRenderer.render(d.Nodes)
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var slackRenderer = renderer{
+	text: slackEscaper.Replace,
+	wrap: map[Kind][2]string{
+		Bold:   {"*", "*"},
+		Italic: {"_", "_"},
+		Strike: {"~", "~"},
+	},
+	code: func(s string) string {
+		return "`" + slackEscaper.Replace(s) + "`"
+	},
+	pre: func(n *Node) string {
+		return "```\n" + slackEscaper.Replace(n.Text) + "\n```"
+	},
+	link: func(url, label string) string {
+		if label == slackEscaper.Replace(url) {
+			return "<" + url + ">"
+		}
+		return "<" + url + "|" + label + ">"
+	},
+	quote: func(s string) string {
+		return prefixLines(s, "> ")
+	},
+	mention: func(n *Node) string {
+		if n.Native != "" {
+			return n.Native
+		}
+		return slackEscaper.Replace(mentionName(n))
+	},
+}
+
+// RenderSlack renders the document as Slack mrkdwn.
+func RenderSlack(d *Document) string {
+	return slackRenderer.render(d.Nodes)
+}
+
+// matrixUserLink returns the matrix.to link of a Matrix user ID like
+// @user:example.org, or "" for other IDs.
+func matrixUserLink(id string) string {
+	if !strings.HasPrefix(id, "@") || !strings.Contains(id, ":") {
+		return ""
+	}
+	return "https://matrix.to/#/" + id
+}
+
+// RenderHTML renders the document as HTML, like Matrix, Telegram and Mumble
+// show it. lineBreak is written for the line breaks outside of code blocks,
+// <br> for most clients but "\n" for Telegram.
+func RenderHTML(d *Document, lineBreak string) string {
+	r := renderer{
+		text: func(s string) string {
+			return strings.ReplaceAll(html.EscapeString(s), "\n", lineBreak)
+		},
+		wrap: map[Kind][2]string{
+			Bold:   {"<strong>", "</strong>"},
+			Italic: {"<em>", "</em>"},
+			Strike: {"<del>", "</del>"},
+		},
+		code: func(s string) string {
+			return "<code>" + html.EscapeString(s) + "</code>"
+		},
+		pre: func(n *Node) string {
+			if n.Lang != "" {
+				return `<pre><code class="language-` + html.EscapeString(n.Lang) + `">` + html.EscapeString(n.Text) + "</code></pre>"
+			}
+			return "<pre><code>" + html.EscapeString(n.Text) + "</code></pre>"
+		},
+		link: func(url, label string) string {
+			return `<a href="` + html.EscapeString(url) + `">` + label + "</a>"
+		},
+		quote: func(s string) string {
+			return "<blockquote>" + s + "</blockquote>"
+		},
+		mention: func(n *Node) string {
+			if n.Native != "" {
+				return n.Native
+			}
+			if link := matrixUserLink(n.UserID); link != "" {
+				return `<a href="` + html.EscapeString(link) + `">` + html.EscapeString(n.Text) + "</a>"
+			}
+			return html.EscapeString(mentionName(n))
+		},
+		block: true,
+	}
+	return r.render(d.Nodes)
+}
+
+const (
+	ircBold      = "\x02"
+	ircItalic    = "\x1d"
+	ircStrike    = "\x1e"
+	ircMonospace = "\x11"
+	ircReset     = "\x0f"
+)
+
+var ircRenderer = renderer{
+	text: func(s string) string { return s },
+	wrap: map[Kind][2]string{
+		Bold:   {ircBold, ircBold},
+		Italic: {ircItalic, ircItalic},
+		Strike: {ircStrike, ircStrike},
+	},
+	code: func(s string) string {
+		return ircMonospace + s + ircMonospace
+	},
+	pre: func(n *Node) string {
+		lines := strings.Split(n.Text, "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = ircMonospace + line + ircMonospace
+			}
+		}
+		return strings.Join(lines, "\n")
+	},
+	link: func(url, label string) string {
+		if label == url {
+			return url
+		}
+		return label + " (" + url + ")"
+	},
+	quote: func(s string) string {
+		return prefixLines(s, "> ")
+	},
+	mention: mentionName,
+}
+
+// RenderIRC renders the document with the IRC formatting control codes.
+func RenderIRC(d *Document) string {
+	return ircRenderer.render(d.Nodes)
+}
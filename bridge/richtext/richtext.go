@@ -0,0 +1,261 @@
+// Package richtext holds the formatting of a message as a tree, so every
+// bridge can parse its native format once and render the formatting of the
+// other protocols in its own format.
+package richtext
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kind is the type of a node.
+type Kind string
+
+const (
+	Text    Kind = "text"
+	Bold    Kind = "bold"
+	Italic  Kind = "italic"
+	Strike  Kind = "strike"
+	Code    Kind = "code"
+	Pre     Kind = "pre"
+	Link    Kind = "link"
+	Quote   Kind = "quote"
+	Mention Kind = "mention"
+)
+
+// Node is an element of the tree. Text, Code, Pre and Mention nodes hold
+// their content in Text, the other kinds format their children.
+type Node struct {
+	Kind Kind   `json:"kind"`
+	Text string `json:"text,omitempty"`
+	// URL is the target of a Link.
+	URL string `json:"url,omitempty"`
+	// Lang is the language of a Pre block, when it is known.
+	Lang string `json:"lang,omitempty"`
+	// UserID is the native ID of a mentioned user on the bridge the message
+	// came from, Text is the name of the user without @.
+	UserID string `json:"userid,omitempty"`
+	// Native is the mention in the syntax of the destination, it's written
+	// as is by the renderers.
+	Native   string  `json:"native,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Document is a parsed message.
+type Document struct {
+	// Source is the text of the message the document belongs to, the
+	// document is stale when the text was changed afterwards.
+	Source string  `json:"source"`
+	Nodes  []*Node `json:"nodes"`
+}
+
+// MapText replaces the content of the text nodes with the result of f, the
+// content of code, pre and mention nodes isn't touched.
+func (d *Document) MapText(f func(string) string) {
+	mapText(d.Nodes, f)
+}
+
+func mapText(nodes []*Node, f func(string) string) {
+	for _, n := range nodes {
+		if n.Kind == Text {
+			n.Text = f(n.Text)
+		}
+		mapText(n.Children, f)
+	}
+}
+
+// Clone returns a deep copy of the document.
+func (d *Document) Clone() *Document {
+	return &Document{Source: d.Source, Nodes: cloneNodes(d.Nodes)}
+}
+
+func cloneNodes(nodes []*Node) []*Node {
+	if nodes == nil {
+		return nil
+	}
+	clone := make([]*Node, len(nodes))
+	for i, n := range nodes {
+		c := *n
+		c.Children = cloneNodes(n.Children)
+		clone[i] = &c
+	}
+	return clone
+}
+
+// Mentions returns the mention nodes of the document.
+func (d *Document) Mentions() []*Node {
+	var mentions []*Node
+	var walk func(nodes []*Node)
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			if n.Kind == Mention {
+				mentions = append(mentions, n)
+			}
+			walk(n.Children)
+		}
+	}
+	walk(d.Nodes)
+	return mentions
+}
+
+// mentionRE matches an @name typed in the text.
+var mentionRE = regexp.MustCompile(`(^|[^\w@])@([\p{L}\p{N}_.-]*[\p{L}\p{N}_])`)
+
+// ReplaceMentions replaces the @name typed in text with the result of
+// replace, the mention is kept when it returns "".
+func ReplaceMentions(text string, replace func(name string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range mentionRE.FindAllStringSubmatchIndex(text, -1) {
+		native := replace(text[m[4]:m[5]])
+		if native == "" {
+			continue
+		}
+		// m[3] is the end of the character before the @
+		b.WriteString(text[last:m[3]])
+		b.WriteString(native)
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// FindMentions turns the @name in the text nodes into mention nodes, for the
+// names known returns true for.
+func (d *Document) FindMentions(known func(name string) bool) {
+	d.Nodes = findMentions(d.Nodes, known)
+}
+
+func findMentions(nodes []*Node, known func(name string) bool) []*Node {
+	var b builder
+	for _, n := range nodes {
+		if n.Kind != Text {
+			if n.Kind != Link {
+				n.Children = findMentions(n.Children, known)
+			}
+			b.add(n)
+			continue
+		}
+		last := 0
+		for _, m := range mentionRE.FindAllStringSubmatchIndex(n.Text, -1) {
+			name := n.Text[m[4]:m[5]]
+			if !known(name) {
+				continue
+			}
+			// m[3] is the end of the character before the @
+			b.writeString(n.Text[last:m[3]])
+			b.add(&Node{Kind: Mention, Text: name})
+			last = m[1]
+		}
+		b.writeString(n.Text[last:])
+	}
+	return b.result()
+}
+
+// Plain returns the text of the document without formatting, links are
+// followed by their URL when it isn't the text of the link.
+func (d *Document) Plain() string {
+	var b strings.Builder
+	renderPlain(&b, d.Nodes)
+	return b.String()
+}
+
+func renderPlain(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text, Code, Pre:
+			b.WriteString(n.Text)
+		case Mention:
+			b.WriteString(mentionName(n))
+		case Link:
+			text := plainText(n.Children)
+			b.WriteString(text)
+			if text != n.URL {
+				b.WriteString(" (" + n.URL + ")")
+			}
+		case Quote:
+			b.WriteString(prefixLines(plainText(n.Children), "> "))
+		default:
+			renderPlain(b, n.Children)
+		}
+	}
+}
+
+func plainText(nodes []*Node) string {
+	var b strings.Builder
+	renderPlain(&b, nodes)
+	return b.String()
+}
+
+// builder collects the nodes of a parser, merging consecutive text.
+type builder struct {
+	nodes []*Node
+	text  strings.Builder
+}
+
+func (b *builder) writeString(s string) {
+	b.text.WriteString(s)
+}
+
+func (b *builder) writeByte(c byte) {
+	b.text.WriteByte(c)
+}
+
+func (b *builder) add(n *Node) {
+	b.flush()
+	b.nodes = append(b.nodes, n)
+}
+
+// addAll adds the nodes, merging the text nodes with the text around them.
+func (b *builder) addAll(nodes ...*Node) {
+	for _, n := range nodes {
+		if n.Kind == Text {
+			b.writeString(n.Text)
+		} else {
+			b.add(n)
+		}
+	}
+}
+
+func (b *builder) flush() {
+	if b.text.Len() == 0 {
+		return
+	}
+	if last := len(b.nodes) - 1; last >= 0 && b.nodes[last].Kind == Text {
+		b.nodes[last].Text += b.text.String()
+	} else {
+		b.nodes = append(b.nodes, &Node{Kind: Text, Text: b.text.String()})
+	}
+	b.text.Reset()
+}
+
+func (b *builder) result() []*Node {
+	b.flush()
+	return b.nodes
+}
+
+// endsWithNewline returns true when the text of the nodes is empty or ends
+// with a line break.
+func (b *builder) endsWithNewline() bool {
+	if b.text.Len() > 0 {
+		return strings.HasSuffix(b.text.String(), "\n")
+	}
+	if len(b.nodes) == 0 {
+		return true
+	}
+	last := b.nodes[len(b.nodes)-1]
+	return last.Kind == Text && strings.HasSuffix(last.Text, "\n")
+}
+
+// isBlock returns true for the kinds that are rendered on lines of their own.
+func isBlock(n *Node) bool {
+	return n.Kind == Pre || n.Kind == Quote
+}
+
+func prefixLines(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
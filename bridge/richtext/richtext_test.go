@@ -0,0 +1,173 @@
+package richtext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		input, html, irc, slack, markdown string
+	}{
+		{
+			input:    "**bold** and *italic*",
+			html:     "<strong>bold</strong> and <em>italic</em>",
+			irc:      "\x02bold\x02 and \x1ditalic\x1d",
+			slack:    "*bold* and _italic_",
+			markdown: "**bold** and *italic*",
+		},
+		{
+			input:    "__bold__ _italic_ ~~gone~~ snake_case",
+			html:     "<strong>bold</strong> <em>italic</em> <del>gone</del> snake_case",
+			irc:      "\x02bold\x02 \x1ditalic\x1d \x1egone\x1e snake_case",
+			slack:    "*bold* _italic_ ~gone~ snake_case",
+			markdown: "**bold** *italic* ~~gone~~ snake_case",
+		},
+		{
+			input:    "***both*** and **bold *nested***",
+			html:     "<strong><em>both</em></strong> and <strong>bold <em>nested</em></strong>",
+			irc:      "\x02\x1dboth\x1d\x02 and \x02bold \x1dnested\x1d\x02",
+			slack:    "*_both_* and *bold _nested_*",
+			markdown: "***both*** and **bold *nested***",
+		},
+		{
+			input:    "use `a*b` or 2 * 3 \\*not\\*",
+			html:     "use <code>a*b</code> or 2 * 3 *not*",
+			irc:      "use \x11a*b\x11 or 2 * 3 *not*",
+			slack:    "use `a*b` or 2 * 3 *not*",
+			markdown: "use `a*b` or 2 \\* 3 \\*not\\*",
+		},
+		{
+			input:    "see [the docs](https://example.com/a_b) or https://example.com/c_d",
+			html:     `see <a href="https://example.com/a_b">the docs</a> or https://example.com/c_d`,
+			irc:      "see the docs (https://example.com/a_b) or https://example.com/c_d",
+			slack:    "see <https://example.com/a_b|the docs> or https://example.com/c_d",
+			markdown: "see [the docs](https://example.com/a_b) or https://example.com/c_d",
+		},
+		{
+			input:    "code:\n```go\nif a < b {\n}\n```\ndone",
+			html:     "code:<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>done",
+			irc:      "code:\n\x11if a < b {\x11\n\x11}\x11\ndone",
+			slack:    "code:\n```\nif a &lt; b {\n}\n```\ndone",
+			markdown: "code:\n```go\nif a < b {\n}\n```\ndone",
+		},
+		{
+			input:    "> quoted **text**\n> more\nreply",
+			html:     "<blockquote>quoted <strong>text</strong><br>more</blockquote>reply",
+			irc:      "> quoted \x02text\x02\n> more\nreply",
+			slack:    "> quoted *text*\n> more\nreply",
+			markdown: "> quoted **text**\n> more\nreply",
+		},
+		{
+			input:    ">_< is not a quote, **unclosed and <b>",
+			html:     "&gt;_&lt; is not a quote, **unclosed and &lt;b&gt;",
+			irc:      ">_< is not a quote, **unclosed and <b>",
+			slack:    "&gt;_&lt; is not a quote, **unclosed and &lt;b&gt;",
+			markdown: ">\\_< is not a quote, \\*\\*unclosed and <b>",
+		},
+	} {
+		doc := ParseMarkdown(tc.input)
+		assert.Equal(t, tc.input, doc.Source)
+		assert.Equal(t, tc.html, RenderHTML(doc, "<br>"), tc.input)
+		assert.Equal(t, tc.irc, RenderIRC(doc), tc.input)
+		assert.Equal(t, tc.slack, RenderSlack(doc), tc.input)
+		assert.Equal(t, tc.markdown, RenderMarkdown(doc), tc.input)
+	}
+}
+
+func TestParseIRC(t *testing.T) {
+	for input, expected := range map[string]string{
+		"\x02bold\x02 plain":                  "**bold** plain",
+		"\x02bold \x1dboth\x0f plain":         "**bold *both*** plain",
+		"\x0304red\x03 \x0304,12on blue\x03":  "red on blue",
+		"\x04ff0000hex\x04 \x1funderline\x1f": "hex underline",
+		"\x11mono\x11 \x1estrike":             "`mono` ~~strike~~",
+		"2*3=6":                               "2\\*3=6",
+	} {
+		assert.Equal(t, expected, RenderMarkdown(ParseIRC(input)), input)
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	for input, expected := range map[string]string{
+		"<strong>bold</strong> <em>it</em> <del>no</del>":                            "**bold** *it* ~~no~~",
+		"<mx-reply><blockquote>old</blockquote></mx-reply>new <b>text</b>":           "new **text**",
+		`<a href="https://matrix.to/#/@alice:example.org">Alice</a>: hi`:             "@Alice: hi",
+		`<a href="https://example.com">site</a> &amp; <code>x &lt; y</code>`:         "[site](https://example.com) & `x < y`",
+		"<p>one</p>\n<p>two<br>three</p>":                                            "one\ntwo\nthree",
+		"<blockquote><p>quote</p></blockquote><p>reply</p>":                          "> quote\nreply",
+		"<pre><code class=\"language-go\">a := 1\n</code></pre>":                     "```go\na := 1\n```",
+		"<h1>title</h1><ul><li>one</li><li>two</li></ul>":                            "**title**\n- one\n- two",
+		`<img alt=":smile:" src="mxc://x"> <span data-mx-color="red">colored</span>`: ":smile: colored",
+	} {
+		assert.Equal(t, expected, RenderMarkdown(ParseHTML(input)), input)
+	}
+
+	doc := ParseHTML(`<a href="https://matrix.to/#/@alice:example.org">Alice</a>`)
+	assert.Equal(t, []*Node{{Kind: Mention, UserID: "@alice:example.org", Text: "Alice"}}, doc.Nodes)
+	assert.Equal(t, `<a href="https://matrix.to/#/@alice:example.org">Alice</a>`, RenderHTML(doc, "<br>"))
+}
+
+func TestParseSlack(t *testing.T) {
+	users := map[string]string{"U1": "alice"}
+	resolve := func(id string) string { return users[id] }
+	for input, expected := range map[string]string{
+		"*bold* _it_ ~no~ `c`":                                     "**bold** *it* ~~no~~ `c`",
+		"not*bold* snake_case_name":                                "not\\*bold\\* snake_case_name",
+		"hi <@U1> and <@U2|bob> in <#C1|general>":                  "hi @alice and @bob in #general",
+		"<!here> <https://example.com|site> <https://example.com>": "@here [site](https://example.com) https://example.com",
+		"&gt; quote\nx &lt; y &amp;&amp; z":                        "> quote\nx < y && z",
+		"```multi\nline```":                                        "```\nmulti\nline\n```",
+	} {
+		assert.Equal(t, expected, RenderMarkdown(ParseSlack(input, resolve)), input)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := ParseMarkdown("**hello** `world` [link](https://example.com) @x")
+	doc.MapText(strings.ToUpper)
+	assert.Equal(t, "**HELLO** `world` [LINK](https://example.com) @X", RenderMarkdown(doc))
+	assert.Equal(t, "HELLO world LINK (https://example.com) @X", doc.Plain())
+
+	doc = ParseMarkdown("> quote\n[https://example.com](https://example.com)")
+	assert.Equal(t, "> quote\nhttps://example.com", doc.Plain())
+}
+
+func TestParseSpans(t *testing.T) {
+	// offsets count UTF-16 code units, the emoji takes two
+	doc := ParseSpans("😄 bold italic link code", []Span{
+		{Offset: 3, Length: 11, Kind: Bold},
+		{Offset: 8, Length: 6, Kind: Italic},
+		{Offset: 15, Length: 4, Kind: Link, URL: "https://example.com"},
+		{Offset: 20, Length: 4, Kind: Code},
+		{Offset: 16, Length: 10, Kind: Bold},
+	})
+	assert.Equal(t, "😄 **bold *italic*** [link](https://example.com) `code`", RenderMarkdown(doc))
+}
+
+func TestMentions(t *testing.T) {
+	known := func(name string) bool { return name == "alice" || name == "bob.b" }
+	doc := ParseMarkdown("**@alice** mail@alice.org @carol `@bob.b` @bob.b.")
+	doc.FindMentions(known)
+	mentions := doc.Mentions()
+	if assert.Len(t, mentions, 2) {
+		assert.Equal(t, "alice", mentions[0].Text)
+		assert.Equal(t, "bob.b", mentions[1].Text)
+		mentions[1].Native = "<@2>"
+	}
+	assert.Equal(t, "**@alice** mail@alice.org @carol `@bob.b` <@2>.", RenderMarkdown(doc))
+
+	clone := doc.Clone()
+	clone.Mentions()[0].Native = "<@1>"
+	assert.Equal(t, "", doc.Mentions()[0].Native)
+
+	text := ReplaceMentions("@alice, hi @carol and @bob.b", func(name string) string {
+		if known(name) {
+			return "<" + name + ">"
+		}
+		return ""
+	})
+	assert.Equal(t, "<alice>, hi @carol and <bob.b>", text)
+}
@@ -0,0 +1,73 @@
+package richtext
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Span formats a part of a text, like the entities of Telegram messages.
+// Offset and Length count UTF-16 code units.
+type Span struct {
+	Offset int
+	Length int
+	Kind   Kind
+	// URL of a Link, Lang of a Pre and UserID of a Mention span.
+	URL    string
+	Lang   string
+	UserID string
+}
+
+// ParseSpans returns the document of text formatted by the spans. Spans may
+// nest but not overlap, overlapping spans are ignored.
+func ParseSpans(text string, spans []Span) *Document {
+	u := utf16.Encode([]rune(text))
+	sorted := make([]Span, 0, len(spans))
+	for _, s := range spans {
+		if s.Offset < 0 || s.Length <= 0 || s.Offset >= len(u) {
+			continue
+		}
+		if s.Offset+s.Length > len(u) {
+			s.Length = len(u) - s.Offset
+		}
+		sorted = append(sorted, s)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset {
+			return sorted[i].Offset < sorted[j].Offset
+		}
+		return sorted[i].Length > sorted[j].Length
+	})
+	return &Document{Source: text, Nodes: spanNodes(u, 0, len(u), sorted)}
+}
+
+func spanNodes(u []uint16, start, end int, spans []Span) []*Node {
+	var b builder
+	pos := start
+	for i := 0; i < len(spans); {
+		s := spans[i]
+		spanEnd := s.Offset + s.Length
+		if s.Offset < pos || spanEnd > end {
+			i++
+			continue
+		}
+		b.writeString(string(utf16.Decode(u[pos:s.Offset])))
+		j := i + 1
+		for j < len(spans) && spans[j].Offset < spanEnd {
+			j++
+		}
+		text := string(utf16.Decode(u[s.Offset:spanEnd]))
+		switch s.Kind {
+		case Code, Pre:
+			b.add(&Node{Kind: s.Kind, Text: strings.TrimSuffix(text, "\n"), Lang: s.Lang})
+		case Mention:
+			b.add(&Node{Kind: Mention, Text: strings.TrimPrefix(text, "@"), UserID: s.UserID})
+		default:
+			b.add(&Node{Kind: s.Kind, URL: s.URL, Children: spanNodes(u, s.Offset, spanEnd, spans[i+1:j])})
+		}
+		pos = spanEnd
+		i = j
+	}
+	b.writeString(string(utf16.Decode(u[pos:end])))
+	return b.result()
+}
@@ -54,6 +54,11 @@ func (b *Brocketchat) Command(cmd string) string {
 	return ""
 }
 
+func (b *Brocketchat) EditsMessages()   {}
+func (b *Brocketchat) DeletesMessages() {}
+func (b *Brocketchat) SendsTyping()     {}
+func (b *Brocketchat) UploadsFiles()    {}
+
 func (b *Brocketchat) Connect() error {
 	if b.GetString("WebhookBindAddress") != "" {
 		if err := b.doConnectWebhookBind(); err != nil {
@@ -114,6 +119,14 @@ func (b *Brocketchat) Send(msg config.Message) (string, error) {
 	msg.Channel = strings.TrimPrefix(msg.Channel, "#")
 	channel := &models.Channel{ID: b.getChannelID(msg.Channel), Name: msg.Channel}
 
+	// the realtime API can send typing, we don't get the typing of others
+	if msg.Event == config.EventUserTyping {
+		if b.GetBool("ShowUserTyping") && b.c != nil {
+			return "", b.c.StartTyping(channel.ID, b.user.UserName)
+		}
+		return "", nil
+	}
+
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
 		msg.Text = "_" + msg.Text + "_"
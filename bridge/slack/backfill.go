@@ -0,0 +1,58 @@
+package bslack
+
+import (
+	"strconv"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/slack-go/slack"
+)
+
+// handleBackfill relays the messages of the channel of an EventBackfill that
+// were posted after the message with its ID.
+func (b *Bslack) handleBackfill(rmsg *config.Message) bool {
+	if rmsg.Event != config.EventBackfill {
+		return false
+	}
+	if err := b.backfill(rmsg); err != nil {
+		b.Log.Errorf("Backfill of %s failed: %s", rmsg.Channel, err)
+	}
+	return true
+}
+
+func (b *Bslack) backfill(rmsg *config.Message) error {
+	channelInfo, err := b.channels.getChannel(rmsg.Channel)
+	if err != nil {
+		return err
+	}
+	// the ID of a message is its timestamp
+	oldest := rmsg.ID
+	if oldest == "" {
+		oldest = strconv.FormatInt(rmsg.Timestamp.Unix(), 10)
+	}
+	resp, err := b.sc.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channelInfo.ID,
+		Oldest:    oldest,
+		Limit:     b.BackfillLimit(),
+	})
+	if err != nil {
+		return err
+	}
+	b.Log.Debugf("Backfilling %d messages of %s", len(resp.Messages), rmsg.Channel)
+	// the history is newest first
+	for i := len(resp.Messages) - 1; i >= 0; i-- {
+		ev := slack.MessageEvent(resp.Messages[i])
+		ev.Channel = channelInfo.ID
+		// skip the messages we relayed, also the ones of earlier runs
+		if ev.User == b.botUserID || b.skipMessageEvent(&ev) {
+			continue
+		}
+		msg, err := b.handleMessageEvent(&ev)
+		if err != nil {
+			b.Log.Errorf("Could not backfill message: %s", err)
+			continue
+		}
+		msg.Backfill = true
+		b.messages <- msg
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package bslack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/conversations.history", r.URL.Path)
+		assert.Equal(t, "C1", r.FormValue("channel"))
+		assert.Equal(t, "1.1", r.FormValue("oldest"))
+		fmt.Fprint(w, `{"ok":true,"messages":[
+			{"type":"message","user":"U1","text":"second","ts":"1700000060.000200"},
+			{"type":"message","user":"UBOT","text":"relayed","ts":"1700000030.000000"},
+			{"type":"message","user":"U1","text":"first","ts":"1700000000.000100"}]}`)
+	}))
+	defer srv.Close()
+
+	b := newTestEventsBridge(t)
+	b.sc = slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+	done := make(chan bool)
+	go func() {
+		done <- b.handleBackfill(&config.Message{Event: config.EventBackfill, Channel: "general", ID: "1.1"})
+	}()
+
+	for _, text := range []string{"first", "second"} {
+		select {
+		case msg := <-b.messages:
+			assert.Equal(t, text, msg.Text)
+			assert.Equal(t, "general", msg.Channel)
+			assert.Equal(t, "Alice", msg.Username)
+			assert.True(t, msg.Backfill)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not backfilled", text)
+		}
+	}
+	require.True(t, <-done)
+	assert.False(t, b.handleBackfill(&config.Message{Text: "hello"}))
+}
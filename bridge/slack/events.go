@@ -0,0 +1,267 @@
+package bslack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/slack-go/slack"
+)
+
+const (
+	eventsBindAddressConfig = "EventsBindAddress"
+	eventsPathConfig        = "EventsPath"
+	signingSecretConfig     = "SigningSecret"
+
+	defaultEventsBindAddress = ":3000"
+	defaultEventsPath        = "/slack/events"
+
+	// eventsReplayWindow is how long a signed request is valid, it's fixed
+	// by slack.NewSecretsVerifier. Events that are delivered again within it
+	// are ignored.
+	eventsReplayWindow = 5 * time.Minute
+	// maxEventsRequestSize is the maximum size of the body of a request.
+	maxEventsRequestSize = 1 << 20
+	cEventID             = "event_id"
+)
+
+// eventsRequest is a request of the Slack Events API, see
+// https://api.slack.com/apis/connections/events-api
+type eventsRequest struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// startEventsAPI starts the server receiving the requests of the Slack Events
+// API, their events are sent on b.events.
+func (b *Bslack) startEventsAPI() error {
+	addr := b.GetString(eventsBindAddressConfig)
+	if addr == "" {
+		addr = defaultEventsBindAddress
+	}
+	path := b.GetString(eventsPathConfig)
+	if path == "" {
+		path = defaultEventsPath
+	}
+	// without it anyone reaching the server could send messages
+	if b.GetString(signingSecretConfig) == "" {
+		return fmt.Errorf("the Events API needs %s, or enable UseSocketMode", signingSecretConfig)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("events API failed to listen on %s: %s", addr, err)
+	}
+	b.events = make(chan json.RawMessage, 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, b.handleEventsRequest)
+	b.eventServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	b.Log.Infof("Receiving events on %s%s", addr, path)
+	go func() {
+		if err := b.eventServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			b.Log.Errorf("Slack event server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// handleEventsRequest verifies a request of the Events API and queues its
+// event. Slack expects an answer within 3 seconds, the events are handled
+// afterwards.
+func (b *Bslack) handleEventsRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventsRequestSize))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := b.verifyEventsRequest(r.Header, body); err != nil {
+		b.Log.Warnf("Rejecting events request from %s: %s", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req eventsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"challenge": req.Challenge})
+		return
+	case "event_callback":
+		if !b.queueEvent(&req) {
+			// Slack delivers the event again later
+			http.Error(w, "too many events", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// queueEvent sends the event of req to b.events, unless it was delivered
+// already. It doesn't wait when b.events is full, Slack wouldn't get the
+// answer in time, and returns false.
+func (b *Bslack) queueEvent(req *eventsRequest) bool {
+	if b.seenEvent(req.EventID) {
+		b.Log.Debugf("Ignoring event %s, it was delivered already", req.EventID)
+		return true
+	}
+	select {
+	case b.events <- req.Event:
+		return true
+	default:
+		b.Log.Errorf("Dropping event %s, too many events are waiting", req.EventID)
+		b.cache.Remove(cEventID + req.EventID)
+		return false
+	}
+}
+
+// verifyEventsRequest checks the signature of a request with the signing
+// secret, and that it isn't older than eventsReplayWindow.
+func (b *Bslack) verifyEventsRequest(header http.Header, body []byte) error {
+	secret := b.GetString(signingSecretConfig)
+	if secret == "" {
+		return fmt.Errorf("no %s configured", signingSecretConfig)
+	}
+	sv, err := slack.NewSecretsVerifier(header, secret)
+	if err != nil {
+		return err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return err
+	}
+	return sv.Ensure()
+}
+
+// seenEvent returns true if the event with the ID was received within the
+// replay window, Slack delivers events again when it didn't get our answer
+// in time.
+func (b *Bslack) seenEvent(id string) bool {
+	if id == "" {
+		return false
+	}
+	if ts, ok := b.cache.Get(cEventID + id); ok && time.Since(ts.(time.Time)) < eventsReplayWindow {
+		return true
+	}
+	b.cache.Add(cEventID+id, time.Now())
+	return false
+}
+
+// handleEvents converts the events received by the Events API or Socket Mode
+// to messages.
+func (b *Bslack) handleEvents(messages chan *config.Message) {
+	for raw := range b.events {
+		rmsg, err := b.handleEvent(raw)
+		switch {
+		case err == ErrEventIgnored:
+			continue
+		case err != nil:
+			b.Log.Errorf("Could not handle event: %s", err)
+			continue
+		}
+		messages <- rmsg
+	}
+}
+
+// handleEvent returns the message for an event of the Events API, the events
+// have the same format as the ones of the RTM API.
+func (b *Bslack) handleEvent(raw json.RawMessage) (*config.Message, error) {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case "message":
+		ev := &slack.MessageEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		if b.skipMessageEvent(ev) {
+			b.Log.Debugf("Skipped message: %#v", ev)
+			return nil, ErrEventIgnored
+		}
+		return b.handleMessageEvent(ev)
+	case "file_deleted":
+		ev := &slack.FileDeletedEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		return b.handleFileDeletedEvent(ev)
+	case "reaction_added", "reaction_removed":
+		ev := &slack.ReactionEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		return b.handleReactionEvent(ev)
+	case "member_joined_channel":
+		ev := &slack.MemberJoinedChannelEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		if ev.User == b.botUserID {
+			// we're a member of the channel now
+			b.channels.populateChannels(false)
+		} else {
+			b.users.populateUser(ev.User)
+		}
+	case "channel_created", "channel_rename", "group_rename":
+		b.channels.populateChannels(false)
+	case "user_change":
+		ev := &slack.UserChangeEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		b.users.invalidateUser(ev.User.ID)
+	default:
+		b.Log.Debugf("Unhandled event %s", event.Type)
+	}
+	return nil, ErrEventIgnored
+}
+
+// handleReactionEvent relays a reaction to a message.
+func (b *Bslack) handleReactionEvent(ev *slack.ReactionEvent) (*config.Message, error) {
+	// Ignore the reactions we relayed and reactions to files
+	if ev.User == b.botUserID || ev.Item.Type != "message" {
+		return nil, ErrEventIgnored
+	}
+	channel, err := b.channels.getChannelByID(ev.Item.Channel)
+	if err != nil {
+		return nil, err
+	}
+	rmsg := &config.Message{
+		Channel:  channel.Name,
+		Username: b.users.getUsername(ev.User),
+		UserID:   ev.User,
+		Account:  b.Account,
+		Event:    config.EventReaction,
+		ParentID: ev.Item.Timestamp,
+		Protocol: b.Protocol,
+		Reaction: &config.Reaction{
+			Emoji:   helper.EmojiFromName(ev.Reaction),
+			Removed: ev.Type == "reaction_removed",
+		},
+	}
+	if b.useChannelID {
+		rmsg.Channel = "ID:" + channel.ID
+	}
+	return rmsg, nil
+}
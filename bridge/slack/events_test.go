@@ -0,0 +1,164 @@
+package bslack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEventsBridge(t *testing.T) *Bslack {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "slack.test"})
+	br.Log = logrus.NewEntry(logger)
+	br.Config = config.NewConfigFromString(logger, []byte(`
+[slack.test]
+SigningSecret="secret"
+`))
+	b := newBridge(&bridge.Config{Bridge: br})
+	b.events = make(chan json.RawMessage, 10)
+	b.botUserID = "UBOT"
+	b.channels = newChannelManager(br.Log, nil)
+	b.channels.registerChannel(slack.Channel{GroupConversation: slack.GroupConversation{
+		Name: "general", Conversation: slack.Conversation{ID: "C1"},
+	}})
+	b.users = newUserManager(br.Log, nil)
+	b.users.users["U1"] = &slack.User{ID: "U1", Name: "alice", Profile: slack.UserProfile{DisplayName: "Alice"}}
+	return b
+}
+
+func signedEventsRequest(body string, ts time.Time, secret string) *http.Request {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + stamp + ":" + body))
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", stamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandleEventsRequest(t *testing.T) {
+	b := newTestEventsBridge(t)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		b.handleEventsRequest(rec, req)
+		return rec
+	}
+	event := `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hi","ts":"1.1"}}`
+
+	rec := serve(signedEventsRequest(`{"type":"url_verification","challenge":"abc"}`, time.Now(), "secret"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"challenge":"abc"}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusOK, serve(signedEventsRequest(event, time.Now(), "secret")).Code)
+	require.Len(t, b.events, 1)
+	assert.JSONEq(t, `{"type":"message","channel":"C1","user":"U1","text":"hi","ts":"1.1"}`, string(<-b.events))
+
+	// retries of Slack are acknowledged but not relayed again
+	assert.Equal(t, http.StatusOK, serve(signedEventsRequest(event, time.Now(), "secret")).Code)
+	assert.Empty(t, b.events)
+
+	other := strings.Replace(event, "Ev1", "Ev2", 1)
+	assert.Equal(t, http.StatusUnauthorized, serve(signedEventsRequest(other, time.Now(), "wrong")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(signedEventsRequest(other, time.Now().Add(-10*time.Minute), "secret")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(other))).Code)
+	assert.Empty(t, b.events)
+}
+
+func TestHandleEventsRequestFull(t *testing.T) {
+	b := newTestEventsBridge(t)
+	b.events = make(chan json.RawMessage, 1)
+	event := `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"hi","ts":"1.1"}}`
+	other := strings.Replace(event, "Ev1", "Ev2", 1)
+	serve := func(body string) int {
+		rec := httptest.NewRecorder()
+		b.handleEventsRequest(rec, signedEventsRequest(body, time.Now(), "secret"))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(event))
+	// a full queue doesn't block, Slack retries the event later
+	assert.Equal(t, http.StatusServiceUnavailable, serve(other))
+	<-b.events
+	assert.Equal(t, http.StatusOK, serve(other))
+	assert.Len(t, b.events, 1)
+}
+
+func TestEventsAPIWithoutSigningSecret(t *testing.T) {
+	b := newTestEventsBridge(t)
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	b.Bridge.Config = config.NewConfigFromString(logger, []byte(`
+[slack.test]
+EventsBindAddress="127.0.0.1:0"
+`))
+	assert.Error(t, b.startEventsAPI())
+	assert.Nil(t, b.eventServer)
+
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(`{"type":"url_verification","challenge":"abc"}`))
+	rec := httptest.NewRecorder()
+	b.handleEventsRequest(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestHandleEvent(t *testing.T) {
+	b := newTestEventsBridge(t)
+	handle := func(event string) *config.Message {
+		rmsg, err := b.handleEvent([]byte(event))
+		require.NoError(t, err)
+		return rmsg
+	}
+
+	rmsg := handle(`{"type":"message","channel":"C1","user":"U1","text":"hi","ts":"1.2","thread_ts":"1.1"}`)
+	assert.Equal(t, "hi", rmsg.Text)
+	assert.Equal(t, "general", rmsg.Channel)
+	assert.Equal(t, "Alice", rmsg.Username)
+	assert.Equal(t, "U1", rmsg.UserID)
+	assert.Equal(t, "1.2", rmsg.ID)
+	assert.Equal(t, "1.1", rmsg.ParentID)
+
+	rmsg = handle(`{"type":"message","subtype":"message_changed","channel":"C1","ts":"1.3",
+		"message":{"type":"message","user":"U1","text":"hello","ts":"1.2","edited":{"user":"U1","ts":"1.3"}}}`)
+	assert.Equal(t, "1.2", rmsg.ID)
+	assert.Equal(t, "hello", rmsg.Text)
+
+	rmsg = handle(`{"type":"message","subtype":"message_deleted","channel":"C1","ts":"1.4","deleted_ts":"1.2"}`)
+	assert.Equal(t, config.EventMsgDelete, rmsg.Event)
+	assert.Equal(t, "1.2", rmsg.ID)
+
+	rmsg = handle(`{"type":"reaction_added","user":"U1","reaction":"thumbsup","item":{"type":"message","channel":"C1","ts":"1.2"}}`)
+	assert.Equal(t, config.EventReaction, rmsg.Event)
+	assert.Equal(t, "1.2", rmsg.ParentID)
+	assert.Equal(t, "Alice", rmsg.Username)
+	assert.False(t, rmsg.Reaction.Removed)
+
+	b.cache.Add(cfileDownloadChannel+"F1", "C1")
+	rmsg = handle(`{"type":"file_deleted","file_id":"F1"}`)
+	assert.Equal(t, config.EventFileDelete, rmsg.Event)
+	assert.Equal(t, "F1", rmsg.ID)
+
+	for _, event := range []string{
+		// the messages and reactions we relayed
+		`{"type":"message","channel":"C1","user":"UBOT","text":"hi","ts":"1.6","blocks":[{"type":"section","block_id":"matterbridge_` + b.uuid + `","text":{"type":"mrkdwn","text":"hi"}}]}`,
+		`{"type":"reaction_added","user":"UBOT","reaction":"thumbsup","item":{"type":"message","channel":"C1","ts":"1.2"}}`,
+		`{"type":"app_mention","user":"U1","text":"hi"}`,
+	} {
+		_, err := b.handleEvent([]byte(event))
+		assert.Equal(t, ErrEventIgnored, err, event)
+	}
+}
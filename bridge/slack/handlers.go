@@ -8,6 +8,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/slack-go/slack"
 )
 
@@ -15,12 +16,13 @@ import (
 var ErrEventIgnored = errors.New("this event message should ignored")
 
 func (b *Bslack) handleSlack() {
-	messages := make(chan *config.Message)
+	messages := b.messages
 	if b.GetString(incomingWebhookConfig) != "" && b.GetString(tokenConfig) == "" {
 		b.Log.Debugf("Choosing webhooks based receiving")
 		go b.handleMatterHook(messages)
 	} else {
-		b.Log.Debugf("Slack is using the Web API + Events. Messages will be received via /slack/events")
+		b.Log.Debugf("Choosing Events API or Socket Mode based receiving")
+		go b.handleEvents(messages)
 	}
 	time.Sleep(time.Second)
 	b.Log.Debug("Start listening for Slack messages")
@@ -29,6 +31,7 @@ func (b *Bslack) handleSlack() {
 		if message.Event != config.EventUserTyping && message.Event != config.EventMsgDelete &&
 			message.Event != config.EventFileDelete {
 			b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
+			formatted := richtext.ParseSlack(message.Text, b.users.getUsername)
 			// cleanup the message
 			message.Text = b.replaceMention(message.Text)
 			message.Text = b.replaceVariable(message.Text)
@@ -36,6 +39,10 @@ func (b *Bslack) handleSlack() {
 			message.Text = b.replaceURL(message.Text)
 			message.Text = b.replaceb0rkedMarkDown(message.Text)
 			message.Text = html.UnescapeString(message.Text)
+			if message.Event == "" || message.Event == config.EventUserAction {
+				formatted.Source = message.Text
+				message.Formatted = formatted
+			}
 
 			// Add the avatar
 			message.Avatar = b.users.getAvatar(message.UserID)
@@ -313,18 +320,8 @@ func (b *Bslack) handleGetChannelMembers(rmsg *config.Message) bool {
 		return false
 	}
 
-	cMembers := b.channels.getChannelMembers(b.users)
-
-	extra := make(map[string][]interface{})
-	extra[config.EventGetChannelMembers] = append(extra[config.EventGetChannelMembers], cMembers)
-	msg := config.Message{
-		Extra:   extra,
-		Event:   config.EventGetChannelMembers,
-		Account: b.Account,
-	}
-
-	b.Log.Debugf("sending msg to remote %#v", msg)
-	b.Remote <- msg
+	b.Log.Debugf("sending channel members of %s to remote", b.Account)
+	b.SendChannelMembers(b.channels.getChannelMembers(b.users))
 
 	return true
 }
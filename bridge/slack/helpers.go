@@ -3,6 +3,7 @@ package bslack
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +30,8 @@ func (b *Bslack) populateReceivedMessage(ev *slack.MessageEvent) (*config.Messag
 		ParentID: ev.ThreadTimestamp,
 		Protocol: b.Protocol,
 	}
+	// the ID of a message is the time it was sent
+	rmsg.Timestamp = parseSlackTimestamp(ev.Timestamp)
 	if b.useChannelID {
 		rmsg.Channel = "ID:" + channel.ID
 	}
@@ -55,6 +58,21 @@ func (b *Bslack) populateReceivedMessage(ev *slack.MessageEvent) (*config.Messag
 	return rmsg, err
 }
 
+// parseSlackTimestamp returns the time of a Slack timestamp like
+// 1503435956.000247.
+func parseSlackTimestamp(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	var usec int64
+	if len(parts) == 2 {
+		usec, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(sec, usec*int64(time.Microsecond))
+}
+
 func (b *Bslack) populateMessageWithUserInfo(ev *slack.MessageEvent, rmsg *config.Message) error {
 	if ev.SubType == sMessageDeleted || ev.SubType == sFileComment {
 		return nil
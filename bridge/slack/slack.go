@@ -2,10 +2,10 @@ package bslack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -14,6 +14,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/42wim/matterbridge/matterhook"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/rs/xid"
@@ -29,8 +30,15 @@ type Bslack struct {
 
 	cache        *lru.Cache
 	uuid         string
+	botUserID    string
 	useChannelID bool
 	eventServer  *http.Server
+	// events are the events received by the Events API or Socket Mode.
+	events           chan json.RawMessage
+	socketModeCancel context.CancelFunc
+	// messages are the received messages waiting for their cleanup by
+	// handleSlack.
+	messages chan *config.Message
 
 	channels *channels
 	users    *users
@@ -85,9 +93,10 @@ func newBridge(cfg *bridge.Config) *Bslack {
 		cfg.Log.Fatalf("Could not create LRU cache for Slack bridge: %v", err)
 	}
 	b := &Bslack{
-		Config: cfg,
-		uuid:   xid.New().String(),
-		cache:  newCache,
+		Config:   cfg,
+		uuid:     xid.New().String(),
+		cache:    newCache,
+		messages: make(chan *config.Message),
 	}
 	return b
 }
@@ -96,6 +105,16 @@ func (b *Bslack) Command(cmd string) string {
 	return ""
 }
 
+func (b *Bslack) EditsMessages()    {}
+func (b *Bslack) DeletesMessages()  {}
+func (b *Bslack) PreservesThreads() {}
+func (b *Bslack) SendsReactions()   {}
+func (b *Bslack) SendsTyping()      {}
+func (b *Bslack) SetsTopic()        {}
+func (b *Bslack) UploadsFiles()     {}
+func (b *Bslack) ListsMembers()     {}
+func (b *Bslack) Backfills()        {}
+
 func (b *Bslack) Connect() error {
 	b.RLock()
 	defer b.RUnlock()
@@ -104,7 +123,8 @@ func (b *Bslack) Connect() error {
 		return errors.New("no connection method found: WebhookBindAddress, WebhookURL or Token need to be configured")
 	}
 
-	// If we have a token we use the Slack websocket-based RTM for both sending and receiving.
+	// If we have a token we use the Web API for sending and the Events API or
+	// Socket Mode for receiving.
 	token := b.GetString("TokenBot")
 	if token == "" {
 		token = b.GetString(tokenConfig)
@@ -112,30 +132,33 @@ func (b *Bslack) Connect() error {
 	if token != "" {
 		b.Log.Info("Connecting using token")
 
-		b.sc = slack.New(token, slack.OptionDebug(b.GetBool("Debug")))
+		useSocketMode := b.GetBool(useSocketModeConfig)
+		if useSocketMode && b.GetString(appTokenConfig) == "" {
+			return errors.New("UseSocketMode needs an app-level token in AppToken")
+		}
+		b.sc = slack.New(token, slack.OptionDebug(b.GetBool("Debug")), slack.OptionAppLevelToken(b.GetString(appTokenConfig)))
 
 		b.channels = newChannelManager(b.Log, b.sc)
 		b.users = newUserManager(b.Log, b.sc)
 
-		mux := http.NewServeMux()
-		mux.HandleFunc("/slack/events", b.handleSlackEvents) // we'll define this function next
-
-		b.eventServer = &http.Server{
-			Addr:    ":3000", // you can make this configurable
-			Handler: mux,
+		// needed to ignore the reactions we relayed
+		if resp, err := b.sc.AuthTest(); err == nil {
+			b.botUserID = resp.UserID
+		} else {
+			b.Log.Warnf("Could not get the user ID of the bot: %s", err)
 		}
 
-		go func() {
-			if err := b.eventServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				b.Log.Errorf("Slack event server error: %v", err)
-			}
-		}()
-
-		/*
-			b.rtm = b.sc.NewRTM()
-			go b.rtm.ManageConnection()
-			go b.handleSlack()
-			return nil */
+		if useSocketMode {
+			b.Log.Info("Receiving events with Socket Mode")
+			ctx, cancel := context.WithCancel(context.Background())
+			b.socketModeCancel = cancel
+			b.events = make(chan json.RawMessage, 100)
+			go b.manageSocketMode(ctx)
+		} else if err := b.startEventsAPI(); err != nil {
+			return err
+		}
+		go b.handleSlack()
+		return nil
 	}
 
 	// In absence of a token we fall back to incoming and outgoing Webhooks.
@@ -160,6 +183,9 @@ func (b *Bslack) Connect() error {
 }
 
 func (b *Bslack) Disconnect() error {
+	if b.socketModeCancel != nil {
+		b.socketModeCancel()
+	}
 	if b.eventServer != nil {
 		return b.eventServer.Close()
 	}
@@ -217,6 +243,9 @@ func (b *Bslack) Send(msg config.Message) (string, error) {
 		b.Log.Debugf("=> Receiving %#v", msg)
 	}
 
+	if doc := msg.RichText(); doc != nil {
+		msg.Text = richtext.RenderSlack(doc)
+	}
 	msg.Text = helper.ClipMessage(msg.Text, messageLength, b.GetString("MessageClipped"))
 	msg.Text = b.replaceCodeFence(msg.Text)
 
@@ -301,6 +330,9 @@ func (b *Bslack) sendAPI(msg config.Message) (string, error) {
 	if handled := b.handleGetChannelMembers(&msg); handled {
 		return "", nil
 	}
+	if handled := b.handleBackfill(&msg); handled {
+		return "", nil
+	}
 
 	channelInfo, err := b.channels.getChannel(msg.Channel)
 	if err != nil {
@@ -313,6 +345,10 @@ func (b *Bslack) sendAPI(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg, channelInfo)
+	}
+
 	var handled bool
 
 	// Handle topic/purpose updates.
@@ -427,6 +463,28 @@ func (b *Bslack) deleteMessage(msg *config.Message, channelInfo *slack.Channel)
 	}
 }
 
+// sendReaction adds or removes the reaction of the bot to the message in ParentID.
+func (b *Bslack) sendReaction(msg *config.Message, channelInfo *slack.Channel) error {
+	name := helper.EmojiName(msg.Reaction.Emoji)
+	ref := slack.NewRefToMessage(channelInfo.ID, msg.ParentID)
+	for {
+		var err error
+		if msg.Reaction.Removed {
+			err = b.sc.RemoveReaction(name, ref)
+		} else {
+			err = b.sc.AddReaction(name, ref)
+		}
+		if err == nil {
+			return nil
+		}
+
+		if err = handleRateLimit(b.Log, err); err != nil {
+			b.Log.Errorf("Failed to send reaction to Slack: %#v", err)
+			return err
+		}
+	}
+}
+
 func (b *Bslack) editMessage(msg *config.Message, channelInfo *slack.Channel) (bool, error) {
 	if msg.ID == "" {
 		return false, nil
@@ -587,73 +645,3 @@ func extractStringField(data map[string]interface{}, field string) string {
 	}
 	return ""
 }
-
-func (b *Bslack) handleSlackEvents(w http.ResponseWriter, r *http.Request) {
-	var evt SlackEventWrapper
-	body, _ := io.ReadAll(r.Body)
-	b.Log.Infof("Received raw Slack event: %s", string(body))
-	_ = json.Unmarshal(body, &evt)
-
-	if evt.Type == "url_verification" {
-		var challenge struct {
-			Challenge string `json:"challenge"`
-		}
-		if err := json.Unmarshal(body, &challenge); err != nil {
-			b.Log.Errorf("Could not parse challenge: %v", err)
-			http.Error(w, "bad request", http.StatusBadRequest)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		resp := map[string]string{"challenge": challenge.Challenge}
-		json.NewEncoder(w).Encode(resp)
-		return
-	}
-
-	if evt.Type == "event_callback" && evt.Event.Type == "message" && evt.Event.SubType != "bot_message" {
-		channel, err := b.channels.getChannelByID(evt.Event.Channel)
-		if err != nil {
-			b.Log.Errorf("Could not get channel name for ID %s: %v", evt.Event.Channel, err)
-			return
-		}
-		username := evt.Event.User // fallback to ID if needed
-		if evt.Event.User != "" {
-			userInfo, err := b.sc.GetUserInfo(evt.Event.User)
-			if err != nil {
-				b.Log.Warnf("Could not fetch username for user ID %s: %v", evt.Event.User, err)
-			} else if userInfo.Profile.DisplayName != "" {
-				username = userInfo.Profile.DisplayName
-			}
-		}
-
-		msg := config.Message{
-			Text:     evt.Event.Text,
-			Channel:  channel.Name,
-			Username: username,
-			Account:  b.Account,
-			Protocol: "slack",
-		}
-		b.Log.Infof("Relaying Slack message from user %s in channel %s", msg.Username, channel.Name)
-		b.Remote <- msg
-	}
-
-	w.WriteHeader(http.StatusOK)
-}
-
-type SlackEventWrapper struct {
-	Type      string `json:"type"`
-	Challenge string `json:"challenge,omitempty"`
-	Event     struct {
-		Type            string `json:"type"`
-		SubType         string `json:"subtype,omitempty"` // <--- Add this
-		User            string `json:"user,omitempty"`
-		Text            string `json:"text,omitempty"`
-		Channel         string `json:"channel"`
-		Ts              string `json:"ts"`
-		DeletedTs       string `json:"deleted_ts,omitempty"` // <--- Add this
-		PreviousMessage struct {
-			Ts   string `json:"ts"`
-			User string `json:"user"`
-			Text string `json:"text"`
-		} `json:"previous_message,omitempty"`
-	} `json:"event"`
-}
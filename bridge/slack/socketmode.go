@@ -0,0 +1,141 @@
+package bslack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+)
+
+const (
+	useSocketModeConfig = "UseSocketMode"
+	appTokenConfig      = "AppToken"
+
+	// socketModeTimeout is how long the connection may be silent, Slack
+	// pings every few seconds.
+	socketModeTimeout = time.Minute
+)
+
+// socketModeEnvelope is a message of Slack on a Socket Mode connection, see
+// https://api.slack.com/apis/connections/socket-implement
+type socketModeEnvelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// manageSocketMode receives the events with Socket Mode until ctx is done,
+// they are sent on b.events like the events of the Events API. Lost
+// connections are reconnected.
+func (b *Bslack) manageSocketMode(ctx context.Context) {
+	bf := &backoff.Backoff{
+		Min:    time.Second,
+		Max:    5 * time.Minute,
+		Jitter: true,
+	}
+	for {
+		err := b.runSocketMode(ctx, bf)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// Slack asked us to reconnect
+			continue
+		}
+		d := bf.Duration()
+		b.Log.Errorf("Socket Mode connection failed: %s, reconnecting in %s", err, d)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+	}
+}
+
+// runSocketMode handles one Socket Mode connection. Returns nil when Slack
+// closes it with a disconnect message.
+func (b *Bslack) runSocketMode(ctx context.Context, bf *backoff.Backoff) error {
+	_, url, err := b.sc.StartSocketModeContext(ctx)
+	if err != nil {
+		return fmt.Errorf("opening connection failed: %s", err)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// unblock the reads when we're disconnected
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(socketModeTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(socketModeTimeout))
+		var env socketModeEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return err
+		}
+		switch env.Type {
+		case "hello":
+			b.Log.Info("Connected with Socket Mode")
+			if bf.Attempt() > 0 {
+				// catch up with the events we missed while we were disconnected
+				go b.RequestBackfill()
+			}
+			bf.Reset()
+		case "disconnect":
+			b.Log.Debugf("Socket Mode connection closed by Slack: %s", env.Reason)
+			return nil
+		case "events_api":
+			var req eventsRequest
+			if err := json.Unmarshal(env.Payload, &req); err != nil {
+				b.Log.Errorf("Could not parse Socket Mode event: %s", err)
+				break
+			}
+			// Slack delivers the events that aren't acknowledged again, so
+			// they are only acknowledged once they are queued
+			if req.Type == "event_callback" && !b.queueEventWait(ctx, &req) {
+				return ctx.Err()
+			}
+		default:
+			b.Log.Debugf("Unhandled Socket Mode message %s", env.Type)
+		}
+		// Slack expects the acknowledgement within 3 seconds
+		if env.EnvelopeID != "" {
+			if err := conn.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// queueEventWait sends the event of req to b.events like queueEvent, but waits
+// while b.events is full. Returns false when ctx is done first.
+func (b *Bslack) queueEventWait(ctx context.Context, req *eventsRequest) bool {
+	if b.seenEvent(req.EventID) {
+		b.Log.Debugf("Ignoring event %s, it was delivered already", req.EventID)
+		return true
+	}
+	select {
+	case b.events <- req.Event:
+		return true
+	case <-ctx.Done():
+		b.cache.Remove(cEventID + req.EventID)
+		return false
+	}
+}
@@ -0,0 +1,151 @@
+package bslack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketMode(t *testing.T) {
+	acks := make(chan string, 10)
+	var connections int32
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer xapp-test", r.Header.Get("Authorization"))
+		fmt.Fprintf(w, `{"ok":true,"url":"ws%s/websocket"}`, strings.TrimPrefix(srv.URL, "http"))
+	})
+	mux.HandleFunc("/websocket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		n := atomic.AddInt32(&connections, 1)
+		event := func(id string) string {
+			return fmt.Sprintf(`{"envelope_id":"env-%s","type":"events_api","payload":{"type":"event_callback","event_id":"%s",
+				"event":{"type":"message","channel":"C1","user":"U1","text":"%s","ts":"1.1"}}}`, id, id, id)
+		}
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello"}`)))
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(event(fmt.Sprint("Ev", n)))))
+		var ack map[string]string
+		if conn.ReadJSON(&ack) == nil {
+			acks <- ack["envelope_id"]
+		}
+		if n == 1 {
+			// the bridge has to reconnect
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"disconnect","reason":"refresh_requested"}`))
+		}
+		// wait until the bridge closes the connection
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	b := newTestEventsBridge(t)
+	b.sc = slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"), slack.OptionAppLevelToken("xapp-test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.manageSocketMode(ctx)
+		close(done)
+	}()
+
+	for _, id := range []string{"Ev1", "Ev2"} {
+		select {
+		case raw := <-b.events:
+			assert.Contains(t, string(raw), `"text":"`+id+`"`)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not received", id)
+		}
+		assert.Equal(t, "env-"+id, <-acks)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Socket Mode didn't stop")
+	}
+}
+
+func TestSocketModeAckAfterQueue(t *testing.T) {
+	acks := make(chan string, 10)
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ok":true,"url":"ws%s/websocket"}`, strings.TrimPrefix(srv.URL, "http"))
+	})
+	mux.HandleFunc("/websocket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello"}`)))
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"envelope_id":"env-Ev1","type":"events_api",
+			"payload":{"type":"event_callback","event_id":"Ev1","event":{"type":"message","channel":"C1","user":"U1","text":"Ev1","ts":"1.1"}}}`)))
+		for {
+			var ack map[string]string
+			if err := conn.ReadJSON(&ack); err != nil {
+				return
+			}
+			acks <- ack["envelope_id"]
+		}
+	})
+
+	b := newTestEventsBridge(t)
+	b.sc = slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"), slack.OptionAppLevelToken("xapp-test"))
+	// the queue is full
+	b.events = make(chan json.RawMessage, 1)
+	b.events <- json.RawMessage(`{}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.manageSocketMode(ctx)
+		close(done)
+	}()
+
+	select {
+	case id := <-acks:
+		t.Fatalf("%s acknowledged before it was queued", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+	<-b.events
+	select {
+	case raw := <-b.events:
+		assert.Contains(t, string(raw), `"text":"Ev1"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ev1 not received")
+	}
+	select {
+	case id := <-acks:
+		assert.Equal(t, "env-Ev1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ev1 not acknowledged")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Socket Mode didn't stop")
+	}
+}
@@ -65,10 +65,6 @@ func (b *Bsshchat) JoinChannel(channel config.ChannelInfo) error {
 }
 
 func (b *Bsshchat) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
 	b.Log.Debugf("=> Receiving %#v", msg)
 	if msg.Extra != nil {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
@@ -76,9 +72,6 @@ func (b *Bsshchat) Send(msg config.Message) (string, error) {
 				b.Log.Errorf("Could not send extra message: %#v", err)
 			}
 		}
-		if len(msg.Extra["file"]) > 0 {
-			return b.handleUploadFile(&msg)
-		}
 	}
 	_, err := b.w.Write([]byte(msg.Username + msg.Text + "\r\n"))
 	return "", err
@@ -148,22 +141,3 @@ func (b *Bsshchat) handleSSHChat() error {
 		}
 	}
 }
-
-func (b *Bsshchat) handleUploadFile(msg *config.Message) (string, error) {
-	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + ": "
-		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
-			}
-		}
-		if _, err := b.w.Write([]byte(msg.Username + msg.Text + "\r\n")); err != nil {
-			b.Log.Errorf("Could not send file message: %#v", err)
-		}
-	}
-	return "", nil
-}
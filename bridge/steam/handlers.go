@@ -105,22 +105,3 @@ func (b *Bsteam) handleLogOnFailed(e *steam.LogOnFailedEvent, myLoginInfo *steam
 	}
 	return nil
 }
-
-// handleFileInfo handles config.FileInfo and adds correct file comment or URL to msg.Text.
-// Returns error if cast fails.
-func (b *Bsteam) handleFileInfo(msg *config.Message, f interface{}) error {
-	if _, ok := f.(config.FileInfo); !ok {
-		return fmt.Errorf("handleFileInfo cast failed %#v", f)
-	}
-	fi := f.(config.FileInfo)
-	if fi.Comment != "" {
-		msg.Text += fi.Comment + ": "
-	}
-	if fi.URL != "" {
-		msg.Text = fi.URL
-		if fi.Comment != "" {
-			msg.Text = fi.Comment + ": " + fi.URL
-		}
-	}
-	return nil
-}
@@ -58,10 +58,6 @@ func (b *Bsteam) JoinChannel(channel config.ChannelInfo) error {
 }
 
 func (b *Bsteam) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
 	id, err := steamid.NewId(msg.Channel)
 	if err != nil {
 		return "", err
@@ -72,13 +68,6 @@ func (b *Bsteam) Send(msg config.Message) (string, error) {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
 			b.c.Social.SendMessage(id, steamlang.EChatEntryType_ChatMsg, rmsg.Username+rmsg.Text)
 		}
-		for i := range msg.Extra["file"] {
-			if err := b.handleFileInfo(&msg, msg.Extra["file"][i]); err != nil {
-				b.Log.Error(err)
-			}
-			b.c.Social.SendMessage(id, steamlang.EChatEntryType_ChatMsg, msg.Username+msg.Text)
-		}
-		return "", nil
 	}
 
 	b.c.Social.SendMessage(id, steamlang.EChatEntryType_ChatMsg, msg.Username+msg.Text)
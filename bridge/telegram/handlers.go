@@ -6,10 +6,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf16"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/davecgh/go-spew/spew"
 	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
 )
@@ -145,7 +147,7 @@ func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Messa
 			}
 		}
 		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && b.General.MediaDownloadPath != "") {
+		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && (b.General.MediaDownloadPath != "" || b.General.MediaStore != "")) {
 			b.handleDownloadAvatar(message.From.ID, rmsg.Channel)
 		}
 	}
@@ -169,7 +171,7 @@ func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Messa
 			}
 		}
 		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && b.General.MediaDownloadPath != "") {
+		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && (b.General.MediaDownloadPath != "" || b.General.MediaStore != "")) {
 			b.handleDownloadAvatar(message.SenderChat.ID, rmsg.Channel)
 		}
 	}
@@ -218,6 +220,10 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 
 		// set the ID's from the channel or group message
 		rmsg.ID = strconv.Itoa(message.MessageID)
+		rmsg.Timestamp = time.Unix(int64(message.Date), 0)
+		if message.EditDate != 0 {
+			rmsg.Timestamp = time.Unix(int64(message.EditDate), 0)
+		}
 		rmsg.Channel = strconv.FormatInt(message.Chat.ID, 10)
 		if message.IsTopicMessage {
 			rmsg.Channel += "/" + strconv.Itoa(message.MessageThreadID)
@@ -248,6 +254,9 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 		// quote the previous message
 		b.handleQuoting(&rmsg, message)
 
+		// parse the formatting of the entities
+		b.handleFormatting(&rmsg, message)
+
 		if rmsg.Text != "" || len(rmsg.Extra) > 0 {
 			// Comment the next line out due to avoid removing empty lines in Telegram
 			// rmsg.Text = helper.RemoveEmptyNewLines(rmsg.Text)
@@ -307,6 +316,45 @@ func (b *Btelegram) handleUserLeave(update tgbotapi.Update) {
 	b.Remote <- rmsg
 }
 
+// sendChannelMembers sends the administrators of the chats to the gateway,
+// the bot API doesn't list the other members.
+func (b *Btelegram) sendChannelMembers() error {
+	b.channelsMutex.RLock()
+	channels := make([]string, 0, len(b.channels))
+	for channel := range b.channels {
+		channels = append(channels, channel)
+	}
+	b.channelsMutex.RUnlock()
+
+	members := config.ChannelMembers{}
+	for _, channel := range channels {
+		chatid, _, err := b.getIds(channel)
+		if err != nil {
+			return err
+		}
+		admins, err := b.c.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: chatid},
+		})
+		if err != nil {
+			return fmt.Errorf("getting the administrators of %s failed: %w", channel, err)
+		}
+		for _, admin := range admins {
+			if admin.User == nil {
+				continue
+			}
+			members = append(members, config.ChannelMember{
+				Username:    admin.User.UserName,
+				Nick:        strings.TrimSpace(admin.User.FirstName + " " + admin.User.LastName),
+				UserID:      strconv.FormatInt(admin.User.ID, 10),
+				ChannelID:   channel,
+				ChannelName: channel,
+			})
+		}
+	}
+	b.SendChannelMembers(members)
+	return nil
+}
+
 // handleDownloadAvatar downloads the avatar of userid from channel
 // sends a EVENT_AVATAR_DOWNLOAD message to the gateway if successful.
 // logs an error message if it fails
@@ -475,6 +523,33 @@ func (b *Btelegram) handleDelete(msg *config.Message, chatid int64) (string, err
 	return "", err
 }
 
+// handleReaction sets or removes the reaction of the bot on the message in ParentID.
+// A bot has one reaction per message, telegram only allows a fixed set of emoji.
+// Receiving reactions isn't supported by the telegram-bot-api library yet.
+func (b *Btelegram) handleReaction(msg *config.Message, chatid int64) error {
+	// custom emoji of other protocols can't be used
+	if strings.HasPrefix(msg.Reaction.Emoji, ":") {
+		return nil
+	}
+	msgid, err := strconv.Atoi(msg.ParentID)
+	if err != nil {
+		return err
+	}
+
+	reaction := []map[string]string{}
+	if !msg.Reaction.Removed {
+		reaction = append(reaction, map[string]string{"type": "emoji", "emoji": msg.Reaction.Emoji})
+	}
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatid)
+	params.AddNonZero("message_id", msgid)
+	if err := params.AddInterface("reaction", reaction); err != nil {
+		return err
+	}
+	_, err = b.c.MakeRequest("setMessageReaction", params)
+	return err
+}
+
 // handleEdit handles message editing.
 func (b *Btelegram) handleEdit(msg *config.Message, chatid int64) (string, error) {
 	msgid, err := strconv.Atoi(msg.ID)
@@ -581,6 +656,53 @@ func (b *Btelegram) handleQuote(message, quoteNick, quoteMessage string) string
 	return format
 }
 
+// entityKinds are the entities that format the text.
+var entityKinds = map[string]richtext.Kind{
+	"bold":          richtext.Bold,
+	"italic":        richtext.Italic,
+	"strikethrough": richtext.Strike,
+	"code":          richtext.Code,
+	"pre":           richtext.Pre,
+	"text_link":     richtext.Link,
+	"text_mention":  richtext.Mention,
+	"blockquote":    richtext.Quote,
+}
+
+// handleFormatting sets the formatting of the message from its entities.
+// The text added around the message, like the forwarded prefix, stays plain.
+func (b *Btelegram) handleFormatting(rmsg *config.Message, message *tgbotapi.Message) {
+	if message.Text == "" || len(message.Entities) == 0 {
+		return
+	}
+	converted := config.Message{Text: message.Text}
+	b.handleEntities(&converted, message)
+	i := strings.Index(rmsg.Text, converted.Text)
+	if i < 0 {
+		return
+	}
+	var spans []richtext.Span
+	for _, e := range message.Entities {
+		kind, ok := entityKinds[e.Type]
+		if !ok {
+			continue
+		}
+		span := richtext.Span{Offset: e.Offset, Length: e.Length, Kind: kind, URL: e.URL, Lang: e.Language}
+		if e.User != nil {
+			span.UserID = strconv.FormatInt(e.User.ID, 10)
+		}
+		spans = append(spans, span)
+	}
+	doc := richtext.ParseSpans(message.Text, spans)
+	if prefix := rmsg.Text[:i]; prefix != "" {
+		doc.Nodes = append([]*richtext.Node{{Kind: richtext.Text, Text: prefix}}, doc.Nodes...)
+	}
+	if suffix := rmsg.Text[i+len(converted.Text):]; suffix != "" {
+		doc.Nodes = append(doc.Nodes, &richtext.Node{Kind: richtext.Text, Text: suffix})
+	}
+	doc.Source = rmsg.Text
+	rmsg.Formatted = doc
+}
+
 // handleEntities handles messageEntities
 func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.Entities == nil {
@@ -6,10 +6,12 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
 )
 
@@ -24,6 +26,10 @@ type Btelegram struct {
 	c *tgbotapi.BotAPI
 	*bridge.Config
 	avatarMap map[string]string // keep cache of userid and avatar sha
+	// channels are the chats of the gateways, the bot is in a chat once
+	// it's added to it.
+	channels      map[string]bool
+	channelsMutex sync.RWMutex
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
@@ -37,9 +43,18 @@ func New(cfg *bridge.Config) bridge.Bridger {
 			log.Fatalf("Telegram bridge configured to convert .tgs files to '%s', but %s doesn't support it.", tgsConvertFormat, helper.LottieBackend())
 		}
 	}
-	return &Btelegram{Config: cfg, avatarMap: make(map[string]string)}
+	return &Btelegram{Config: cfg, avatarMap: make(map[string]string), channels: make(map[string]bool)}
 }
 
+func (b *Btelegram) EditsMessages()    {}
+func (b *Btelegram) DeletesMessages()  {}
+func (b *Btelegram) PreservesThreads() {}
+func (b *Btelegram) SendsReactions()   {}
+func (b *Btelegram) SendsTyping()      {}
+func (b *Btelegram) UploadsFiles()     {}
+func (b *Btelegram) CachesAvatars()    {}
+func (b *Btelegram) ListsMembers()     {}
+
 func (b *Btelegram) Connect() error {
 	var err error
 	b.Log.Info("Connecting")
@@ -61,6 +76,9 @@ func (b *Btelegram) Disconnect() error {
 }
 
 func (b *Btelegram) JoinChannel(channel config.ChannelInfo) error {
+	b.channelsMutex.Lock()
+	b.channels[channel.Name] = true
+	b.channelsMutex.Unlock()
 	return nil
 }
 
@@ -120,6 +138,10 @@ func (b *Btelegram) getIds(channel string) (int64, int, error) {
 func (b *Btelegram) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		return "", b.sendChannelMembers()
+	}
+
 	chatid, topicid, err := b.getIds(msg.Channel)
 	if err != nil {
 		return "", err
@@ -130,8 +152,28 @@ func (b *Btelegram) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg)
 	}
 
+	if msg.Event == config.EventReaction {
+		return "", b.handleReaction(&msg, chatid)
+	}
+
+	// bots can show they're typing but don't get the typing of others
+	if msg.Event == config.EventUserTyping {
+		if b.GetBool("ShowUserTyping") {
+			action := tgbotapi.NewChatAction(chatid, tgbotapi.ChatTyping)
+			action.MessageThreadID = topicid
+			_, err := b.c.Request(action)
+			return "", err
+		}
+		return "", nil
+	}
+
 	if b.GetString("MessageFormat") == HTMLFormat {
-		msg.Text = makeHTML(html.EscapeString(msg.Text))
+		if doc := msg.RichText(); doc != nil {
+			// telegram doesn't support <br>
+			msg.Text = richtext.RenderHTML(doc, "\n")
+		} else {
+			msg.Text = makeHTML(html.EscapeString(msg.Text))
+		}
 	}
 
 	// Delete message
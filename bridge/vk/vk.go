@@ -43,6 +43,9 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return &Bvk{usernamesMap: make(map[int]user), Config: cfg}
 }
 
+func (b *Bvk) EditsMessages() {}
+func (b *Bvk) UploadsFiles()  {}
+
 func (b *Bvk) Connect() error {
 	b.Log.Info("Connecting")
 	b.c = api.NewVK(b.GetString("Token"))
@@ -59,6 +59,10 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
+func (b *Bwhatsapp) EditsMessages()   {}
+func (b *Bwhatsapp) DeletesMessages() {}
+func (b *Bwhatsapp) UploadsFiles()    {}
+
 // Connect to WhatsApp. Required implementation of the Bridger interface
 func (b *Bwhatsapp) Connect() error {
 	number := b.GetString(cfgNumber)
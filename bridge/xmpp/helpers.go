@@ -4,6 +4,7 @@ import (
 	"regexp"
 
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
 )
 
 var pathRegex = regexp.MustCompile("[^a-zA-Z0-9]+")
@@ -13,7 +14,7 @@ func getAvatar(av map[string]string, userid string, general *config.Protocol) st
 	if hash, ok := av[userid]; ok {
 		// NOTE: This does not happen in bridge/helper/helper.go but messes up XMPP
 		id := pathRegex.ReplaceAllString(userid, "_")
-		return general.MediaServerDownload + "/" + hash + "/" + id + ".png"
+		return helper.MediaURL(general, hash+"/"+id+".png")
 	}
 	return ""
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 	"net/url"
 	"strings"
@@ -26,21 +27,34 @@ type Bxmpp struct {
 	xc        *xmpp.Client
 	xmppMap   map[string]string
 	connected bool
+	// occupants are the nicks in the MUCs by channel.
+	occupants map[string]map[string]bool
 	sync.RWMutex
 
 	avatarAvailability map[string]bool
 	avatarMap          map[string]string
 }
 
+// chatStatesNS is the namespace of the XEP-0085 chat states.
+const chatStatesNS = "http://jabber.org/protocol/chatstates"
+
 func New(cfg *bridge.Config) bridge.Bridger {
 	return &Bxmpp{
 		Config:             cfg,
 		xmppMap:            make(map[string]string),
+		occupants:          make(map[string]map[string]bool),
 		avatarAvailability: make(map[string]bool),
 		avatarMap:          make(map[string]string),
 	}
 }
 
+func (b *Bxmpp) EditsMessages()   {}
+func (b *Bxmpp) ReactionsAsText() {}
+func (b *Bxmpp) SendsTyping()     {}
+func (b *Bxmpp) UploadsFiles()    {}
+func (b *Bxmpp) CachesAvatars()   {}
+func (b *Bxmpp) ListsMembers()    {}
+
 func (b *Bxmpp) Connect() error {
 	b.Log.Infof("Connecting %s", b.GetString("Server"))
 	if err := b.createXMPP(); err != nil {
@@ -72,12 +86,19 @@ func (b *Bxmpp) Send(msg config.Message) (string, error) {
 	if !b.Connected() {
 		return "", fmt.Errorf("bridge %s not connected, dropping message %#v to bridge", b.Account, msg)
 	}
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
+	b.Log.Debugf("=> Receiving %#v", msg)
+
+	if msg.Event == config.EventGetChannelMembers {
+		b.sendChannelMembers()
 		return "", nil
 	}
 
-	b.Log.Debugf("=> Receiving %#v", msg)
+	if msg.Event == config.EventUserTyping {
+		if b.GetBool("ShowUserTyping") {
+			return "", b.sendComposing(msg.Channel)
+		}
+		return "", nil
+	}
 
 	if msg.Event == config.EventAvatarDownload {
 		return b.cacheAvatar(&msg), nil
@@ -277,6 +298,11 @@ func (b *Bxmpp) xmppKeepAlive() chan bool {
 func (b *Bxmpp) handleXMPP() error {
 	b.startTime = time.Now()
 
+	// we get the presence of the occupants again when we join
+	b.Lock()
+	b.occupants = make(map[string]map[string]bool)
+	b.Unlock()
+
 	done := b.xmppKeepAlive()
 	defer close(done)
 
@@ -297,6 +323,11 @@ func (b *Bxmpp) handleXMPP() error {
 			if v.Type == "groupchat" {
 				b.Log.Debugf("== Receiving %#v", v)
 
+				if v.Text == "" && isComposing(v) {
+					b.handleComposing(v)
+					continue
+				}
+
 				// Skip invalid messages.
 				if b.skipMessage(v) {
 					continue
@@ -348,9 +379,81 @@ func (b *Bxmpp) handleXMPP() error {
 			b.avatarAvailability[v.From] = true
 			b.Log.Debugf("Avatar for %s is now available", v.From)
 		case xmpp.Presence:
-			// Do nothing.
+			b.handlePresence(v)
+		}
+	}
+}
+
+// handleComposing sends a typing event for a XEP-0085 composing chat state.
+func (b *Bxmpp) handleComposing(chat xmpp.Chat) {
+	if !b.GetBool("ShowUserTyping") || b.parseNick(chat.Remote) == b.GetString("Nick") {
+		return
+	}
+	b.Remote <- config.Message{
+		Account: b.Account,
+		Event:   config.EventUserTyping,
+		Channel: b.parseChannel(chat.Remote),
+	}
+}
+
+// sendComposing shows that we're typing in the MUC with a XEP-0085 chat
+// state.
+func (b *Bxmpp) sendComposing(channel string) error {
+	_, err := b.xc.SendOrg(fmt.Sprintf("<message to='%s' type='groupchat'><composing xmlns='%s'/></message>",
+		html.EscapeString(channel+"@"+b.GetString("Muc")), chatStatesNS))
+	return err
+}
+
+// isComposing returns true if chat has a composing chat state.
+func isComposing(chat xmpp.Chat) bool {
+	for _, elem := range chat.OtherElem {
+		if elem.XMLName.Space == chatStatesNS && elem.XMLName.Local == "composing" {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePresence keeps track of the occupants of the MUCs.
+func (b *Bxmpp) handlePresence(presence xmpp.Presence) {
+	if !strings.Contains(presence.From, "@"+b.GetString("Muc")+"/") {
+		return
+	}
+	channel, nick := b.parseChannel(presence.From), b.parseNick(presence.From)
+	if nick == "" || nick == b.GetString("Nick") {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	switch presence.Type {
+	case "":
+		if b.occupants[channel] == nil {
+			b.occupants[channel] = make(map[string]bool)
+		}
+		b.occupants[channel][nick] = true
+	case "unavailable":
+		delete(b.occupants[channel], nick)
+	}
+}
+
+// sendChannelMembers sends the occupants of the MUCs to the gateway.
+func (b *Bxmpp) sendChannelMembers() {
+	members := config.ChannelMembers{}
+	b.RLock()
+	for channel, nicks := range b.occupants {
+		for nick := range nicks {
+			members = append(members, config.ChannelMember{
+				Username:    nick,
+				Nick:        nick,
+				UserID:      channel + "@" + b.GetString("Muc") + "/" + nick,
+				ChannelID:   channel,
+				ChannelName: channel,
+			})
 		}
 	}
+	b.RUnlock()
+	b.SendChannelMembers(members)
 }
 
 func (b *Bxmpp) replaceAction(text string) (string, bool) {
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,6 +30,10 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return &Bzulip{Config: cfg, streams: make(map[int]string)}
 }
 
+func (b *Bzulip) EditsMessages()   {}
+func (b *Bzulip) DeletesMessages() {}
+func (b *Bzulip) Backfills()       {}
+
 func (b *Bzulip) Connect() error {
 	bot := gzb.Bot{APIKey: b.GetString("token"), APIURL: b.GetString("server") + "/api/v1/", Email: b.GetString("login"), UserAgent: fmt.Sprintf("matterbridge/%s", version.Release)}
 	bot.Init()
@@ -56,6 +62,10 @@ func (b *Bzulip) JoinChannel(channel config.ChannelInfo) error {
 func (b *Bzulip) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventBackfill {
+		return "", b.backfill(&msg)
+	}
+
 	// Delete message
 	if msg.Event == config.EventMsgDelete {
 		if msg.ID == "" {
@@ -70,9 +80,6 @@ func (b *Bzulip) Send(msg config.Message) (string, error) {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
 			b.sendMessage(rmsg)
 		}
-		if len(msg.Extra["file"]) > 0 {
-			return b.handleUploadFile(&msg)
-		}
 	}
 
 	// edit the message if we have a msg ID
@@ -124,6 +131,8 @@ func (b *Bzulip) handleQueue() error {
 					}
 					break
 				}
+				// the events of the old queue are lost
+				b.RequestBackfill()
 			case gzb.HeartbeatError:
 				b.Log.Debug("heartbeat received.")
 			default:
@@ -135,33 +144,108 @@ func (b *Bzulip) handleQueue() error {
 		}
 		for _, m := range messages {
 			b.Log.Debugf("== Receiving %#v", m)
-			// ignore our own messages
-			if m.SenderEmail == b.GetString("login") {
-				continue
-			}
-
-			avatarURL := m.AvatarURL
-			if !strings.HasPrefix(avatarURL, "http") {
-				avatarURL = b.GetString("server") + avatarURL
-			}
-
-			rmsg := config.Message{
-				Username: m.SenderFullName,
-				Text:     m.Content,
-				Channel:  b.getChannel(m.StreamID) + "/topic:" + m.Subject,
-				Account:  b.Account,
-				UserID:   strconv.Itoa(m.SenderID),
-				Avatar:   avatarURL,
-			}
-			b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
-			b.Log.Debugf("<= Message is %#v", rmsg)
-			b.Remote <- rmsg
+			b.relayMessage(m, false)
 		}
 
 		time.Sleep(time.Second * 3)
 	}
 }
 
+// relayMessage sends m to the gateway, backfill is true for the messages of
+// the history that were missed.
+func (b *Bzulip) relayMessage(m gzb.EventMessage, backfill bool) {
+	// ignore our own messages
+	if m.SenderEmail == b.GetString("login") {
+		return
+	}
+
+	avatarURL := m.AvatarURL
+	if !strings.HasPrefix(avatarURL, "http") {
+		avatarURL = b.GetString("server") + avatarURL
+	}
+
+	rmsg := config.Message{
+		Username:  m.SenderFullName,
+		Text:      m.Content,
+		Channel:   b.getChannel(m.StreamID) + "/topic:" + m.Subject,
+		Account:   b.Account,
+		UserID:    strconv.Itoa(m.SenderID),
+		ID:        strconv.Itoa(m.ID),
+		Avatar:    avatarURL,
+		Timestamp: time.Unix(int64(m.Timestamp), 0),
+		Backfill:  backfill,
+	}
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// backfill relays the messages of the stream and topic of msg that were sent
+// after the message with its ID, or after its timestamp.
+func (b *Bzulip) backfill(msg *config.Message) error {
+	stream, topic := msg.Channel, ""
+	if strings.Contains(msg.Channel, "/topic:") {
+		res := strings.Split(msg.Channel, "/topic:")
+		stream, topic = res[0], res[1]
+	}
+	narrow := []map[string]string{{"operator": "stream", "operand": stream}}
+	if topic != "" {
+		narrow = append(narrow, map[string]string{"operator": "topic", "operand": topic})
+	}
+	narrowJSON, err := json.Marshal(narrow)
+	if err != nil {
+		return err
+	}
+	limit := strconv.Itoa(b.BackfillLimit())
+	query := url.Values{"narrow": {string(narrowJSON)}, "apply_markdown": {"false"}}
+	if msg.ID != "" {
+		query.Set("anchor", msg.ID)
+		query.Set("num_before", "0")
+		query.Set("num_after", limit)
+	} else {
+		// without an ID the newest messages are filtered by their timestamp
+		query.Set("anchor", "newest")
+		query.Set("num_before", limit)
+		query.Set("num_after", "0")
+	}
+
+	req, err := http.NewRequest("GET", b.bot.APIURL+"messages?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.bot.Email, b.bot.APIKey)
+	resp, err := b.bot.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Result   string             `json:"result"`
+		Msg      string             `json:"msg"`
+		Messages []gzb.EventMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if res.Result != "success" {
+		return fmt.Errorf("getting messages failed: %s", res.Msg)
+	}
+
+	b.Log.Debugf("Backfilling %d messages of %s", len(res.Messages), msg.Channel)
+	// the messages are oldest first
+	for _, m := range res.Messages {
+		if msg.ID != "" && strconv.Itoa(m.ID) == msg.ID {
+			// the anchor
+			continue
+		}
+		if msg.ID == "" && int64(m.Timestamp) <= msg.Timestamp.Unix() {
+			continue
+		}
+		b.relayMessage(m, true)
+	}
+	return nil
+}
+
 func (b *Bzulip) sendMessage(msg config.Message) (string, error) {
 	topic := ""
 	if strings.Contains(msg.Channel, "/topic:") {
@@ -195,23 +279,3 @@ func (b *Bzulip) sendMessage(msg config.Message) (string, error) {
 	}
 	return "", nil
 }
-
-func (b *Bzulip) handleUploadFile(msg *config.Message) (string, error) {
-	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + ": "
-		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
-			}
-		}
-		_, err := b.sendMessage(*msg)
-		if err != nil {
-			return "", err
-		}
-	}
-	return "", nil
-}
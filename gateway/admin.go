@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+type adminChannel struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Account   string `json:"account"`
+	Direction string `json:"direction"`
+	Joined    bool   `json:"joined"`
+}
+
+type adminGateway struct {
+	Name     string         `json:"name"`
+	Enabled  bool           `json:"enabled"`
+	Accounts []string       `json:"accounts"`
+	Channels []adminChannel `json:"channels"`
+}
+
+type adminBridge struct {
+	Account   string         `json:"account"`
+	Protocol  string         `json:"protocol"`
+	Connected bool           `json:"connected"`
+	Gateways  []string       `json:"gateways"`
+	Channels  []adminChannel `json:"channels"`
+}
+
+type adminMember struct {
+	Username    string `json:"username"`
+	Nick        string `json:"nick"`
+	UserID      string `json:"userid"`
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+}
+
+// startAdmin starts the admin API when AdminBindAddress is configured.
+func (r *Router) startAdmin() error {
+	general := r.BridgeValues().General
+	if general.AdminBindAddress == "" {
+		return nil
+	}
+	if general.AdminToken == "" {
+		return fmt.Errorf("AdminBindAddress is configured without an AdminToken")
+	}
+	e := r.newAdminServer(general.AdminToken)
+	r.adminServer = e
+	go func() {
+		r.logger.Infof("Admin API listening on %s", general.AdminBindAddress)
+		if err := e.Start(general.AdminBindAddress); err != nil && err != http.ErrServerClosed {
+			r.logger.Errorf("Admin API failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+func (r *Router) newAdminServer(token string) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+	}))
+	e.GET("/admin/gateways", r.handleAdminGateways)
+	e.GET("/admin/bridges", r.handleAdminBridges)
+	e.GET("/admin/bridges/:account/members", r.handleAdminMembers)
+	e.POST("/admin/bridges/:account/reconnect", r.handleAdminReconnect)
+	e.POST("/admin/gateways/:name/enable", r.handleAdminEnable(true))
+	e.POST("/admin/gateways/:name/disable", r.handleAdminEnable(false))
+	e.POST("/admin/gateways/:name/message", r.handleAdminMessage)
+	return e
+}
+
+func adminChannels(channels map[string]*config.ChannelInfo, joined func(*config.ChannelInfo) bool) []adminChannel {
+	res := []adminChannel{}
+	for _, channel := range channels {
+		res = append(res, adminChannel{
+			ID:        channel.ID,
+			Name:      channel.Name,
+			Account:   channel.Account,
+			Direction: channel.Direction,
+			Joined:    joined(channel),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (r *Router) handleAdminGateways(c echo.Context) error {
+	r.RLock()
+	defer r.RUnlock()
+	res := []adminGateway{}
+	for _, gw := range r.Gateways {
+		agw := adminGateway{
+			Name:     gw.Name,
+			Enabled:  !gw.disabled,
+			Accounts: []string{},
+			Channels: adminChannels(gw.Channels, func(channel *config.ChannelInfo) bool {
+				br, ok := gw.Bridges[channel.Account]
+				return ok && br.Joined[channel.ID]
+			}),
+		}
+		for account := range gw.Bridges {
+			agw.Accounts = append(agw.Accounts, account)
+		}
+		sort.Strings(agw.Accounts)
+		res = append(res, agw)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return c.JSON(http.StatusOK, res)
+}
+
+func (r *Router) handleAdminBridges(c echo.Context) error {
+	r.RLock()
+	defer r.RUnlock()
+	res := []adminBridge{}
+	for account, br := range r.bridges() {
+		abr := adminBridge{
+			Account:   account,
+			Protocol:  br.Protocol,
+			Connected: br.Connected(),
+			Gateways:  []string{},
+		}
+		channels := make(map[string]*config.ChannelInfo)
+		for _, gw := range r.Gateways {
+			if _, ok := gw.Bridges[account]; !ok {
+				continue
+			}
+			abr.Gateways = append(abr.Gateways, gw.Name)
+			for ID, channel := range gw.Channels {
+				if channel.Account == account {
+					channels[ID] = channel
+				}
+			}
+		}
+		sort.Strings(abr.Gateways)
+		abr.Channels = adminChannels(channels, func(channel *config.ChannelInfo) bool {
+			return br.Joined[channel.ID]
+		})
+		res = append(res, abr)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Account < res[j].Account })
+	return c.JSON(http.StatusOK, res)
+}
+
+// handleAdminMembers lists the channel members the bridge sent with
+// EventGetChannelMembers.
+func (r *Router) handleAdminMembers(c echo.Context) error {
+	account := c.Param("account")
+	r.RLock()
+	br, ok := r.bridges()[account]
+	r.RUnlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown account "+account)
+	}
+	res := []adminMember{}
+	for _, member := range br.GetChannelMembers() {
+		res = append(res, adminMember(member))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (r *Router) handleAdminReconnect(c echo.Context) error {
+	account := c.Param("account")
+	r.RLock()
+	defer r.RUnlock()
+	for _, gw := range r.Gateways {
+		if br, ok := gw.Bridges[account]; ok {
+			r.logger.Infof("Admin API: reconnecting %s", account)
+			go gw.reconnectBridge(br)
+			return c.NoContent(http.StatusAccepted)
+		}
+	}
+	return echo.NewHTTPError(http.StatusNotFound, "unknown account "+account)
+}
+
+func (r *Router) handleAdminEnable(enable bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		r.Lock()
+		defer r.Unlock()
+		gw, ok := r.Gateways[name]
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "unknown gateway "+name)
+		}
+		gw.disabled = !enable
+		r.logger.Infof("Admin API: gateway %s enabled: %t", name, enable)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// handleAdminMessage relays a message as if it was received on the specified
+// account and channel of the gateway, but only to this gateway.
+func (r *Router) handleAdminMessage(c echo.Context) error {
+	msg := config.Message{}
+	if err := c.Bind(&msg); err != nil {
+		return err
+	}
+	name := c.Param("name")
+	r.RLock()
+	defer r.RUnlock()
+	gw, ok := r.Gateways[name]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown gateway "+name)
+	}
+	br, ok := gw.Bridges[msg.Account]
+	if _, found := gw.Channels[getChannelID(&msg)]; !ok || !found {
+		return echo.NewHTTPError(http.StatusBadRequest, "account and channel must be a channel of gateway "+name)
+	}
+	if msg.Text == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty text")
+	}
+	msg.Protocol = br.Protocol
+	msg.Gateway = gw.Name
+	msg.ID = ""
+	msg.ParentID = ""
+	msg.Event = ""
+	msg.Extra = nil
+	msg.ReceivedAt = time.Now()
+	msg.Timestamp = msg.ReceivedAt
+	r.logger.Debugf("Admin API: sending message from %s on %s to gateway %s", msg.Username, msg.Channel, name)
+	gw.modifyMessage(&msg)
+	for _, dest := range gw.Bridges {
+		gw.handleMessage(&msg, dest)
+	}
+	return c.JSON(http.StatusOK, msg)
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(r *Router, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	r.newAdminServer("secret").ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	r, _ := maketestBridgerRouter(t, reloadTestConfig)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(r, "GET", "/admin/gateways", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(r, "GET", "/admin/gateways", "wrong", "").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(r, "GET", "/admin/gateways", "secret", "").Code)
+}
+
+func TestAdminList(t *testing.T) {
+	r, _ := maketestBridgerRouter(t, reloadTestConfig)
+	irc := r.getBridge("irc.zzz")
+	irc.SetConnected(true)
+	irc.Joined["#mainirc.zzz"] = true
+
+	var gateways []adminGateway
+	rec := adminRequest(r, "GET", "/admin/gateways", "secret", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &gateways))
+	assert.Len(t, gateways, 2)
+	assert.Equal(t, "bridge1", gateways[0].Name)
+	assert.True(t, gateways[0].Enabled)
+	assert.Equal(t, []string{"irc.zzz", "slack.zzz"}, gateways[0].Accounts)
+	assert.Equal(t, []adminChannel{
+		{ID: "#mainirc.zzz", Name: "#main", Account: "irc.zzz", Direction: "inout", Joined: true},
+		{ID: "mainslack.zzz", Name: "main", Account: "slack.zzz", Direction: "inout", Joined: false},
+	}, gateways[0].Channels)
+
+	var bridges []adminBridge
+	rec = adminRequest(r, "GET", "/admin/bridges", "secret", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bridges))
+	assert.Len(t, bridges, 2)
+	assert.Equal(t, "irc.zzz", bridges[0].Account)
+	assert.True(t, bridges[0].Connected)
+	assert.Equal(t, []string{"bridge1", "bridge2"}, bridges[0].Gateways)
+	assert.Len(t, bridges[0].Channels, 2)
+	assert.False(t, bridges[1].Connected)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(r, "POST", "/admin/bridges/irc.unknown/reconnect", "secret", "").Code)
+}
+
+func TestAdminMembers(t *testing.T) {
+	r, _ := maketestBridgerRouter(t, reloadTestConfig)
+	rec := adminRequest(r, "GET", "/admin/bridges/slack.zzz/members", "secret", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	r.getBridge("slack.zzz").SetChannelMembers(&config.ChannelMembers{
+		{Username: "alice", Nick: "Alice", UserID: "U1", ChannelID: "C1", ChannelName: "main"},
+	})
+	var members []adminMember
+	rec = adminRequest(r, "GET", "/admin/bridges/slack.zzz/members", "secret", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &members))
+	assert.Equal(t, []adminMember{{Username: "alice", Nick: "Alice", UserID: "U1", ChannelID: "C1", ChannelName: "main"}}, members)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(r, "GET", "/admin/bridges/irc.unknown/members", "secret", "").Code)
+}
+
+func TestAdminEnableAndMessage(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, reloadTestConfig)
+
+	assert.Equal(t, http.StatusNoContent, adminRequest(r, "POST", "/admin/gateways/bridge1/disable", "secret", "").Code)
+	assert.True(t, r.Gateways["bridge1"].disabled)
+	assert.Equal(t, http.StatusNoContent, adminRequest(r, "POST", "/admin/gateways/bridge1/enable", "secret", "").Code)
+	assert.False(t, r.Gateways["bridge1"].disabled)
+	assert.Equal(t, http.StatusNotFound, adminRequest(r, "POST", "/admin/gateways/unknown/enable", "secret", "").Code)
+
+	rec := adminRequest(r, "POST", "/admin/gateways/bridge1/message", "secret",
+		`{"text":"hello","username":"admin","account":"irc.zzz","channel":"#other"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = adminRequest(r, "POST", "/admin/gateways/bridge1/message", "secret",
+		`{"text":"hello","username":"admin","account":"irc.zzz","channel":"#main"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	r.dispatcher.wait()
+	assert.Empty(t, bridgers["irc.zzz"].sent)
+	if assert.Len(t, bridgers["slack.zzz"].sent, 1) {
+		assert.Equal(t, "hello", bridgers["slack.zzz"].sent[0].Text)
+		assert.Equal(t, "main", bridgers["slack.zzz"].sent[0].Channel)
+	}
+}
@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+)
+
+const (
+	// checkpointInterval is how often the checkpoints are written to
+	// BackfillPath.
+	checkpointInterval = 10 * time.Second
+	// defaultBackfillFormat is the prefix of backfilled messages when
+	// BackfillFormat isn't set.
+	defaultBackfillFormat = "[sent {TIME}] "
+)
+
+// checkpoint is the last message relayed from a channel.
+type checkpoint struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// checkpoints keeps the last relayed message of every source channel, the
+// bridges backfill the messages after it when they reconnect or matterbridge
+// restarts. They're persisted in BackfillPath.
+type checkpoints struct {
+	sync.Mutex
+
+	path  string
+	last  map[string]checkpoint
+	dirty bool
+	// running are the accounts being backfilled.
+	running map[string]bool
+}
+
+func checkpointKey(account, channel string) string {
+	return account + " " + channel
+}
+
+// loadCheckpoints returns the checkpoints persisted in path by a previous run.
+func loadCheckpoints(path string) (*checkpoints, error) {
+	c := &checkpoints{
+		path:    path,
+		last:    make(map[string]checkpoint),
+		running: make(map[string]bool),
+	}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.last); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %s", path, err)
+	}
+	return c, nil
+}
+
+// update records msg as the last message relayed from its channel.
+func (c *checkpoints) update(msg *config.Message) {
+	c.Lock()
+	defer c.Unlock()
+	key := checkpointKey(msg.Account, msg.Channel)
+	// backfilled messages can be older than the ones relayed meanwhile
+	if last, ok := c.last[key]; ok && msg.Timestamp.Before(last.Timestamp) {
+		return
+	}
+	c.last[key] = checkpoint{ID: msg.ID, Timestamp: msg.Timestamp}
+	c.dirty = true
+}
+
+func (c *checkpoints) get(account, channel string) (checkpoint, bool) {
+	c.Lock()
+	defer c.Unlock()
+	cp, ok := c.last[checkpointKey(account, channel)]
+	return cp, ok
+}
+
+// start returns false if the account is being backfilled already, otherwise
+// the account is marked until finish is called.
+func (c *checkpoints) start(account string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.running[account] {
+		return false
+	}
+	c.running[account] = true
+	return true
+}
+
+func (c *checkpoints) finish(account string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.running, account)
+}
+
+// save writes the checkpoints to BackfillPath when they changed.
+func (c *checkpoints) save() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.last)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash doesn't leave partial checkpoints.
+	if err := ioutil.WriteFile(c.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(c.path+".tmp", c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// saveCheckpoints writes the checkpoints to BackfillPath every
+// checkpointInterval until the router stops.
+func (r *Router) saveCheckpoints() {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		if err := r.checkpoints.save(); err != nil {
+			r.logger.Errorf("Saving BackfillPath failed: %s", err)
+		}
+	}
+}
+
+// backfill starts backfilling br in the background when it has Backfill
+// enabled, unless it's being backfilled already.
+func (r *Router) backfill(br *bridge.Bridge) {
+	if _, ok := br.Bridger.(bridge.Backfiller); !ok || !br.GetBool("Backfill") {
+		return
+	}
+	if !r.checkpoints.start(br.Account) {
+		r.logger.Debugf("Backfill of %s is running already", br.Account)
+		return
+	}
+	go r.runBackfill(br)
+}
+
+// runBackfill sends an EventBackfill for every channel of br that messages
+// were relayed from before. The bridge sends the messages posted after the
+// checkpoint of the channel to the gateway, so it must not block
+// handleReceive.
+func (r *Router) runBackfill(br *bridge.Bridge) {
+	defer r.checkpoints.finish(br.Account)
+
+	// the channels are remapped by reloads
+	r.RLock()
+	var channels []string
+	seen := make(map[string]bool)
+	for _, channel := range br.Channels {
+		if strings.Contains(channel.Direction, "in") && !seen[channel.Name] {
+			seen[channel.Name] = true
+			channels = append(channels, channel.Name)
+		}
+	}
+	r.RUnlock()
+
+	for _, channel := range channels {
+		cp, ok := r.checkpoints.get(br.Account, channel)
+		if !ok {
+			// nothing was relayed from the channel yet
+			continue
+		}
+		r.logger.Infof("Backfilling %s (%s) since %s", br.Account, channel, cp.Timestamp.Format(time.RFC3339))
+		msg := config.Message{
+			Event:     config.EventBackfill,
+			Account:   br.Account,
+			Channel:   channel,
+			ID:        cp.ID,
+			Timestamp: cp.Timestamp,
+		}
+		if _, err := br.Send(msg); err != nil {
+			r.logger.Errorf("Backfill of %s (%s) failed: %s", br.Account, channel, err)
+		}
+	}
+}
+
+// handleEventBackfill backfills the bridges that reconnected by themselves.
+func (r *Router) handleEventBackfill(msg *config.Message) {
+	if msg.Event != config.EventBackfill {
+		return
+	}
+	if br := r.getBridge(msg.Account); br != nil {
+		r.backfill(br)
+	}
+}
+
+// markBackfill prefixes the text of a backfilled message with BackfillFormat
+// of the destination, so it's visible that the message is delayed.
+func (gw *Gateway) markBackfill(msg *config.Message, dest *bridge.Bridge) {
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+	format := dest.GetString("BackfillFormat")
+	if format == "" {
+		format = defaultBackfillFormat
+	}
+	prefix := strings.ReplaceAll(format, "{TIME}", msg.Timestamp.Local().Format("2006-01-02 15:04"))
+	if doc := msg.RichText(); doc != nil {
+		doc = doc.Clone()
+		doc.Nodes = append([]*richtext.Node{{Kind: richtext.Text, Text: prefix}}, doc.Nodes...)
+		doc.Source = prefix + msg.Text
+		msg.Formatted = doc
+	}
+	msg.Text = prefix + msg.Text
+}
@@ -0,0 +1,11 @@
+// +build !noarchive
+
+package bridgemap
+
+import (
+	barchive "github.com/42wim/matterbridge/bridge/archive"
+)
+
+func init() {
+	FullMap["archive"] = barchive.New
+}
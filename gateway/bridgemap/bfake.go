@@ -0,0 +1,11 @@
+// +build fake
+
+package bridgemap
+
+import (
+	bfake "github.com/42wim/matterbridge/bridge/fake"
+)
+
+func init() {
+	FullMap["fake"] = bfake.New
+}
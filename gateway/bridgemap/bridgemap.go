@@ -5,6 +5,5 @@ import (
 )
 
 var (
-	FullMap           = map[string]bridge.Factory{}
-	UserTypingSupport = map[string]struct{}{}
+	FullMap = map[string]bridge.Factory{}
 )
@@ -8,5 +8,4 @@ import (
 
 func init() {
 	FullMap["slack"] = bslack.New
-	UserTypingSupport["slack"] = struct{}{}
 }
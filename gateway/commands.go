@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Command is a command handled by the gateway, like !who. Messages starting
+// with the CommandPrefix of their account and the name of a command aren't
+// relayed, the reply is sent to the channel they came from.
+type Command struct {
+	// Help describes the command in the reply of help.
+	Help string
+	// Run returns the reply to the command in msg, args are the words after
+	// the name of the command. An empty reply isn't sent.
+	Run func(gw *Gateway, msg *config.Message, args []string) string
+}
+
+var (
+	commandsMutex sync.RWMutex
+	commands      = map[string]*Command{}
+)
+
+// RegisterCommand adds the command with the name, replacing a command with
+// the same name. Names are case-insensitive.
+func RegisterCommand(name string, cmd *Command) {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	commands[strings.ToLower(name)] = cmd
+}
+
+func lookupCommand(name string) *Command {
+	commandsMutex.RLock()
+	defer commandsMutex.RUnlock()
+	return commands[strings.ToLower(name)]
+}
+
+func init() {
+	RegisterCommand("help", &Command{Help: "lists the commands", Run: commandHelp})
+	RegisterCommand("who", &Command{Help: "lists the users on the other sides of the bridge", Run: commandWho})
+	RegisterCommand("status", &Command{Help: "shows if the bridges are connected", Run: commandStatus})
+}
+
+// handleCommand runs the command in msg in the first gateway of its channel by
+// name, so it's answered once when the channel is part of several gateways.
+// Returns true if msg was a command, no gateway relays it then.
+func (r *Router) handleCommand(msg *config.Message) bool {
+	names := make([]string, 0, len(r.Gateways))
+	for name := range r.Gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		gw := r.Gateways[name]
+		if gw.disabled || gw.ignoreMessage(msg) {
+			continue
+		}
+		if gw.handleCommand(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCommand runs the command in msg and replies to it. Returns true if
+// msg was a command.
+func (gw *Gateway) handleCommand(msg *config.Message) bool {
+	if msg.Event != "" || msg.Protocol == apiProtocol {
+		return false
+	}
+	br := gw.Bridges[msg.Account]
+	prefix := br.GetString("CommandPrefix")
+	if prefix == "" || !strings.HasPrefix(msg.Text, prefix) {
+		return false
+	}
+	channel, ok := gw.Channels[getChannelID(msg)]
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(strings.TrimPrefix(msg.Text, prefix))
+	if len(fields) == 0 {
+		return false
+	}
+	cmd := lookupCommand(fields[0])
+	if cmd == nil {
+		return false
+	}
+
+	gw.logger.Debugf("running command %s from %s on %s", fields[0], msg.Username, msg.Account)
+	text := cmd.Run(gw, msg, fields[1:])
+	if text == "" {
+		return true
+	}
+	gw.Router.dispatcher.dispatch(&delivery{
+		gw: gw,
+		msg: &config.Message{
+			Text:     text,
+			Channel:  msg.Channel,
+			Account:  msg.Account,
+			Protocol: msg.Protocol,
+			Gateway:  gw.Name,
+		},
+		dest:    br,
+		channel: *channel,
+		reply:   true,
+	})
+	return true
+}
+
+func commandHelp(gw *Gateway, msg *config.Message, args []string) string {
+	prefix := gw.Bridges[msg.Account].GetString("CommandPrefix")
+	commandsMutex.RLock()
+	defer commandsMutex.RUnlock()
+	lines := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		lines = append(lines, prefix+name+": "+cmd.Help)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// commandWho lists the members of the other channels of the gateway, as the
+// bridges sent them with EventGetChannelMembers.
+func commandWho(gw *Gateway, msg *config.Message, args []string) string {
+	var lines []string
+	for _, channel := range gw.sortedChannels() {
+		if channel.ID == getChannelID(msg) {
+			continue
+		}
+		br, ok := gw.Bridges[channel.Account]
+		if !ok {
+			continue
+		}
+		var nicks []string
+		for _, member := range channelMembers(br, channel.Name) {
+			member := member
+			nicks = append(nicks, memberNick(&member))
+		}
+		if len(nicks) == 0 {
+			lines = append(lines, channel.Account+" "+channel.Name+": no users known")
+			continue
+		}
+		sort.Strings(nicks)
+		lines = append(lines, channel.Account+" "+channel.Name+": "+strings.Join(nicks, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func commandStatus(gw *Gateway, msg *config.Message, args []string) string {
+	accounts := make([]string, 0, len(gw.Bridges))
+	for account := range gw.Bridges {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	lines := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		state := "disconnected"
+		if gw.Bridges[account].Connected() {
+			state = "connected"
+		}
+		lines = append(lines, account+": "+state)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortedChannels returns the channels of the gateway sorted by account and
+// name.
+func (gw *Gateway) sortedChannels() []*config.ChannelInfo {
+	channels := make([]*config.ChannelInfo, 0, len(gw.Channels))
+	for _, channel := range gw.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Account != channels[j].Account {
+			return channels[i].Account < channels[j].Account
+		}
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var commandTestConfig = []byte(`
+[general]
+CommandPrefix="!"
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+[discord.zzz]
+server=""
+CommandPrefix="%"
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="discord.zzz"
+    channel="main"
+`)
+
+func TestCommands(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, commandTestConfig)
+	r.getBridge("irc.zzz").SetConnected(true)
+	r.getBridge("slack.zzz").SetChannelMembers(&config.ChannelMembers{
+		{Username: "bob", UserID: "U2", ChannelID: "C1", ChannelName: "main"},
+		{Username: "alice", Nick: "Alice", UserID: "U1", ChannelID: "C1", ChannelName: "main"},
+		{Username: "carol", UserID: "U3", ChannelID: "C2", ChannelName: "other"},
+	})
+
+	command := func(account, channel, text string) {
+		r.handleMessage(&config.Message{Text: text, Username: "user", Account: account, Channel: channel})
+		r.dispatcher.wait()
+	}
+
+	command("irc.zzz", "#main", "!who")
+	assert.Equal(t, []string{"discord.zzz main: no users known\nslack.zzz main: Alice, bob"}, bridgers["irc.zzz"].sentTexts())
+	assert.Empty(t, bridgers["slack.zzz"].sent)
+	assert.Empty(t, bridgers["discord.zzz"].sent)
+	assert.Equal(t, "#main", bridgers["irc.zzz"].sent[0].Channel)
+
+	command("slack.zzz", "main", "!status")
+	assert.Equal(t, []string{"discord.zzz: disconnected\nirc.zzz: connected\nslack.zzz: disconnected"}, bridgers["slack.zzz"].sentTexts())
+
+	// the prefix can be set per account
+	command("discord.zzz", "main", "%HELP")
+	if assert.Len(t, bridgers["discord.zzz"].sent, 1) {
+		assert.Contains(t, bridgers["discord.zzz"].sent[0].Text, "%who: lists the users on the other sides of the bridge")
+	}
+	command("discord.zzz", "main", "!who")
+	assert.Len(t, bridgers["discord.zzz"].sent, 1)
+	assert.Len(t, bridgers["irc.zzz"].sent, 2, "a command with another prefix is relayed")
+
+	// unknown commands are relayed
+	command("irc.zzz", "#main", "!unknown")
+	assert.Equal(t, "!unknown", bridgers["slack.zzz"].sent[len(bridgers["slack.zzz"].sent)-1].Text)
+}
+
+func TestCommandSeveralGateways(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, append(commandTestConfig, []byte(`
+[[gateway]]
+name="bridge2"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="other"
+`)...))
+
+	r.handleMessage(&config.Message{Text: "!status", Username: "user", Account: "irc.zzz", Channel: "#main"})
+	r.dispatcher.wait()
+	assert.Len(t, bridgers["irc.zzz"].sent, 1, "the command was answered more than once")
+	assert.Empty(t, bridgers["slack.zzz"].sent)
+	assert.Empty(t, bridgers["discord.zzz"].sent)
+}
+
+func TestRegisterCommand(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, commandTestConfig)
+	RegisterCommand("Echo", &Command{
+		Help: "repeats the text",
+		Run: func(gw *Gateway, msg *config.Message, args []string) string {
+			return msg.Username + ": " + args[0]
+		},
+	})
+	defer func() {
+		commandsMutex.Lock()
+		delete(commands, "echo")
+		commandsMutex.Unlock()
+	}()
+
+	r.handleMessage(&config.Message{Text: "!echo hello", Username: "user", Account: "irc.zzz", Channel: "#main"})
+	r.dispatcher.wait()
+	assert.Equal(t, []string{"user: hello"}, bridgers["irc.zzz"].sentTexts())
+	assert.Empty(t, bridgers["slack.zzz"].sent)
+}
@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/msgstore"
+)
+
+// maxDeliveryQueueLength is the maximum number of messages waiting for a destination account.
+const maxDeliveryQueueLength = 10000
+
+// delivery is a message waiting to be sent to a destination channel.
+type delivery struct {
+	gw       *Gateway
+	msg      *config.Message
+	dest     *bridge.Bridge
+	channel  config.ChannelInfo
+	parentID string
+	// reply is the reply to a command, it goes to the channel the command
+	// came from and isn't retried.
+	reply bool
+}
+
+// deliveryQueue holds the messages waiting for a single destination account in order.
+type deliveryQueue struct {
+	items   []*delivery
+	running bool
+}
+
+// dispatcher sends the messages with a worker per destination account, so a
+// slow or stuck bridge doesn't hold up the others. A worker sends the messages
+// of its account one at a time in the order they were received, which keeps
+// the order per channel.
+type dispatcher struct {
+	sync.Mutex
+
+	router *Router
+	queues map[string]*deliveryQueue
+	// queued is the number of messages waiting or being sent.
+	queued int
+	idle   *sync.Cond
+}
+
+func newDispatcher(r *Router) *dispatcher {
+	d := &dispatcher{
+		router: r,
+		queues: make(map[string]*deliveryQueue),
+	}
+	d.idle = sync.NewCond(&d.Mutex)
+	return d
+}
+
+// dispatch queues the message for the worker of the destination account.
+// It never blocks, so it's safe to call with the router lock held.
+func (d *dispatcher) dispatch(item *delivery) {
+	d.Lock()
+	defer d.Unlock()
+	account := item.dest.Account
+	q, ok := d.queues[account]
+	if !ok {
+		q = &deliveryQueue{}
+		d.queues[account] = q
+	}
+	if len(q.items) >= maxDeliveryQueueLength {
+		item.gw.logger.Errorf("Delivery queue for %s is full, dropping message to %s", account, item.channel.Name)
+		return
+	}
+	q.items = append(q.items, item)
+	d.queued++
+	if !q.running {
+		q.running = true
+		go d.run(account, q)
+	}
+}
+
+// run sends the messages of the queue until it's empty.
+func (d *dispatcher) run(account string, q *deliveryQueue) {
+	for {
+		d.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			delete(d.queues, account)
+			d.Unlock()
+			return
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		d.Unlock()
+
+		d.router.send(item)
+
+		d.Lock()
+		d.queued--
+		if d.queued == 0 {
+			d.idle.Broadcast()
+		}
+		d.Unlock()
+	}
+}
+
+// wait blocks until all dispatched messages are sent.
+func (d *dispatcher) wait() {
+	d.Lock()
+	defer d.Unlock()
+	for d.queued > 0 {
+		d.idle.Wait()
+	}
+}
+
+// drain blocks until all dispatched messages are sent or ctx is done.
+func (d *dispatcher) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send sends a dispatched message to its destination channel, or queues it
+// for a retry when that fails.
+// The router lock isn't held while sending, a slow bridge would block reloads
+// and with them the delivery to all the other bridges.
+func (r *Router) send(item *delivery) {
+	gw := item.gw
+	if item.reply {
+		msg := *item.msg
+		msg.Extra = copyExtra(item.msg.Extra)
+		if _, err := item.dest.Send(msg); err != nil {
+			gw.logger.Errorf("Sending reply to %s (%s) failed: %s", item.dest.Account, item.channel.Name, err)
+		}
+		return
+	}
+	// keep the order when earlier messages are waiting to be retried
+	if r.outbox.pending(item.dest, &item.channel) &&
+		r.outbox.enqueue(gw, item.msg, item.dest, &item.channel, item.parentID, 0) {
+		return
+	}
+	msgID, err := gw.SendMessage(item.msg, item.dest, &item.channel, item.parentID)
+	// the first of several file messages may have been sent
+	gw.addDestMsgID(item.msg, item.dest, &item.channel, msgID)
+	if err != nil {
+		gw.logger.Errorf("SendMessage failed: %s", err)
+		msg := item.msg
+		var fileErr *fileSendError
+		if errors.As(err, &fileErr) {
+			msg = fileErr.unsent(msg)
+		}
+		r.outbox.enqueue(gw, msg, item.dest, &item.channel, item.parentID, 1)
+	}
+}
+
+// copyExtra returns a copy of extra with copies of its slices.
+func copyExtra(extra map[string][]interface{}) map[string][]interface{} {
+	if extra == nil {
+		return nil
+	}
+	c := make(map[string][]interface{}, len(extra))
+	for key, values := range extra {
+		c[key] = append([]interface{}(nil), values...)
+	}
+	return c
+}
+
+// addDestMsgID records the ID of the message sent to the destination channel
+// for edits and threading. An ID that is already known isn't replaced, edits
+// don't change the mapping.
+func (gw *Gateway) addDestMsgID(rmsg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo, msgID string) {
+	if msgID == "" || rmsg.ID == "" {
+		return
+	}
+	gw.Router.msgIDLock.Lock()
+	defer gw.Router.msgIDLock.Unlock()
+	key := rmsg.Protocol + " " + rmsg.ID
+	ids, _ := gw.Messages.Get(key)
+	for _, id := range ids {
+		if id.Account == dest.Account && id.ChannelID == channel.ID {
+			return
+		}
+	}
+	ids = append(append([]msgstore.DestID{}, ids...), msgstore.DestID{
+		Account:   dest.Account,
+		ID:        dest.Protocol + " " + msgID,
+		ChannelID: channel.ID,
+	})
+	gw.Messages.Add(key, ids)
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	bfake "github.com/42wim/matterbridge/bridge/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var e2eTestConfig = `
+[general]
+RemoteNickFormat="<{NICK}> "
+%s
+[fake.one]
+server=""
+[fake.two]
+server=""
+PreserveThreading=true
+[fake.three]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="fake.one"
+    channel="#one"
+    [[gateway.inout]]
+    account="fake.two"
+    channel="two"
+[[gateway]]
+name="bridge2"
+enable=true
+    [[gateway.inout]]
+    account="fake.three"
+    channel="three"
+`
+
+const e2eTimeout = time.Second
+
+// newFakeRouter returns a router with fake bridges for the configuration,
+// extra is added to its general section.
+func newFakeRouter(t *testing.T, extra string) (*Router, map[string]*bfake.Bfake) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	bridgers := make(map[string]*bfake.Bfake)
+	factory := func(cfg *bridge.Config) bridge.Bridger {
+		b := bfake.New(cfg)
+		bridgers[cfg.Account] = b.(*bfake.Bfake)
+		return b
+	}
+	cfg := config.NewConfigFromString(logger, []byte(fmt.Sprintf(e2eTestConfig, extra)))
+	r, err := NewRouter(logger, cfg, map[string]bridge.Factory{"fake": factory})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, r.Stop(context.Background()))
+	})
+	return r, bridgers
+}
+
+// startFakeRouter starts a router returned by newFakeRouter.
+func startFakeRouter(t *testing.T, extra string) (*Router, map[string]*bfake.Bfake) {
+	r, bridgers := newFakeRouter(t, extra)
+	require.NoError(t, r.Start())
+	return r, bridgers
+}
+
+func TestE2ERouting(t *testing.T) {
+	_, bridgers := startFakeRouter(t, "")
+	one, two, three := bridgers["fake.one"], bridgers["fake.two"], bridgers["fake.three"]
+	assert.True(t, one.IsConnected())
+	assert.Equal(t, []string{"#one"}, one.Joined())
+
+	one.Receive("#one", config.Message{Text: "hello", Username: "alice"})
+	sent := two.WaitSent(1, e2eTimeout)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "hello", sent[0].Text)
+	assert.Equal(t, "<alice> ", sent[0].Username)
+	assert.Equal(t, "two", sent[0].Channel)
+	assert.Equal(t, "bridge1", sent[0].Gateway)
+
+	// messages of other channels and other gateways aren't relayed
+	one.Receive("#elsewhere", config.Message{Text: "ignored", Username: "alice"})
+	three.Receive("three", config.Message{Text: "alone", Username: "carol"})
+	two.Receive("two", config.Message{Text: "hi", Username: "bob"})
+	require.Len(t, one.WaitSent(1, e2eTimeout), 1)
+	assert.Equal(t, "hi", one.Sent()[0].Text)
+	assert.Len(t, two.Sent(), 1)
+	assert.Empty(t, three.Sent())
+}
+
+func TestE2ETimestamps(t *testing.T) {
+	_, bridgers := startFakeRouter(t, "")
+	one, two := bridgers["fake.one"], bridgers["fake.two"]
+
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	before := time.Now()
+	one.Receive("#one", config.Message{Text: "hello", Username: "alice", Timestamp: sentAt})
+	sent := two.WaitSent(1, e2eTimeout)
+	require.Len(t, sent, 1)
+	assert.True(t, sentAt.Equal(sent[0].Timestamp), "the time of the bridge is kept")
+	assert.False(t, sent[0].ReceivedAt.Before(before))
+}
+
+func TestE2EEditsAndThreads(t *testing.T) {
+	_, bridgers := startFakeRouter(t, "")
+	one, two := bridgers["fake.one"], bridgers["fake.two"]
+
+	id := one.Receive("#one", config.Message{Text: "question", Username: "alice"})
+	require.Len(t, two.WaitSent(1, e2eTimeout), 1)
+	destID := two.SentIDs()[0]
+
+	one.Receive("#one", config.Message{ID: id, Text: "question?", Username: "alice"})
+	one.Receive("#one", config.Message{Text: "answer", Username: "alice", ParentID: id})
+	sent := two.WaitSent(3, e2eTimeout)
+	require.Len(t, sent, 3)
+	assert.Equal(t, destID, sent[1].ID, "edit of the relayed message")
+	assert.Equal(t, "question?", sent[1].Text)
+	assert.Equal(t, destID, sent[2].ParentID, "reply to the relayed message")
+
+	// fake.one doesn't preserve threads
+	two.Receive("two", config.Message{Text: "reply", Username: "bob", ParentID: destID})
+	require.Len(t, one.WaitSent(1, e2eTimeout), 1)
+	assert.Equal(t, config.ParentIDNotFound, one.Sent()[0].ParentID)
+
+	one.Receive("#one", config.Message{ID: id, Event: config.EventMsgDelete, Text: config.EventMsgDelete, Username: "alice"})
+	sent = two.WaitSent(4, e2eTimeout)
+	require.Len(t, sent, 4)
+	assert.Equal(t, config.EventMsgDelete, sent[3].Event)
+	assert.Equal(t, destID, sent[3].ID)
+}
+
+func TestE2EFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, bridgers := startFakeRouter(t, fmt.Sprintf(`MediaDownloadPath=%q
+MediaServerDownload="https://media.example.org"`, dir))
+	one, two := bridgers["fake.one"], bridgers["fake.two"]
+
+	data := []byte("data")
+	one.Receive("#one", config.Message{
+		Username: "alice",
+		Extra:    map[string][]interface{}{"file": {config.FileInfo{Name: "my file.txt", Data: &data}}},
+	})
+	sent := two.WaitSent(1, e2eTimeout)
+	require.Len(t, sent, 1)
+	require.Len(t, sent[0].Extra["file"], 1)
+	fi := sent[0].Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, "my file.txt", fi.Name)
+	assert.Equal(t, "https://media.example.org/"+fi.SHA+"/my_file.txt", fi.URL)
+	stored, err := ioutil.ReadFile(filepath.Join(dir, fi.SHA, "my_file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, stored)
+}
+
+func TestE2ETengo(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "out.tengo")
+	require.NoError(t, os.WriteFile(script, []byte(`
+text := import("text")
+if text.contains(msgText, "secret") {
+	msgDrop = true
+}
+msgText = outAccount + ": " + msgText
+`), 0o600))
+	r, bridgers := startFakeRouter(t, "")
+	r.BridgeValues().Tengo.OutMessage = script
+	one, two := bridgers["fake.one"], bridgers["fake.two"]
+
+	one.Receive("#one", config.Message{Text: "secret", Username: "alice"})
+	one.Receive("#one", config.Message{Text: "public", Username: "alice"})
+	sent := two.WaitSent(1, e2eTimeout)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "fake.two: public", sent[0].Text)
+}
+
+func TestE2EFailures(t *testing.T) {
+	r, bridgers := startFakeRouter(t, "OutboundQueueRetries=3")
+	r.outbox.backoff.Min = time.Millisecond
+	r.outbox.backoff.Max = time.Millisecond
+	one, two, three := bridgers["fake.one"], bridgers["fake.two"], bridgers["fake.three"]
+
+	// failed messages are retried
+	two.FailSends(2)
+	one.Receive("#one", config.Message{Text: "retried", Username: "alice"})
+	sent := two.WaitSent(1, e2eTimeout)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "retried", sent[0].Text)
+
+	// a slow bridge doesn't hold up the others
+	two.SetDelay(200 * time.Millisecond)
+	one.Receive("#one", config.Message{Text: "slow", Username: "alice"})
+	three.Receive("three", config.Message{Text: "fast", Username: "carol"})
+	two.Receive("two", config.Message{Text: "fast", Username: "bob"})
+	require.Len(t, one.WaitSent(1, e2eTimeout), 1)
+	assert.Len(t, two.Sent(), 1)
+}
+
+func TestE2EBackfill(t *testing.T) {
+	extra := fmt.Sprintf("Backfill=true\nBackfillPath=%q", filepath.Join(t.TempDir(), "backfill.json"))
+
+	r, bridgers := startFakeRouter(t, extra)
+	first := bridgers["fake.one"].Receive("#one", config.Message{Text: "before", Username: "alice"})
+	require.Len(t, bridgers["fake.two"].WaitSent(1, e2eTimeout), 1)
+	require.NoError(t, r.Stop(context.Background()))
+
+	// alice posted while matterbridge was restarted
+	r, bridgers = newFakeRouter(t, extra)
+	one, two := bridgers["fake.one"], bridgers["fake.two"]
+	posted := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	one.Post("#one", config.Message{ID: first, Text: "before", Username: "alice"})
+	one.Post("#one", config.Message{Text: "missed", Username: "alice", Timestamp: posted})
+	one.Post("#one", config.Message{Text: "missed too", Username: "alice", Timestamp: posted.Add(time.Minute)})
+	require.NoError(t, r.Start())
+
+	sent := two.WaitSent(2, e2eTimeout)
+	require.Len(t, sent, 2)
+	assert.Equal(t, "[sent 2024-01-01 12:00] missed", sent[0].Text)
+	assert.True(t, sent[0].Backfill)
+	assert.True(t, posted.Equal(sent[0].Timestamp))
+	assert.Equal(t, "[sent 2024-01-01 12:01] missed too", sent[1].Text)
+
+	// messages are backfilled once
+	r.backfill(r.getBridge("fake.one"))
+	one.Receive("#one", config.Message{Text: "live", Username: "alice"})
+	sent = two.WaitSent(3, e2eTimeout)
+	require.Len(t, sent, 3)
+	assert.Equal(t, "live", sent[2].Text)
+	assert.False(t, sent[2].Backfill)
+}
@@ -6,10 +6,12 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/msgstore"
 	"github.com/42wim/matterbridge/internal"
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/stdlib"
@@ -28,9 +30,16 @@ type Gateway struct {
 	ChannelOptions map[string]config.ChannelOptions
 	Message        chan config.Message
 	Name           string
-	Messages       *lru.Cache
-
-	logger *logrus.Entry
+	Messages       msgstore.Store
+
+	// texts holds the start of the recent messages for the text of reactions.
+	texts *lru.Cache
+	// typing holds when the last typing event was sent to a channel.
+	typing      map[string]time.Time
+	typingMutex sync.Mutex
+	// disabled gateways don't relay messages, guarded by the router lock.
+	disabled bool
+	logger   *logrus.Entry
 }
 
 type BrMsgID struct {
@@ -39,47 +48,44 @@ type BrMsgID struct {
 	ChannelID string
 }
 
-const apiProtocol = "api"
+const (
+	apiProtocol = "api"
+
+	// reactionTextLength is the number of characters of a message quoted in
+	// the text of a reaction.
+	reactionTextLength = 30
+	textCacheSize      = 1000
+
+	// typingInterval is the minimum time between the typing events sent to a
+	// channel, so a typing user doesn't flood the other networks.
+	typingInterval = 5 * time.Second
+)
 
 // New creates a new Gateway object associated with the specified router and
 // following the given configuration.
 func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "gateway"})
 
-	cache, _ := lru.New(5000)
 	gw := &Gateway{
 		Channels: make(map[string]*config.ChannelInfo),
 		Message:  r.Message,
 		Router:   r,
 		Bridges:  make(map[string]*bridge.Bridge),
 		Config:   r.Config,
-		Messages: cache,
+		Messages: r.msgStore.Scope(cfg.Name),
+		typing:   make(map[string]time.Time),
 		logger:   logger,
 	}
+	gw.texts, _ = lru.New(textCacheSize)
 	if err := gw.AddConfig(cfg); err != nil {
 		logger.Errorf("Failed to add configuration to gateway: %#v", err)
 	}
 	return gw
 }
 
-// FindCanonicalMsgID returns the ID under which a message was stored in the cache.
+// FindCanonicalMsgID returns the ID under which a message was stored in the message store.
 func (gw *Gateway) FindCanonicalMsgID(protocol string, mID string) string {
-	ID := protocol + " " + mID
-	if gw.Messages.Contains(ID) {
-		return ID
-	}
-
-	// If not keyed, iterate through cache for downstream, and infer upstream.
-	for _, mid := range gw.Messages.Keys() {
-		v, _ := gw.Messages.Peek(mid)
-		ids := v.([]*BrMsgID)
-		for _, downstreamMsgObj := range ids {
-			if ID == downstreamMsgObj.ID {
-				return mid.(string)
-			}
-		}
-	}
-	return ""
+	return gw.Messages.FindCanonical(protocol + " " + mID)
 }
 
 // AddBridge sets up a new bridge in the gateway object with the specified configuration.
@@ -89,7 +95,9 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 		gw.checkConfig(cfg)
 		br = bridge.New(cfg)
 		br.Config = gw.Router.Config
-		br.General = &gw.BridgeValues().General
+		// the bridge keeps the [general] section it was started with
+		general := gw.BridgeValues().General
+		br.General = &general
 		br.Log = gw.logger.WithFields(logrus.Fields{"prefix": br.Protocol})
 		brconfig := &bridge.Config{
 			Remote: gw.Message,
@@ -145,6 +153,7 @@ func (gw *Gateway) mapChannelsToBridge(br *bridge.Bridge) {
 }
 
 func (gw *Gateway) reconnectBridge(br *bridge.Bridge) {
+	br.SetConnected(false)
 	if err := br.Disconnect(); err != nil {
 		gw.logger.Errorf("Disconnect() %s failed: %s", br.Account, err)
 	}
@@ -157,10 +166,12 @@ RECONNECT:
 		time.Sleep(time.Second * 60)
 		goto RECONNECT
 	}
+	br.SetConnected(true)
 	br.Joined = make(map[string]bool)
 	if err := br.JoinChannels(); err != nil {
 		gw.logger.Errorf("JoinChannels() %s failed: %s", br.Account, err)
 	}
+	gw.Router.backfill(br)
 }
 
 func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
@@ -259,12 +270,11 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 }
 
 func (gw *Gateway) getDestMsgID(msgID string, dest *bridge.Bridge, channel *config.ChannelInfo) string {
-	if res, ok := gw.Messages.Get(msgID); ok {
-		IDs := res.([]*BrMsgID)
+	if IDs, ok := gw.Messages.Get(msgID); ok {
 		for _, id := range IDs {
-			// check protocol, bridge name and channelname
+			// check account (protocol and bridge name) and channelname
 			// for people that reuse the same bridge multiple times. see #342
-			if dest.Protocol == id.br.Protocol && dest.Name == id.br.Name && channel.ID == id.ChannelID {
+			if dest.Account == id.Account && channel.ID == id.ChannelID {
 				return strings.Replace(id.ID, dest.Protocol+" ", "", 1)
 			}
 		}
@@ -277,7 +287,7 @@ func (gw *Gateway) ignoreTextEmpty(msg *config.Message) bool {
 	if msg.Text != "" {
 		return false
 	}
-	if msg.Event == config.EventUserTyping {
+	if msg.Event == config.EventUserTyping || msg.Event == config.EventReaction {
 		return false
 	}
 	// we have an attachment or actual bytes, do not ignore
@@ -402,9 +412,22 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 		gw.logger.Errorf("Tengo.Message failed: %s", err)
 	}
 
+	// tengo scripts only see the text, changing it drops the formatting
+	formatted := msg.RichText()
+	if formatted != nil {
+		// the deliveries for the other gateways share the document
+		formatted = formatted.Clone()
+		msg.Formatted = formatted
+	}
+
 	// replace :emoji: to unicode
 	emoji.ReplacePadding = ""
 	msg.Text = emoji.Sprint(msg.Text)
+	if formatted != nil {
+		formatted.MapText(func(text string) string {
+			return emoji.Sprint(text)
+		})
+	}
 
 	br := gw.Bridges[msg.Account]
 	// loop to replace messages
@@ -418,9 +441,21 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 			break
 		}
 		msg.Text = re.ReplaceAllString(msg.Text, replace)
+		if formatted != nil {
+			formatted.MapText(func(text string) string {
+				return re.ReplaceAllString(text, replace)
+			})
+		}
 	}
 
+	// the formatting follows the replacements, but not the extracted nicks
+	text := msg.Text
 	gw.handleExtractNicks(msg)
+	if formatted != nil && msg.Text == text {
+		formatted.Source = msg.Text
+	} else {
+		msg.Formatted = nil
+	}
 
 	// messages from api have Gateway specified, don't overwrite
 	if msg.Protocol != apiProtocol {
@@ -437,6 +472,9 @@ func (gw *Gateway) SendMessage(
 	canonicalParentMsgID string,
 ) (string, error) {
 	msg := *rmsg
+	// the bridges can change Extra, the message is sent to other
+	// destinations concurrently and retried later
+	msg.Extra = copyExtra(rmsg.Extra)
 	// Only send the avatar download event to ourselves.
 	if msg.Event == config.EventAvatarDownload {
 		if channel.ID != getChannelID(rmsg) {
@@ -449,11 +487,6 @@ func (gw *Gateway) SendMessage(
 		}
 	}
 
-	// Only send irc notices to irc
-	if msg.Event == config.EventNoticeIRC && dest.Protocol != "irc" {
-		return "", nil
-	}
-
 	// Too noisy to log like other events
 	debugSendMessage := ""
 	if msg.Event != config.EventUserTyping {
@@ -469,8 +502,19 @@ func (gw *Gateway) SendMessage(
 		msg.ID = gw.getDestMsgID(rmsg.Protocol+" "+rmsg.ID, dest, channel)
 	}
 
-	// for api we need originchannel as channel
-	if dest.Protocol == apiProtocol {
+	// the message to delete isn't known on dest
+	if msg.Event == config.EventMsgDelete && msg.ID == "" {
+		return "", nil
+	}
+
+	// bridges that can't edit get edits as new messages
+	if _, ok := dest.Bridger.(bridge.Editor); !ok && (msg.Event == "" || msg.Event == config.EventUserAction) {
+		msg.ID = ""
+	}
+
+	// for api and archive we need originchannel as channel
+	_, recorder := dest.Bridger.(bridge.Recorder)
+	if recorder {
 		msg.Channel = rmsg.Channel
 	}
 
@@ -485,12 +529,28 @@ func (gw *Gateway) SendMessage(
 		msg.ParentID = config.ParentIDNotFound
 	}
 
+	if _, ok := dest.Bridger.(bridge.Threader); !ok && msg.Event != config.EventReaction {
+		msg.ParentID = ""
+	}
+
+	if msg.Event == config.EventReaction && !gw.handleReaction(&msg, dest, canonicalParentMsgID) {
+		gw.logger.Debugf("=> Dropping reaction from %s (%s) to %s (%s), reacted message not found", msg.Account, rmsg.Channel, dest.Account, channel.Name)
+		return "", nil
+	}
+
+	if rmsg.Backfill && !recorder {
+		gw.markBackfill(&msg, dest)
+	}
+
+	gw.translateMentions(&msg, dest, channel)
+
 	drop, err := gw.modifyOutMessageTengo(rmsg, &msg, dest)
 	if err != nil {
 		gw.logger.Errorf("modifySendMessageTengo: %s", err)
 	}
 
 	if drop {
+		metricTengoDrops.Inc(dest.Account)
 		gw.logger.Debugf("=> Tengo dropping %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
 		return "", nil
 	}
@@ -500,18 +560,78 @@ func (gw *Gateway) SendMessage(
 	}
 	// if we are using mattermost plugin account, send messages to MattermostPlugin channel
 	// that can be picked up by the mattermost matterbridge plugin
-	if dest.Account == "mattermost.plugin" {
+	if _, ok := dest.Bridger.(bridge.PluginRelay); ok {
 		gw.Router.MattermostPlugin <- msg
 	}
 
+	// bridges that can't upload files get their URL's as text, like the
+	// files restored from OutboundQueuePath without their data
+	if _, ok := dest.Bridger.(bridge.FileUploader); (!ok || !hasFileData(&msg)) && len(msg.Extra["file"]) > 0 {
+		separator := ": "
+		if fs, ok := dest.Bridger.(bridge.FileSeparator); ok {
+			separator = fs.FileCommentSeparator()
+		}
+		var firstID string
+		for idx, fmsg := range fileMessages(&msg, separator) {
+			mID, err := gw.send(fmsg, rmsg.Channel, dest, channel)
+			if err != nil {
+				if idx > 0 {
+					err = &fileSendError{err: err, sent: idx}
+				}
+				return firstID, err
+			}
+			if idx == 0 {
+				firstID = mID
+			}
+		}
+		return firstID, nil
+	}
+	return gw.send(msg, rmsg.Channel, dest, channel)
+}
+
+// fileSendError is returned by SendMessage when a file message failed after
+// the first ones were sent to a bridge that can't upload files. A retry only
+// needs to send the files after them.
+type fileSendError struct {
+	err  error
+	sent int
+}
+
+func (e *fileSendError) Error() string {
+	return fmt.Sprintf("%s (after %d file messages)", e.err, e.sent)
+}
+
+func (e *fileSendError) Unwrap() error {
+	return e.err
+}
+
+// unsent returns a copy of msg with only the files that weren't sent. The
+// other extras went with the first message.
+func (e *fileSendError) unsent(msg *config.Message) *config.Message {
+	files := msg.Extra["file"]
+	for sent := 0; sent < e.sent && len(files) > 0; files = files[1:] {
+		if fi, ok := files[0].(config.FileInfo); ok && (fi.URL != "" || fi.Comment != "") {
+			sent++
+		}
+	}
+	unsent := *msg
+	unsent.Extra = map[string][]interface{}{"file": append([]interface{}(nil), files...)}
+	return &unsent
+}
+
+// send sends msg from the source channel to channel of dest.
+func (gw *Gateway) send(msg config.Message, source string, dest *bridge.Bridge, channel *config.ChannelInfo) (string, error) {
 	defer func(t time.Time) {
-		gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, time.Since(t))
+		metricSendDuration.Observe(time.Since(t).Seconds(), dest.Account)
+		gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, source, dest.Account, channel.Name, time.Since(t))
 	}(time.Now())
 
 	mID, err := dest.Send(msg)
 	if err != nil {
+		metricSendErrors.Inc(dest.Account)
 		return mID, err
 	}
+	metricMessagesSent.Inc(dest.Account, channel.Name)
 
 	// append the message ID (mID) from this bridge (dest) to our brMsgIDs slice
 	if mID != "" {
@@ -522,6 +642,51 @@ func (gw *Gateway) SendMessage(
 	return "", nil
 }
 
+// hasFileData returns true if all the files of msg have their data.
+func hasFileData(msg *config.Message) bool {
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Data == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// fileMessages returns a text message with the comment and URL of every file
+// of msg, for bridges that can't upload files. The other extras are kept on
+// the first message.
+func fileMessages(msg *config.Message, separator string) []config.Message {
+	extra := make(map[string][]interface{})
+	for key, value := range msg.Extra {
+		if key != "file" {
+			extra[key] = value
+		}
+	}
+	var msgs []config.Message
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		text := fi.Comment
+		switch {
+		case fi.URL != "" && fi.Comment != "":
+			text = fi.Comment + separator + fi.URL
+		case fi.URL != "":
+			text = fi.URL
+		case text == "":
+			continue
+		}
+		fmsg := *msg
+		fmsg.ID = ""
+		fmsg.Text = text
+		fmsg.Extra = extra
+		extra = nil
+		msgs = append(msgs, fmsg)
+	}
+	return msgs
+}
+
 func (gw *Gateway) validGatewayDest(msg *config.Message) bool {
 	return msg.Gateway == gw.Name
 }
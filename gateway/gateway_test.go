@@ -1,13 +1,23 @@
 package gateway
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/gateway/msgstore"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -507,3 +517,884 @@ func BenchmarkTengo(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkFindCanonicalMsgID(b *testing.B) {
+	for _, size := range []int{100, 5000, 20000} {
+		gw := &Gateway{Messages: msgstore.NewMemory(size).Scope("bench")}
+		for i := 0; i < size; i++ {
+			gw.Messages.Add("slack "+strconv.Itoa(i), []msgstore.DestID{
+				{Account: "irc.zzz", ID: "irc " + strconv.Itoa(i), ChannelID: "#mainirc.zzz"},
+				{Account: "discord.zzz", ID: "discord " + strconv.Itoa(i), ChannelID: "maindiscord.zzz"},
+			})
+		}
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			// the oldest downstream ID was the worst case for a linear scan
+			for n := 0; n < b.N; n++ {
+				if gw.FindCanonicalMsgID("discord", "0") != "slack 0" {
+					b.Fatal("canonical ID not found")
+				}
+			}
+		})
+	}
+}
+
+type testBridger struct {
+	sync.Mutex
+
+	joined   []string
+	sent     []config.Message
+	failures int
+	// failOn makes Send fail once for the message with this text.
+	failOn       string
+	disconnected bool
+	// block makes Send wait for a value, like a slow bridge.
+	block chan struct{}
+	// extractFiles makes Send remove the files from Extra, like bmumble.
+	extractFiles bool
+}
+
+// Send records the message and returns its index as message ID, or an error
+// while failures is positive.
+func (b *testBridger) Send(msg config.Message) (string, error) {
+	if b.block != nil {
+		<-b.block
+	}
+	if b.extractFiles && msg.Extra != nil {
+		files := msg.Extra["file"]
+		msg.Extra["file"] = nil
+		msg.Extra = map[string][]interface{}{"file": files}
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return "", errors.New("send failed")
+	}
+	if b.failOn != "" && b.failOn == msg.Text {
+		b.failOn = ""
+		return "", errors.New("send failed")
+	}
+	b.sent = append(b.sent, msg)
+	return strconv.Itoa(len(b.sent) - 1), nil
+}
+
+func (b *testBridger) sentTexts() []string {
+	b.Lock()
+	defer b.Unlock()
+	var texts []string
+	for _, msg := range b.sent {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func (b *testBridger) Connect() error { return nil }
+func (b *testBridger) Disconnect() error {
+	b.disconnected = true
+	return nil
+}
+
+func (b *testBridger) JoinChannel(channel config.ChannelInfo) error {
+	b.Lock()
+	defer b.Unlock()
+	b.joined = append(b.joined, channel.Name)
+	return nil
+}
+
+func (b *testBridger) joinedChannels() []string {
+	b.Lock()
+	defer b.Unlock()
+	return append([]string(nil), b.joined...)
+}
+
+// ircTestBridger has the capabilities of the irc bridge.
+type ircTestBridger struct {
+	*testBridger
+}
+
+func (b ircTestBridger) ReactionsAsText() {}
+func (b ircTestBridger) SendsNotices()    {}
+func (b ircTestBridger) ListsMembers()    {}
+
+func (b ircTestBridger) FileCommentSeparator() string {
+	return " : "
+}
+
+// richTestBridger has the capabilities of the slack and discord bridges.
+type richTestBridger struct {
+	*testBridger
+}
+
+func (b richTestBridger) EditsMessages()    {}
+func (b richTestBridger) DeletesMessages()  {}
+func (b richTestBridger) PreservesThreads() {}
+func (b richTestBridger) SendsReactions()   {}
+func (b richTestBridger) SendsTyping()      {}
+func (b richTestBridger) UploadsFiles()     {}
+func (b richTestBridger) ListsMembers()     {}
+
+type recorderTestBridger struct {
+	richTestBridger
+}
+
+func (b recorderTestBridger) RecordsMessages() {}
+
+type pluginTestBridger struct {
+	richTestBridger
+}
+
+func (b pluginTestBridger) RelaysToPlugin() {}
+
+var reloadTestConfig = []byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+[discord.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+
+[[gateway]]
+name="bridge2"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#other"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="other"
+`)
+
+var reloadTestConfig2 = []byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+[discord.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+
+[[gateway]]
+name="bridge3"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#new"
+    [[gateway.inout]]
+    account="discord.zzz"
+    channel="new"
+`)
+
+// maketestBridgerRouter returns a router using testBridgers for all bridges.
+func maketestBridgerRouter(t *testing.T, input []byte) (*Router, map[string]*testBridger) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return maketestBridgerRouterFromConfig(t, logger, config.NewConfigFromString(logger, input))
+}
+
+func maketestBridgerRouterFromConfig(t *testing.T, logger *logrus.Logger, cfg config.Config) (*Router, map[string]*testBridger) {
+	bridgers := make(map[string]*testBridger)
+	factory := func(cfg *bridge.Config) bridge.Bridger {
+		b := &testBridger{}
+		bridgers[cfg.Account] = b
+		switch {
+		case cfg.Protocol == "irc":
+			return ircTestBridger{b}
+		case cfg.Protocol == "archive":
+			return recorderTestBridger{richTestBridger{b}}
+		case cfg.Account == "mattermost.plugin":
+			return pluginTestBridger{richTestBridger{b}}
+		}
+		return richTestBridger{b}
+	}
+	bridgeMap := map[string]bridge.Factory{
+		"irc": factory, "slack": factory, "discord": factory, "archive": factory, "mattermost": factory,
+	}
+
+	r, err := NewRouter(logger, cfg, bridgeMap)
+	assert.NoError(t, err)
+	return r, bridgers
+}
+
+func TestReload(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, reloadTestConfig)
+	logger := r.rootLogger
+	for _, br := range r.bridges() {
+		assert.NoError(t, br.JoinChannels())
+	}
+	bridge1 := r.Gateways["bridge1"]
+	irc := r.getBridge("irc.zzz")
+
+	r.Config = config.NewConfigFromString(logger, reloadTestConfig2)
+	r.Reload()
+
+	assert.Len(t, r.Gateways, 2)
+	assert.Same(t, bridge1, r.Gateways["bridge1"], "unchanged gateway was replaced")
+	assert.Contains(t, r.Gateways, "bridge3")
+	assert.Same(t, irc, r.Gateways["bridge3"].Bridges["irc.zzz"], "running bridge was replaced")
+
+	assert.ElementsMatch(t, []string{"#main", "#other", "#new"}, bridgers["irc.zzz"].joined)
+	assert.Equal(t, []string{"new"}, bridgers["discord.zzz"].joined)
+	assert.False(t, bridgers["irc.zzz"].disconnected)
+	assert.False(t, bridgers["slack.zzz"].disconnected)
+	assert.NotContains(t, irc.Channels, "#otherirc.zzz")
+	assert.NotContains(t, irc.Joined, "#otherirc.zzz")
+
+	// removing the last gateway of a bridge disconnects it
+	r.Config = config.NewConfigFromString(logger, reloadTestConfig)
+	r.Reload()
+	assert.True(t, bridgers["discord.zzz"].disconnected)
+	assert.False(t, bridgers["irc.zzz"].disconnected)
+	assert.ElementsMatch(t, []string{"#main", "#other", "#new", "#other"}, bridgers["irc.zzz"].joined)
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	path := filepath.Join(t.TempDir(), "matterbridge.toml")
+	assert.NoError(t, ioutil.WriteFile(path, reloadTestConfig, 0o600))
+	cfg := config.NewConfig(logger, path)
+	// removing the file stops watching it
+	defer os.Remove(path)
+	r, bridgers := maketestBridgerRouterFromConfig(t, logger, cfg)
+	assert.NoError(t, r.Start())
+	defer func() {
+		assert.NoError(t, r.Stop(context.Background()))
+	}()
+
+	// the configuration is read while it's reloaded
+	done := make(chan struct{})
+	reading := make(chan struct{})
+	defer func() {
+		close(done)
+		<-reading
+	}()
+	go func() {
+		defer close(reading)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = r.BridgeValues().Gateway
+			_, _ = r.GetString("irc.zzz.RemoteNickFormat")
+			select {
+			case r.Message <- config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main"}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	assert.NoError(t, ioutil.WriteFile(path, reloadTestConfig2, 0o600))
+	assert.Eventually(t, func() bool {
+		r.RLock()
+		defer r.RUnlock()
+		_, ok := r.Gateways["bridge3"]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "the changed configuration file wasn't reloaded")
+	// the reload created the discord bridger before adding bridge3
+	assert.Eventually(t, func() bool {
+		for _, channel := range bridgers["discord.zzz"].joinedChannels() {
+			if channel == "new" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	assert.Len(t, r.BridgeValues().Gateway, 2)
+	assert.Equal(t, "bridge3", r.BridgeValues().Gateway[1].Name)
+}
+
+func TestMessageMetrics(t *testing.T) {
+	r, _ := maketestBridgerRouter(t, reloadTestConfig)
+	received := metricMessagesReceived.Value("irc.zzz")
+	sent := metricMessagesSent.Value("slack.zzz", "main")
+
+	r.handleMessage(&config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main"})
+	r.handleMessage(&config.Message{Event: config.EventFailure, Account: "irc.zzz"})
+	r.dispatcher.wait()
+
+	assert.Equal(t, received+1, metricMessagesReceived.Value("irc.zzz"))
+	assert.Equal(t, sent+1, metricMessagesSent.Value("slack.zzz", "main"))
+}
+
+var queueTestConfig = []byte(`
+[general]
+OutboundQueueRetries=3
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`)
+
+func TestOutboundQueue(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, queueTestConfig)
+	r.outbox.backoff.Min = time.Millisecond
+	r.outbox.backoff.Max = 5 * time.Millisecond
+	slack := bridgers["slack.zzz"]
+	slack.failures = 2
+
+	r.handleMessage(&config.Message{Text: "one", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1"})
+	r.handleMessage(&config.Message{Text: "two", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "2"})
+
+	assert.Eventually(t, func() bool {
+		return len(slack.sentTexts()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"one", "two"}, slack.sentTexts(), "messages were reordered")
+	assert.Eventually(t, func() bool {
+		return r.Gateways["bridge1"].FindCanonicalMsgID("slack", "1") == "irc 2"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "irc 1", r.Gateways["bridge1"].FindCanonicalMsgID("slack", "0"))
+
+	// give up after OutboundQueueRetries
+	slack.failures = 10
+	r.handleMessage(&config.Message{Text: "three", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "3"})
+	r.dispatcher.wait()
+	assert.Eventually(t, func() bool {
+		return !r.outbox.pending(r.getBridge("slack.zzz"), r.Gateways["bridge1"].Channels["mainslack.zzz"])
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"one", "two"}, slack.sentTexts())
+}
+
+func TestOutboundQueueFiles(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, queueTestConfig)
+	r.outbox.backoff.Min = time.Millisecond
+	r.outbox.backoff.Max = 5 * time.Millisecond
+	irc := bridgers["irc.zzz"]
+	irc.failOn = "http://media/b.png"
+
+	r.handleMessage(&config.Message{
+		Text: "files", Username: "user", Account: "slack.zzz", Channel: "main", ID: "1",
+		Extra: map[string][]interface{}{"file": {
+			config.FileInfo{Name: "a.png", Comment: "files", URL: "http://media/a.png"},
+			config.FileInfo{Name: "c.png"},
+			config.FileInfo{Name: "b.png", URL: "http://media/b.png"},
+			config.FileInfo{Name: "d.png", URL: "http://media/d.png"},
+		}},
+	})
+
+	// the retry resumes after the files that were sent
+	assert.Eventually(t, func() bool {
+		return len(irc.sentTexts()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"files : http://media/a.png", "http://media/b.png", "http://media/d.png"}, irc.sentTexts())
+	assert.Equal(t, "slack 1", r.Gateways["bridge1"].FindCanonicalMsgID("irc", "0"))
+}
+
+func TestOutboundQueuePersist(t *testing.T) {
+	dir := t.TempDir()
+	r, bridgers := maketestBridgerRouter(t, queueTestConfig)
+	r.BridgeValues().General.OutboundQueuePath = dir
+	// retries are far in the future, so the message stays queued
+	r.outbox.backoff.Min = time.Hour
+	r.outbox.backoff.Max = time.Hour
+	bridgers["slack.zzz"].failures = 1
+	data := []byte("data")
+	r.handleMessage(&config.Message{
+		Text: "one", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1",
+		Extra: map[string][]interface{}{
+			"file": {
+				config.FileInfo{Name: "file.txt", Comment: "one", URL: "http://media/file.txt", Data: &data},
+			},
+			"attachments": {map[string]interface{}{"text": "attached"}},
+			"native":      {struct{ Text string }{"native"}},
+		},
+	})
+	r.dispatcher.wait()
+	r.outbox.save()
+
+	// the data of the files isn't saved
+	saved, err := ioutil.ReadFile(filepath.Join(dir, "slack.zzz.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(saved), "http://media/file.txt")
+	assert.NotContains(t, string(saved), base64.StdEncoding.EncodeToString(data))
+
+	// loading doesn't write the queues again
+	r2, _ := maketestBridgerRouter(t, queueTestConfig)
+	r2.BridgeValues().General.OutboundQueuePath = dir
+	r2.outbox.backoff.Min = time.Hour
+	r2.outbox.backoff.Max = time.Hour
+	assert.NoError(t, r2.outbox.load())
+	assert.True(t, r2.outbox.pending(r2.getBridge("slack.zzz"), r2.Gateways["bridge1"].Channels["mainslack.zzz"]))
+	r2.outbox.Lock()
+	assert.Empty(t, r2.outbox.dirty)
+	r2.outbox.Unlock()
+	r2.outbox.stop()
+
+	// without their data the files are sent as links
+	r3, bridgers3 := maketestBridgerRouter(t, queueTestConfig)
+	r3.BridgeValues().General.OutboundQueuePath = dir
+	r3.outbox.backoff.Min = time.Millisecond
+	assert.NoError(t, r3.outbox.load())
+	assert.Eventually(t, func() bool {
+		return len(bridgers3["slack.zzz"].sentTexts()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"one: http://media/file.txt"}, bridgers3["slack.zzz"].sentTexts())
+	sent := bridgers3["slack.zzz"].sent[0]
+	assert.Empty(t, sent.Extra["file"])
+	// the extras with types of the bridges are dropped
+	assert.Equal(t, []interface{}{map[string]interface{}{"text": "attached"}}, sent.Extra["attachments"])
+	assert.NotContains(t, sent.Extra, "native")
+}
+
+func TestOutboundQueueStale(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "slack.zzz.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[{"Gateway":"removed","Account":"slack.zzz","Msg":{"text":"one"}}]`), 0o600))
+
+	r, _ := maketestBridgerRouter(t, queueTestConfig)
+	r.BridgeValues().General.OutboundQueuePath = dir
+	assert.NoError(t, r.outbox.load())
+	r.outbox.save()
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err), "stale queue wasn't removed")
+}
+
+var recorderTestConfig = []byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+[archive.zzz]
+server=""
+[mattermost.plugin]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.out]]
+    account="archive.zzz"
+    channel="log"
+    [[gateway.out]]
+    account="mattermost.plugin"
+    channel="plugin"
+`)
+
+func TestRecorderAndPlugin(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, recorderTestConfig)
+	plugin := make(chan config.Message, 1)
+	go func() {
+		plugin <- <-r.MattermostPlugin
+	}()
+
+	posted := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	r.handleMessage(&config.Message{
+		Text: "missed", Username: "user", Account: "irc.zzz", Channel: "#main", Backfill: true, Timestamp: posted,
+	})
+	r.dispatcher.wait()
+
+	// the archive gets the message as it was received
+	assert.Equal(t, []string{"[sent 2024-01-01 12:00] missed"}, bridgers["slack.zzz"].sentTexts())
+	if assert.Len(t, bridgers["archive.zzz"].sent, 1) {
+		assert.Equal(t, "missed", bridgers["archive.zzz"].sent[0].Text)
+		assert.Equal(t, "#main", bridgers["archive.zzz"].sent[0].Channel)
+	}
+
+	select {
+	case msg := <-plugin:
+		assert.Equal(t, "plugin", msg.Channel)
+		assert.Equal(t, "[sent 2024-01-01 12:00] missed", msg.Text)
+	case <-time.After(time.Second):
+		t.Fatal("message not handed to the plugin")
+	}
+}
+
+var slowTestConfig = []byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+[discord.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="discord.zzz"
+    channel="main"
+
+[[gateway]]
+name="bridge2"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#other"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="other"
+`)
+
+func TestSlowBridge(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack := bridgers["slack.zzz"]
+	discord := bridgers["discord.zzz"]
+	slack.block = make(chan struct{})
+
+	for _, text := range []string{"one", "two", "three"} {
+		r.handleMessage(&config.Message{Text: text, Username: "user", Account: "irc.zzz", Channel: "#main", ID: text})
+	}
+	r.handleMessage(&config.Message{Text: "other", Username: "user", Account: "irc.zzz", Channel: "#other", ID: "other"})
+
+	// discord doesn't wait for slack
+	assert.Eventually(t, func() bool {
+		return len(discord.sentTexts()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"one", "two", "three"}, discord.sentTexts())
+	assert.Empty(t, slack.sentTexts())
+	assert.Equal(t, "irc one", r.Gateways["bridge1"].FindCanonicalMsgID("discord", "0"))
+
+	for i := 0; i < 4; i++ {
+		slack.block <- struct{}{}
+	}
+	r.dispatcher.wait()
+	assert.Equal(t, []string{"one", "two", "three", "other"}, slack.sentTexts(), "messages were reordered")
+	assert.Equal(t, "irc three", r.Gateways["bridge1"].FindCanonicalMsgID("slack", "2"))
+	assert.Equal(t, "irc other", r.Gateways["bridge2"].FindCanonicalMsgID("slack", "3"))
+}
+
+func TestRequestChannelMembers(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	for _, br := range r.bridges() {
+		br.SetConnected(true)
+	}
+	r.getBridge("discord.zzz").SetConnected(false)
+
+	r.requestChannelMembers()
+	for account, asked := range map[string]bool{"irc.zzz": true, "slack.zzz": true, "discord.zzz": false} {
+		if !asked {
+			assert.Empty(t, bridgers[account].sent, account)
+		} else if assert.Len(t, bridgers[account].sent, 1, account) {
+			assert.Equal(t, config.EventGetChannelMembers, bridgers[account].sent[0].Event)
+		}
+	}
+
+	members := config.ChannelMembers{{Username: "alice", Nick: "Alice", UserID: "U1", ChannelID: "C1", ChannelName: "main"}}
+	r.handleEventGetChannelMembers(&config.Message{
+		Account: "slack.zzz",
+		Event:   config.EventGetChannelMembers,
+		Extra:   map[string][]interface{}{config.EventGetChannelMembers: {members}},
+	})
+	assert.Equal(t, members, r.getBridge("slack.zzz").GetChannelMembers())
+}
+
+func TestUserTypingRateLimit(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	typing := func() {
+		r.handleMessage(&config.Message{Event: config.EventUserTyping, Account: "discord.zzz", Channel: "main"})
+		r.dispatcher.wait()
+	}
+
+	typing()
+	typing()
+	assert.Len(t, bridgers["slack.zzz"].sent, 1, "typing wasn't rate limited")
+	assert.Empty(t, bridgers["irc.zzz"].sent, "irc doesn't support typing")
+
+	gw := r.Gateways["bridge1"]
+	gw.typingMutex.Lock()
+	gw.typing["mainslack.zzz"] = time.Now().Add(-typingInterval)
+	gw.typingMutex.Unlock()
+	typing()
+	assert.Len(t, bridgers["slack.zzz"].sent, 2)
+}
+
+func TestEditAfterSlowSend(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack := bridgers["slack.zzz"]
+	slack.block = make(chan struct{})
+
+	r.handleMessage(&config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1"})
+	r.handleMessage(&config.Message{Text: "hello!", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1"})
+	close(slack.block)
+	r.dispatcher.wait()
+
+	// the edit is sent after the original message got its ID
+	if assert.Len(t, slack.sent, 2) {
+		assert.Equal(t, "", slack.sent[0].ID)
+		assert.Equal(t, "0", slack.sent[1].ID)
+	}
+}
+
+func TestReaction(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	irc, slack, discord := bridgers["irc.zzz"], bridgers["slack.zzz"], bridgers["discord.zzz"]
+
+	r.handleMessage(&config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1"})
+	r.dispatcher.wait()
+	r.handleMessage(&config.Message{
+		Username: "user", Account: "slack.zzz", Channel: "main", Event: config.EventReaction, ParentID: "0",
+		Reaction: &config.Reaction{Emoji: "👍"},
+	})
+	r.dispatcher.wait()
+
+	// native reactions go to the message on the destination
+	if assert.Len(t, discord.sent, 2) {
+		assert.Equal(t, config.EventReaction, discord.sent[1].Event)
+		assert.Equal(t, "0", discord.sent[1].ParentID)
+		assert.Equal(t, "👍", discord.sent[1].Reaction.Emoji)
+	}
+	assert.Len(t, slack.sent, 1, "reaction was sent back to its origin")
+	assert.Equal(t, []string{`reacted 👍 to "hello"`}, irc.sentTexts())
+
+	r.handleMessage(&config.Message{
+		Username: "user", Account: "slack.zzz", Channel: "main", Event: config.EventReaction, ParentID: "unknown",
+		Reaction: &config.Reaction{Emoji: "👍", Removed: true},
+	})
+	r.dispatcher.wait()
+	assert.Len(t, discord.sent, 2, "reaction to unknown message was sent")
+	assert.Equal(t, []string{`reacted 👍 to "hello"`, "removed 👍 from a message"}, irc.sentTexts())
+}
+
+func TestDowngrade(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	irc, discord := bridgers["irc.zzz"], bridgers["discord.zzz"]
+	data := []byte("data")
+
+	r.handleMessage(&config.Message{Text: "hello", Username: "user", Account: "slack.zzz", Channel: "main", ID: "1"})
+	r.dispatcher.wait()
+	r.handleMessage(&config.Message{Text: "hello!", Username: "user", Account: "slack.zzz", Channel: "main", ID: "1"})
+	r.handleMessage(&config.Message{Text: "reply", Username: "user", Account: "slack.zzz", Channel: "main", ID: "2", ParentID: "1"})
+	r.handleMessage(&config.Message{
+		Text: config.EventMsgDelete, Username: "user", Account: "slack.zzz", Channel: "main", ID: "1", Event: config.EventMsgDelete,
+	})
+	r.handleMessage(&config.Message{
+		Text: "files", Username: "user", Account: "slack.zzz", Channel: "main", ID: "3",
+		Extra: map[string][]interface{}{"file": {
+			config.FileInfo{Name: "a.png", Comment: "files", URL: "http://media/a.png", Data: &data},
+			config.FileInfo{Name: "b.png", URL: "http://media/b.png", Data: &data},
+			config.FileInfo{Name: "c.png", Data: &data},
+		}},
+	})
+	r.dispatcher.wait()
+
+	// the edit is a new message, the delete is dropped and the files are links
+	if assert.Len(t, irc.sent, 5) {
+		assert.Equal(t, "", irc.sent[1].ID)
+		assert.Equal(t, "", irc.sent[2].ParentID)
+		for _, msg := range irc.sent {
+			assert.NotEqual(t, config.EventMsgDelete, msg.Event)
+			assert.Empty(t, msg.Extra["file"])
+		}
+	}
+	assert.Equal(t, []string{"hello", "hello!", "reply", "files : http://media/a.png", "http://media/b.png"}, irc.sentTexts())
+
+	if assert.Len(t, discord.sent, 5) {
+		assert.Equal(t, "0", discord.sent[1].ID)
+		assert.Equal(t, config.EventMsgDelete, discord.sent[3].Event)
+		assert.Len(t, discord.sent[4].Extra["file"], 3)
+	}
+}
+
+func TestFilesToSeveralDestinations(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack, discord := bridgers["slack.zzz"], bridgers["discord.zzz"]
+	slack.extractFiles = true
+	discord.extractFiles = true
+
+	for i := 0; i < 10; i++ {
+		data := []byte("data")
+		r.handleMessage(&config.Message{
+			Text: "file", Username: "user", Account: "irc.zzz", Channel: "#main", ID: strconv.Itoa(i),
+			Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "file.txt", Data: &data}}},
+		})
+	}
+	r.dispatcher.wait()
+
+	for _, b := range []*testBridger{slack, discord} {
+		if assert.Len(t, b.sent, 10) {
+			for _, msg := range b.sent {
+				assert.Len(t, msg.Extra["file"], 1)
+			}
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack, discord := bridgers["slack.zzz"], bridgers["discord.zzz"]
+	slack.block = make(chan struct{})
+	assert.NoError(t, r.Start())
+
+	r.Message <- config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main"}
+	assert.Eventually(t, func() bool {
+		return len(discord.sentTexts()) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := make(chan error)
+	go func() {
+		stopped <- r.Stop(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		r.RLock()
+		defer r.RUnlock()
+		return r.stopped
+	}, time.Second, time.Millisecond)
+	r.Message <- config.Message{Text: "ignored", Username: "user", Account: "irc.zzz", Channel: "#main"}
+
+	// the message being sent is finished before disconnecting
+	slack.block <- struct{}{}
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, []string{"hello"}, slack.sentTexts())
+	assert.Equal(t, []string{"hello"}, discord.sentTexts())
+	for account, b := range bridgers {
+		assert.True(t, b.disconnected, account+" wasn't disconnected")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack := bridgers["slack.zzz"]
+	slack.block = make(chan struct{})
+	assert.NoError(t, r.Start())
+
+	r.Message <- config.Message{Text: "hello", Username: "user", Account: "irc.zzz", Channel: "#main"}
+	assert.Eventually(t, func() bool {
+		return len(bridgers["discord.zzz"].sentTexts()) == 1
+	}, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.Stop(ctx))
+
+	close(slack.block)
+	r.dispatcher.wait()
+}
+
+var richTextTestConfig = []byte(`
+[irc.zzz]
+server=""
+ReplaceMessages=[ ["hello","hi"] ]
+[slack.zzz]
+server=""
+[discord.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="discord.zzz"
+    channel="main"
+`)
+
+func TestRichText(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, richTextTestConfig)
+	discord := bridgers["discord.zzz"]
+
+	formatted := richtext.ParseIRC("\x02hello\x02 :smile:")
+	formatted.Source = formatted.Plain()
+	r.handleMessage(&config.Message{
+		Text: formatted.Source, Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1",
+		Formatted: formatted,
+	})
+	r.dispatcher.wait()
+	// the replacements apply to the formatting too
+	if assert.Len(t, discord.sent, 1) {
+		msg := discord.sent[0]
+		assert.Equal(t, "hi 😄", msg.Text)
+		if assert.NotNil(t, msg.RichText()) {
+			assert.Equal(t, "**hi** 😄", richtext.RenderMarkdown(msg.RichText()))
+		}
+	}
+	assert.Equal(t, "hello :smile:", formatted.Plain(), "the formatting of the received message was changed")
+
+	// formatting of another text is dropped
+	r.handleMessage(&config.Message{
+		Text: "changed", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "2",
+		Formatted: richtext.ParseMarkdown("**hello**"),
+	})
+	r.dispatcher.wait()
+	if assert.Len(t, discord.sent, 2) {
+		assert.Nil(t, discord.sent[1].RichText())
+	}
+}
+
+func TestTranslateMentions(t *testing.T) {
+	r, bridgers := maketestBridgerRouter(t, slowTestConfig)
+	slack, discord := bridgers["slack.zzz"], bridgers["discord.zzz"]
+	gw := r.Gateways["bridge1"]
+	gw.Bridges["slack.zzz"].SetChannelMembers(&config.ChannelMembers{
+		{Username: "alice", Nick: "Alice A", UserID: "U1", ChannelName: "main"},
+		{Username: "bob", UserID: "U2", ChannelName: "other"},
+	})
+	gw.Bridges["discord.zzz"].SetChannelMembers(&config.ChannelMembers{
+		{Username: "bob", UserID: "42", ChannelName: "main"},
+	})
+
+	formatted := richtext.ParseIRC("\x02hi\x02 @alice and @bob")
+	formatted.Source = formatted.Plain()
+	r.handleMessage(&config.Message{
+		Text: formatted.Source, Username: "user", Account: "irc.zzz", Channel: "#main", ID: "1",
+		Formatted: formatted,
+	})
+	r.dispatcher.wait()
+	if assert.Len(t, slack.sent, 1) && assert.NotNil(t, slack.sent[0].RichText()) {
+		assert.Equal(t, "*hi* <@U1> and @bob", richtext.RenderSlack(slack.sent[0].RichText()))
+	}
+	if assert.Len(t, discord.sent, 1) && assert.NotNil(t, discord.sent[0].RichText()) {
+		assert.Equal(t, "**hi** @alice and <@42>", richtext.RenderMarkdown(discord.sent[0].RichText()))
+	}
+
+	// mentions parsed by the source bridge use the display name
+	r.handleMessage(&config.Message{
+		Text: "ping @Alice A", Username: "user", Account: "discord.zzz", Channel: "main", ID: "2",
+		Formatted: &richtext.Document{Source: "ping @Alice A", Nodes: []*richtext.Node{
+			{Kind: richtext.Text, Text: "ping "},
+			{Kind: richtext.Mention, Text: "Alice A", UserID: "7"},
+		}},
+	})
+	// messages without formatting get the mentions in the text
+	r.handleMessage(&config.Message{Text: "ping @ALICE", Username: "user", Account: "irc.zzz", Channel: "#main", ID: "3"})
+	r.dispatcher.wait()
+	assert.Equal(t, []string{"hi <@U1> and @bob", "ping <@U1>", "ping <@U1>"}, slack.sentTexts())
+}
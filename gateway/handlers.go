@@ -1,20 +1,17 @@
 package gateway
 
 import (
-	"bytes"
 	"crypto/sha1" //nolint:gosec
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/bridge/helper"
 )
 
 // handleEventFailure handles failures and reconnects bridges.
@@ -25,6 +22,7 @@ func (r *Router) handleEventFailure(msg *config.Message) {
 	for _, gw := range r.Gateways {
 		for _, br := range gw.Bridges {
 			if msg.Account == br.Account {
+				metricReconnects.Inc(br.Account)
 				go gw.reconnectBridge(br)
 				return
 			}
@@ -72,9 +70,7 @@ func (gw *Gateway) handleFiles(msg *config.Message) {
 	reg := regexp.MustCompile("[^a-zA-Z0-9]+")
 
 	// If we don't have a attachfield or we don't have a mediaserver configured return
-	if msg.Extra == nil ||
-		(gw.BridgeValues().General.MediaServerUpload == "" &&
-			gw.BridgeValues().General.MediaDownloadPath == "") {
+	if msg.Extra == nil || gw.Router.mediaStore == nil {
 		return
 	}
 
@@ -92,22 +88,15 @@ func (gw *Gateway) handleFiles(msg *config.Message) {
 
 		sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
 
-		if gw.BridgeValues().General.MediaServerUpload != "" {
-			// Use MediaServerUpload. Upload using a PUT HTTP request and basicauth.
-			if err := gw.handleFilesUpload(&fi); err != nil {
-				gw.logger.Error(err)
-				continue
-			}
-		} else {
-			// Use MediaServerPath. Place the file on the current filesystem.
-			if err := gw.handleFilesLocal(&fi); err != nil {
-				gw.logger.Error(err)
-				continue
-			}
+		if err := gw.Router.mediaStore.Put(sha1sum+"/"+fi.Name, *fi.Data, fi.Avatar); err != nil {
+			gw.logger.Error(err)
+			metricMediaUploadFailures.Inc()
+			continue
 		}
+		metricMediaUploads.Inc()
 
 		// Download URL.
-		durl := gw.BridgeValues().General.MediaServerDownload + "/" + sha1sum + "/" + fi.Name
+		durl := helper.MediaURL(&gw.BridgeValues().General, sha1sum+"/"+fi.Name)
 
 		gw.logger.Debugf("mediaserver download URL = %s", durl)
 
@@ -119,57 +108,12 @@ func (gw *Gateway) handleFiles(msg *config.Message) {
 	}
 }
 
-// handleFilesUpload uses MediaServerUpload configuration to upload the file.
-// Returns error on failure.
-func (gw *Gateway) handleFilesUpload(fi *config.FileInfo) error {
-	client := &http.Client{
-		Timeout: time.Second * 5,
-	}
-	// Use MediaServerUpload. Upload using a PUT HTTP request and basicauth.
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
-	url := gw.BridgeValues().General.MediaServerUpload + "/" + sha1sum + "/" + fi.Name
-
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(*fi.Data))
-	if err != nil {
-		return fmt.Errorf("mediaserver upload failed, could not create request: %#v", err)
-	}
-
-	gw.logger.Debugf("mediaserver upload url: %s", url)
-
-	req.Header.Set("Content-Type", "binary/octet-stream")
-	_, err = client.Do(req)
-	if err != nil {
-		return fmt.Errorf("mediaserver upload failed, could not Do request: %#v", err)
-	}
-	return nil
-}
-
-// handleFilesLocal use MediaServerPath configuration, places the file on the current filesystem.
-// Returns error on failure.
-func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
-	dir := gw.BridgeValues().General.MediaDownloadPath + "/" + sha1sum
-	err := os.Mkdir(dir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return fmt.Errorf("mediaserver path failed, could not mkdir: %s %#v", err, err)
-	}
-
-	path := dir + "/" + fi.Name
-	gw.logger.Debugf("mediaserver path placing file: %s", path)
-
-	err = ioutil.WriteFile(path, *fi.Data, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("mediaserver path failed, could not writefile: %s %#v", err, err)
-	}
-	return nil
-}
-
 // ignoreEvent returns true if we need to ignore this event for the specified destination bridge.
 func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 	switch event {
 	case config.EventAvatarDownload:
-		// Avatar downloads are only relevant for telegram and mattermost for now
-		if dest.Protocol != "mattermost" && dest.Protocol != "telegram" && dest.Protocol != "xmpp" {
+		// Avatar downloads are only relevant for bridges caching avatars
+		if _, ok := dest.Bridger.(bridge.AvatarCacher); !ok {
 			return true
 		}
 	case config.EventJoinLeave:
@@ -179,7 +123,25 @@ func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 		}
 	case config.EventTopicChange:
 		// only relay topic change when used in some way on other side
-		if !dest.GetBool("ShowTopicChange") && !dest.GetBool("SyncTopic") {
+		_, setter := dest.Bridger.(bridge.TopicSetter)
+		if !dest.GetBool("ShowTopicChange") && !(setter && dest.GetBool("SyncTopic")) {
+			return true
+		}
+	case config.EventUserTyping:
+		// Not all bridges support "user is typing" indications
+		if _, ok := dest.Bridger.(bridge.TypingSender); !ok {
+			return true
+		}
+	case config.EventMsgDelete:
+		if _, ok := dest.Bridger.(bridge.Deleter); !ok {
+			return true
+		}
+	case config.EventReaction:
+		if !reactionSupport(dest) {
+			return true
+		}
+	case config.EventNoticeIRC:
+		if _, ok := dest.Bridger.(bridge.NoticeSender); !ok {
 			return true
 		}
 	}
@@ -187,53 +149,57 @@ func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 }
 
 // handleMessage makes sure the message get sent to the correct bridge/channels.
-// Returns an array of msg ID's
-func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*BrMsgID {
-	var brMsgIDs []*BrMsgID
-
-	// Not all bridges support "user is typing" indications so skip the message
-	// if the targeted bridge does not support it.
-	if rmsg.Event == config.EventUserTyping {
-		if _, ok := bridgemap.UserTypingSupport[dest.Protocol]; !ok {
-			return nil
-		}
-	}
-
+// The message is dispatched to the worker of the destination bridge, which
+// records the message ID's once it has been sent.
+func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) {
 	// if we have an attached file, or other info
 	if rmsg.Extra != nil && len(rmsg.Extra[config.EventFileFailureSize]) != 0 && rmsg.Text == "" {
-		return brMsgIDs
+		return
 	}
 
 	if gw.ignoreEvent(rmsg.Event, dest) {
-		return brMsgIDs
+		return
 	}
 
 	// broadcast to every out channel (irc QUIT)
 	if rmsg.Channel == "" && rmsg.Event != config.EventJoinLeave {
 		gw.logger.Debug("empty channel")
-		return brMsgIDs
+		return
 	}
 
-	// Get the ID of the parent message in thread
+	// Get the ID of the parent message in thread, or the reacted message
 	var canonicalParentMsgID string
-	if rmsg.ParentID != "" && dest.GetBool("PreserveThreading") {
+	if rmsg.ParentID != "" && (preserveThreading(dest) || rmsg.Event == config.EventReaction) {
 		canonicalParentMsgID = gw.FindCanonicalMsgID(rmsg.Protocol, rmsg.ParentID)
 	}
 
-	channels := gw.getDestChannel(rmsg, *dest)
-	for idx := range channels {
-		channel := &channels[idx]
-		msgID, err := gw.SendMessage(rmsg, dest, channel, canonicalParentMsgID)
-		if err != nil {
-			gw.logger.Errorf("SendMessage failed: %s", err)
+	// the caller keeps modifying rmsg for the other gateways
+	msg := *rmsg
+	for _, channel := range gw.getDestChannel(rmsg, *dest) {
+		if rmsg.Event == config.EventUserTyping && !gw.allowTyping(channel.ID) {
 			continue
 		}
-		if msgID == "" {
-			continue
-		}
-		brMsgIDs = append(brMsgIDs, &BrMsgID{dest, dest.Protocol + " " + msgID, channel.ID})
+		gw.Router.dispatcher.dispatch(&delivery{
+			gw:       gw,
+			msg:      &msg,
+			dest:     dest,
+			channel:  channel,
+			parentID: canonicalParentMsgID,
+		})
+	}
+}
+
+// allowTyping returns true if a typing event can be sent to the channel, at
+// most one is sent every typingInterval.
+func (gw *Gateway) allowTyping(channelID string) bool {
+	gw.typingMutex.Lock()
+	defer gw.typingMutex.Unlock()
+	now := time.Now()
+	if now.Sub(gw.typing[channelID]) < typingInterval {
+		return false
 	}
-	return brMsgIDs
+	gw.typing[channelID] = now
+	return true
 }
 
 func (gw *Gateway) handleExtractNicks(msg *config.Message) {
@@ -273,3 +239,57 @@ func extractNick(search, extract, username, text string) (string, string, error)
 	}
 	return username, text, nil
 }
+
+// reactionSupport returns true if reactions are relayed to dest, natively or
+// as text.
+func reactionSupport(dest *bridge.Bridge) bool {
+	switch dest.Bridger.(type) {
+	case bridge.Reactor, bridge.ReactionTexter:
+		return true
+	}
+	return false
+}
+
+// preserveThreading returns true if replies are sent in threads on dest.
+func preserveThreading(dest *bridge.Bridge) bool {
+	_, ok := dest.Bridger.(bridge.Threader)
+	return ok && dest.GetBool("PreserveThreading")
+}
+
+// handleReaction prepares the reaction msg for dest. Protocols without native
+// reactions get a text message, like `reacted 👍 to "hello"`.
+// Returns false if the reaction can't be sent, because the reacted message
+// isn't known on dest.
+func (gw *Gateway) handleReaction(msg *config.Message, dest *bridge.Bridge, canonicalParentMsgID string) bool {
+	if msg.Reaction == nil {
+		return false
+	}
+	if _, ok := dest.Bridger.(bridge.Reactor); ok {
+		return msg.ParentValid()
+	}
+	target := "a message"
+	if text, ok := gw.texts.Get(canonicalParentMsgID); ok {
+		target = fmt.Sprintf(`"%s"`, text)
+	}
+	if msg.Reaction.Removed {
+		msg.Text = fmt.Sprintf("removed %s from %s", msg.Reaction.Emoji, target)
+	} else {
+		msg.Text = fmt.Sprintf("reacted %s to %s", msg.Reaction.Emoji, target)
+	}
+	msg.Event = ""
+	msg.ParentID = ""
+	msg.Reaction = nil
+	return true
+}
+
+// rememberText keeps the start of the text of msg for the text of reactions.
+func (gw *Gateway) rememberText(msg *config.Message) {
+	if msg.ID == "" || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	text := strings.Join(strings.Fields(msg.Text), " ")
+	if utf8.RuneCountInString(text) > reactionTextLength {
+		text = string([]rune(text)[:reactionTextLength]) + "…"
+	}
+	gw.texts.Add(msg.Protocol+" "+msg.ID, text)
+}
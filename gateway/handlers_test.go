@@ -8,27 +8,73 @@ import (
 	"testing"
 )
 
+type avatarTestBridger struct {
+	*testBridger
+}
+
+func (b avatarTestBridger) CachesAvatars() {}
+
 func TestIgnoreEvent(t *testing.T) {
 	eventTests := map[string]struct {
 		input  string
 		dest   *bridge.Bridge
 		output bool
 	}{
-		"avatar mattermost": {
+		"avatar cacher": {
 			input:  config.EventAvatarDownload,
-			dest:   &bridge.Bridge{Protocol: "mattermost"},
+			dest:   &bridge.Bridge{Bridger: avatarTestBridger{}},
 			output: false,
 		},
 		"avatar slack": {
 			input:  config.EventAvatarDownload,
-			dest:   &bridge.Bridge{Protocol: "slack"},
+			dest:   &bridge.Bridge{Bridger: richTestBridger{}},
 			output: true,
 		},
-		"avatar telegram": {
+		"avatar irc": {
 			input:  config.EventAvatarDownload,
-			dest:   &bridge.Bridge{Protocol: "telegram"},
+			dest:   &bridge.Bridge{Bridger: ircTestBridger{}},
+			output: true,
+		},
+		"typing irc": {
+			input:  config.EventUserTyping,
+			dest:   &bridge.Bridge{Bridger: ircTestBridger{}},
+			output: true,
+		},
+		"typing": {
+			input:  config.EventUserTyping,
+			dest:   &bridge.Bridge{Bridger: richTestBridger{}},
 			output: false,
 		},
+		"delete irc": {
+			input:  config.EventMsgDelete,
+			dest:   &bridge.Bridge{Bridger: ircTestBridger{}},
+			output: true,
+		},
+		"notice irc": {
+			input:  config.EventNoticeIRC,
+			dest:   &bridge.Bridge{Bridger: ircTestBridger{}},
+			output: false,
+		},
+		"notice": {
+			input:  config.EventNoticeIRC,
+			dest:   &bridge.Bridge{Bridger: richTestBridger{}},
+			output: true,
+		},
+		"reaction irc": {
+			input:  config.EventReaction,
+			dest:   &bridge.Bridge{Bridger: ircTestBridger{}},
+			output: false,
+		},
+		"reaction native": {
+			input:  config.EventReaction,
+			dest:   &bridge.Bridge{Bridger: richTestBridger{}},
+			output: false,
+		},
+		"reaction unsupported": {
+			input:  config.EventReaction,
+			dest:   &bridge.Bridge{Bridger: &testBridger{}},
+			output: true,
+		},
 	}
 	gw := &Gateway{}
 	for testname, testcase := range eventTests {
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/mediastore"
+)
+
+// startMediaServer serves MediaDownloadPath when MediaServerBindAddress is configured.
+func (r *Router) startMediaServer() error {
+	general := r.BridgeValues().General
+	if general.MediaServerBindAddress == "" {
+		return nil
+	}
+	if general.MediaDownloadPath == "" {
+		return fmt.Errorf("MediaServerBindAddress is configured without a MediaDownloadPath")
+	}
+	tracker, _ := r.mediaStore.(mediastore.AccessTracker)
+	r.mediaServer = &http.Server{ //nolint:gosec
+		Addr:    general.MediaServerBindAddress,
+		Handler: newMediaHandler(&general, tracker),
+	}
+	go func() {
+		r.logger.Infof("Media server listening on %s", general.MediaServerBindAddress)
+		if err := r.mediaServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			r.logger.Errorf("Media server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// newMediaHandler returns a handler serving the files in MediaDownloadPath on
+// /<sha>/<name>, like MediaServerDownload expects.
+// With MediaServerSigningKey the URLs must have a valid signature that hasn't
+// expired, with MediaServerToken the token must be sent as bearer token or
+// token parameter. Either one is enough when both are configured.
+// Files starting with a dot, like the metadata of the store, aren't served.
+func newMediaHandler(general *config.Protocol, tracker mediastore.AccessTracker) http.Handler {
+	root := http.Dir(general.MediaDownloadPath)
+	signingKey := general.MediaServerSigningKey
+	token := general.MediaServerToken
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(req.URL.Path, "/")
+		if (signingKey != "" || token != "") &&
+			!validMediaSignature(signingKey, key, req) && !validMediaToken(token, req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(key, ".") || strings.Contains(key, "/.") {
+			http.NotFound(w, req)
+			return
+		}
+		f, err := root.Open("/" + key)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", mediastore.ContentType(info.Name()))
+		// the files are uploaded by chat users, don't let browsers run them
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		if tracker != nil {
+			tracker.Accessed(key)
+		}
+		http.ServeContent(w, req, info.Name(), info.ModTime(), f)
+	})
+}
+
+func validMediaSignature(signingKey, key string, req *http.Request) bool {
+	if signingKey == "" {
+		return false
+	}
+	expires, err := strconv.ParseInt(req.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	signature := req.URL.Query().Get("signature")
+	return hmac.Equal([]byte(signature), []byte(helper.MediaSignature(signingKey, key, expires)))
+}
+
+func validMediaToken(token string, req *http.Request) bool {
+	if token == "" {
+		return false
+	}
+	got := req.URL.Query().Get("token")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mediaRequest(general *config.Protocol, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	newMediaHandler(general, nil).ServeHTTP(rec, req)
+	return rec
+}
+
+func testMediaDir(t *testing.T) string {
+	parent := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0o644))
+	dir := filepath.Join(parent, "media")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "1234abcd"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1234abcd", "cat.png"), []byte("0123456789"), 0o644))
+	return dir
+}
+
+func TestMediaServer(t *testing.T) {
+	general := &config.Protocol{MediaDownloadPath: testMediaDir(t)}
+
+	rec := mediaRequest(general, "/1234abcd/cat.png")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "0123456789", rec.Body.String())
+
+	rec = mediaRequest(general, "/1234abcd/cat.png", "Range", "bytes=2-4")
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 2-4/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "234", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, mediaRequest(general, "/1234abcd/dog.png").Code)
+	assert.Equal(t, http.StatusNotFound, mediaRequest(general, "/1234abcd/").Code, "directory listed")
+	assert.Equal(t, http.StatusNotFound, mediaRequest(general, "/1234abcd/../../secret.txt").Code, "file outside MediaDownloadPath served")
+}
+
+func TestMediaServerSigned(t *testing.T) {
+	general := &config.Protocol{
+		MediaDownloadPath:     testMediaDir(t),
+		MediaServerDownload:   "https://example.com",
+		MediaServerSigningKey: "key",
+		MediaServerToken:      "token",
+	}
+	url := helper.MediaURL(general, "1234abcd/cat.png")
+	path := strings.TrimPrefix(url, general.MediaServerDownload)
+	assert.Equal(t, http.StatusOK, mediaRequest(general, path).Code)
+
+	assert.Equal(t, http.StatusForbidden, mediaRequest(general, "/1234abcd/cat.png").Code)
+	assert.Equal(t, http.StatusForbidden, mediaRequest(general, strings.Replace(path, "cat", "dog", 1)).Code, "signature of another file accepted")
+	expired := "/1234abcd/cat.png?expires=1&signature=" + helper.MediaSignature("key", "1234abcd/cat.png", 1)
+	assert.Equal(t, http.StatusForbidden, mediaRequest(general, expired).Code)
+
+	// the token works without signature
+	assert.Equal(t, http.StatusOK, mediaRequest(general, "/1234abcd/cat.png?token=token").Code)
+	assert.Equal(t, http.StatusOK, mediaRequest(general, "/1234abcd/cat.png", "Authorization", "Bearer token").Code)
+	assert.Equal(t, http.StatusForbidden, mediaRequest(general, "/1234abcd/cat.png", "Authorization", "Bearer wrong").Code)
+}
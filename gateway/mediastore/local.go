@@ -0,0 +1,244 @@
+package mediastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metadataFile is the file in the root of the local store that keeps the
+// metadata of the stored files. The media server doesn't serve dotfiles.
+const metadataFile = ".matterbridge-media.json"
+
+// sweepInterval is the time between two runs of the retention sweeper.
+const sweepInterval = 10 * time.Minute
+
+// Retention limits the files kept by the local store. Zero values don't limit.
+type Retention struct {
+	// MaxAge removes files stored longer ago.
+	MaxAge time.Duration
+	// MaxSize removes the least recently used files when the files together
+	// are larger.
+	MaxSize int64
+}
+
+// fileMeta is the metadata of a stored file.
+type fileMeta struct {
+	Size     int64
+	Stored   time.Time
+	Accessed time.Time
+	// Avatar is set for the current avatar of a user, which is kept until a
+	// newer avatar supersedes it.
+	Avatar bool
+}
+
+type local struct {
+	sync.Mutex
+
+	logger    *logrus.Entry
+	path      string
+	retention Retention
+	// files holds the metadata by key, only when retention is configured.
+	files map[string]*fileMeta
+	size  int64
+	now   func() time.Time
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewLocal returns a store writing the files below path, for when matterbridge
+// has write access to the directory the webserver is serving.
+// With a retention configured, a sweeper removes the files exceeding it.
+// Files that were already there are tracked from their modification time.
+func NewLocal(logger *logrus.Entry, path string, retention Retention) Store {
+	l := &local{
+		logger:    logger,
+		path:      path,
+		retention: retention,
+		now:       time.Now,
+	}
+	if retention == (Retention{}) {
+		return l
+	}
+	l.files = make(map[string]*fileMeta)
+	l.load()
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.sweeper()
+	return l
+}
+
+func (l *local) Put(key string, data []byte, avatar bool) error {
+	file := filepath.Join(l.path, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return fmt.Errorf("mediaserver path failed, could not mkdir: %s", err)
+	}
+	l.logger.Debugf("mediaserver path placing file: %s", file)
+	if err := ioutil.WriteFile(file, data, os.ModePerm); err != nil {
+		return fmt.Errorf("mediaserver path failed, could not writefile: %s", err)
+	}
+	if l.files == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	if old, ok := l.files[key]; ok {
+		l.size -= old.Size
+	}
+	l.files[key] = &fileMeta{Size: int64(len(data)), Stored: now, Accessed: now, Avatar: avatar}
+	l.size += int64(len(data))
+	if avatar {
+		// older avatars with the same name are superseded
+		for k, meta := range l.files {
+			if k != key && meta.Avatar && path.Base(k) == path.Base(key) {
+				meta.Avatar = false
+			}
+		}
+	}
+	return nil
+}
+
+// Accessed marks the file as used, it's evicted after the files that weren't
+// accessed for a longer time.
+func (l *local) Accessed(key string) {
+	if l.files == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if meta, ok := l.files[key]; ok {
+		meta.Accessed = l.now()
+	}
+}
+
+func (l *local) Close() error {
+	if l.files == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	l.Lock()
+	defer l.Unlock()
+	return l.save()
+}
+
+func (l *local) sweeper() {
+	defer close(l.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		l.sweep()
+		select {
+		case <-ticker.C:
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// sweep removes the files older than MaxAge, then the least recently used
+// files until they fit in MaxSize. Current avatars are never removed.
+func (l *local) sweep() {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	var candidates []string
+	for key, meta := range l.files {
+		if meta.Avatar {
+			continue
+		}
+		if l.retention.MaxAge > 0 && now.Sub(meta.Stored) > l.retention.MaxAge {
+			l.remove(key)
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if l.retention.MaxSize > 0 && l.size > l.retention.MaxSize {
+		sort.Slice(candidates, func(i, j int) bool {
+			return l.files[candidates[i]].Accessed.Before(l.files[candidates[j]].Accessed)
+		})
+		for _, key := range candidates {
+			if l.size <= l.retention.MaxSize {
+				break
+			}
+			l.remove(key)
+		}
+	}
+	if err := l.save(); err != nil {
+		l.logger.Errorf("Saving media metadata failed: %s", err)
+	}
+}
+
+// remove deletes the file and its directory when it's empty.
+// Must be called with the lock held.
+func (l *local) remove(key string) {
+	file := filepath.Join(l.path, filepath.FromSlash(key))
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		l.logger.Errorf("Removing %s failed: %s", file, err)
+		return
+	}
+	l.logger.Debugf("Removed %s from the media store", key)
+	// fails when other files are in the directory
+	_ = os.Remove(filepath.Dir(file))
+	l.size -= l.files[key].Size
+	delete(l.files, key)
+}
+
+// load reads the metadata and adds the files that are missing in it.
+func (l *local) load() {
+	data, err := ioutil.ReadFile(filepath.Join(l.path, metadataFile))
+	if err != nil && !os.IsNotExist(err) {
+		l.logger.Errorf("Reading media metadata failed: %s", err)
+	}
+	stored := make(map[string]*fileMeta)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			l.logger.Errorf("Ignoring corrupt media metadata: %s", err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(l.path, "*", "*"))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		rel, _ := filepath.Rel(l.path, file)
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, ".") {
+			continue
+		}
+		meta, ok := stored[key]
+		if !ok {
+			meta = &fileMeta{Stored: info.ModTime(), Accessed: info.ModTime()}
+		}
+		meta.Size = info.Size()
+		l.files[key] = meta
+		l.size += meta.Size
+	}
+}
+
+// save writes the metadata. Must be called with the lock held.
+func (l *local) save() error {
+	data, err := json.Marshal(l.files)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(l.path, metadataFile)
+	if err := os.MkdirAll(l.path, os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
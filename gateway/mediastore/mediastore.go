@@ -0,0 +1,112 @@
+// Package mediastore stores the files relayed by the gateway, so bridges
+// without native file support can link to them on MediaServerDownload.
+package mediastore
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+)
+
+// uploadTimeout is the time a remote store gets to accept a file. The files
+// are uploaded while the router handles the message, so it is kept short.
+const uploadTimeout = 5 * time.Second
+
+// Store puts files where the mediaserver serves them from.
+type Store interface {
+	// Put stores data under key, which is "<sha>/<filename>". The file must be
+	// available on MediaServerDownload + "/" + key afterwards.
+	// avatar is set for the avatar of a user, which is named after the user.
+	Put(key string, data []byte, avatar bool) error
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// AccessTracker is implemented by stores that evict the least recently used
+// files, Accessed is called when a file is served.
+type AccessTracker interface {
+	Accessed(key string)
+}
+
+// Open returns the store configured with MediaStore in the [general] section,
+// or nil when no store is configured.
+// Without MediaStore, MediaServerUpload or MediaDownloadPath select the store
+// like before it could be configured.
+func Open(logger *logrus.Entry, general *config.Protocol) (Store, error) {
+	if err := Check(general); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: uploadTimeout}
+	switch general.MediaStore {
+	case "webdav":
+		return NewWebDAV(logger, client, general.MediaServerUpload, true), nil
+	case "s3":
+		return NewS3(logger, client, S3Config{
+			Endpoint:  general.MediaS3Endpoint,
+			Region:    general.MediaS3Region,
+			Bucket:    general.MediaS3Bucket,
+			Prefix:    general.MediaS3Prefix,
+			AccessKey: general.MediaS3AccessKey,
+			SecretKey: general.MediaS3SecretKey,
+		}), nil
+	case "local":
+		return NewLocal(logger, general.MediaDownloadPath, retention(general)), nil
+	}
+	switch {
+	case general.MediaServerUpload != "":
+		return NewWebDAV(logger, client, general.MediaServerUpload, false), nil
+	case general.MediaDownloadPath != "":
+		return NewLocal(logger, general.MediaDownloadPath, retention(general)), nil
+	}
+	return nil, nil
+}
+
+// Check returns an error when the store configuration is incomplete.
+func Check(general *config.Protocol) error {
+	switch general.MediaStore {
+	case "":
+	case "local":
+		if general.MediaDownloadPath == "" {
+			return fmt.Errorf("MediaStore local needs MediaDownloadPath to be set")
+		}
+	case "webdav":
+		if general.MediaServerUpload == "" {
+			return fmt.Errorf("MediaStore webdav needs MediaServerUpload to be set")
+		}
+	case "s3":
+		if general.MediaS3Bucket == "" {
+			return fmt.Errorf("MediaStore s3 needs MediaS3Bucket to be set")
+		}
+	default:
+		return fmt.Errorf("unknown MediaStore %s", general.MediaStore)
+	}
+	return nil
+}
+
+func retention(general *config.Protocol) Retention {
+	return Retention{
+		MaxAge:  time.Duration(general.MediaRetentionMaxAge) * time.Hour,
+		MaxSize: int64(general.MediaRetentionMaxSize) * 1024 * 1024,
+	}
+}
+
+// ContentType returns the content type for the extension of the filename.
+func ContentType(name string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// checkResponse returns an error for responses without a 2xx status code.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
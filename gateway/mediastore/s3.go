@@ -0,0 +1,176 @@
+package mediastore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// S3Config is the configuration of an S3 compatible object storage.
+type S3Config struct {
+	// Endpoint is the URL of the storage (eg http://localhost:9000), the
+	// bucket is added to its path. Empty uses the AWS endpoint of the region
+	// with the bucket in the hostname.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+	// Prefix is prepended to the keys of the files.
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+type s3 struct {
+	S3Config
+
+	logger *logrus.Entry
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 returns a store uploading the files to an S3 compatible object storage
+// with requests signed with AWS Signature Version 4.
+func NewS3(logger *logrus.Entry, client *http.Client, cfg S3Config) Store {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &s3{
+		S3Config: cfg,
+		logger:   logger,
+		client:   client,
+		now:      time.Now,
+	}
+}
+
+// objectURL returns the URL of the object with the key.
+func (s *s3) objectURL(key string) string {
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + key
+	}
+	if s.Endpoint == "" {
+		return "https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com/" + uriEncode(key, false)
+	}
+	return s.Endpoint + "/" + s.Bucket + "/" + uriEncode(key, false)
+}
+
+func (s *s3) Close() error {
+	return nil
+}
+
+func (s *s3) Put(key string, data []byte, avatar bool) error {
+	req, err := http.NewRequest("PUT", s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("s3 upload failed, could not create request: %s", err)
+	}
+	req.Header.Set("Content-Type", ContentType(key))
+	sum := sha256.Sum256(data)
+	s.sign(req, hex.EncodeToString(sum[:]), s.now())
+	s.logger.Debugf("s3 upload url: %s", req.URL)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 upload failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("s3 upload failed: %s", err)
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 authorization of the request, signing
+// the host and all headers set on the request.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *s3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters, and the slash
+// unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
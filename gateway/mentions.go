@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+)
+
+// nativeMentions returns the mention of a member in the syntax of the
+// protocol. An empty mention keeps the name, Matrix mentions are rendered as
+// pills from the user ID.
+var nativeMentions = map[string]func(member *config.ChannelMember) string{
+	"discord": func(member *config.ChannelMember) string {
+		return "<@" + member.UserID + ">"
+	},
+	"slack": func(member *config.ChannelMember) string {
+		return "<@" + member.UserID + ">"
+	},
+	"mattermost": func(member *config.ChannelMember) string {
+		return "@" + member.Username
+	},
+	"rocketchat": func(member *config.ChannelMember) string {
+		return "@" + member.Username
+	},
+	"telegram": func(member *config.ChannelMember) string {
+		if member.Username == "" {
+			return ""
+		}
+		return "@" + member.Username
+	},
+	"matrix": func(member *config.ChannelMember) string {
+		return ""
+	},
+	"irc":  memberNick,
+	"xmpp": memberNick,
+}
+
+// memberNick returns the nick of a member, which highlights it on IRC and
+// XMPP.
+func memberNick(member *config.ChannelMember) string {
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.Username
+}
+
+// channelMembers returns the members of the destination that are in channel.
+func channelMembers(dest *bridge.Bridge, channel string) []config.ChannelMember {
+	var members []config.ChannelMember
+	for _, member := range dest.GetChannelMembers() {
+		if member.ChannelName == "" && member.ChannelID == "" ||
+			member.ChannelName == channel || member.ChannelID == channel || "ID:"+member.ChannelID == channel {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// findMember returns the member with the nick or username name, ignoring case.
+func findMember(members []config.ChannelMember, name string) *config.ChannelMember {
+	for i := range members {
+		if strings.EqualFold(members[i].Nick, name) || strings.EqualFold(members[i].Username, name) {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+// translateMentions rewrites the mentions of the members of the destination
+// channel to the native mention syntax of the destination, so they get
+// notified. Mentions are the mentions parsed by the bridge the message came
+// from and the @name typed in the text.
+func (gw *Gateway) translateMentions(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) {
+	native, ok := nativeMentions[dest.Protocol]
+	if !ok || msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+	members := channelMembers(dest, channel.Name)
+	if len(members) == 0 {
+		return
+	}
+	doc := msg.RichText()
+	if doc == nil {
+		msg.Text = richtext.ReplaceMentions(msg.Text, func(name string) string {
+			if member := findMember(members, name); member != nil {
+				return native(member)
+			}
+			return ""
+		})
+		return
+	}
+	// the document is shared with the other destinations
+	doc = doc.Clone()
+	doc.FindMentions(func(name string) bool {
+		return findMember(members, name) != nil
+	})
+	translated := false
+	for _, mention := range doc.Mentions() {
+		member := findMember(members, mention.Text)
+		if member == nil {
+			continue
+		}
+		mention.UserID = member.UserID
+		mention.Native = native(member)
+		translated = true
+	}
+	if !translated {
+		return
+	}
+	gw.logger.Debugf("Translated mentions for %s (%s)", dest.Account, channel.Name)
+	msg.Text = doc.Plain()
+	doc.Source = msg.Text
+	msg.Formatted = doc
+}
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/42wim/matterbridge/gateway/metrics"
+)
+
+var (
+	metricMessagesReceived = metrics.NewCounterVec("matterbridge_messages_received_total",
+		"Messages received from a bridge.", "account")
+	metricMessagesSent = metrics.NewCounterVec("matterbridge_messages_sent_total",
+		"Messages sent to a channel of a bridge.", "account", "channel")
+	metricSendErrors = metrics.NewCounterVec("matterbridge_send_errors_total",
+		"Messages that failed to be sent to a bridge.", "account")
+	metricSendDuration = metrics.NewHistogramVec("matterbridge_send_duration_seconds",
+		"Time taken to send a message to a bridge.", metrics.DefaultBuckets, "account")
+	metricTengoDrops = metrics.NewCounterVec("matterbridge_tengo_drops_total",
+		"Messages dropped by the tengo OutMessage script.", "account")
+	metricMediaUploads = metrics.NewCounterVec("matterbridge_media_uploads_total",
+		"Files uploaded or written to the mediaserver.")
+	metricMediaUploadFailures = metrics.NewCounterVec("matterbridge_media_upload_failures_total",
+		"Files that failed to be uploaded or written to the mediaserver.")
+	metricReconnects = metrics.NewCounterVec("matterbridge_reconnects_total",
+		"Reconnects of a bridge after a failure.", "account")
+)
+
+// startMetrics serves the metrics on /metrics when MetricsBindAddress is configured.
+func (r *Router) startMetrics() {
+	addr := r.BridgeValues().General.MetricsBindAddress
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	r.metricsServer = &http.Server{Addr: addr, Handler: mux} //nolint:gosec
+	go func() {
+		r.logger.Infof("Metrics listening on %s", addr)
+		if err := r.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			r.logger.Errorf("Metrics failed: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterVec(t *testing.T) {
+	DefaultRegistry = &Registry{}
+	c := NewCounterVec("test_total", "A test counter.", "account", "channel")
+	c.Inc("irc.zzz", "#main")
+	c.Inc("irc.zzz", "#main")
+	c.Add(0.5, "slack.zzz", `"quoted"`)
+	assert.Equal(t, float64(2), c.Value("irc.zzz", "#main"))
+	assert.Panics(t, func() { c.Inc("irc.zzz") })
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{account="irc.zzz",channel="#main"} 2
+test_total{account="slack.zzz",channel="\"quoted\""} 0.5
+`, rec.Body.String())
+}
+
+func TestHistogramVec(t *testing.T) {
+	DefaultRegistry = &Registry{}
+	h := NewHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "account")
+	h.Observe(0.05, "irc.zzz")
+	h.Observe(0.5, "irc.zzz")
+	h.Observe(5, "irc.zzz")
+
+	var buf bytes.Buffer
+	DefaultRegistry.Write(&buf)
+	assert.Equal(t, `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{account="irc.zzz",le="0.1"} 1
+test_seconds_bucket{account="irc.zzz",le="1"} 2
+test_seconds_bucket{account="irc.zzz",le="+Inf"} 3
+test_seconds_sum{account="irc.zzz"} 5.55
+test_seconds_count{account="irc.zzz"} 3
+`, buf.String())
+}
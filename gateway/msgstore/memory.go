@@ -0,0 +1,83 @@
+package msgstore
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+type memory struct {
+	size int
+}
+
+// NewMemory returns a backend keeping the last size messages of every gateway
+// in memory. Nothing survives a restart.
+func NewMemory(size int) Backend {
+	return &memory{size: size}
+}
+
+func (m *memory) Scope(gateway string) Store {
+	s := &memoryStore{downstream: make(map[string]string)}
+	s.cache, _ = lru.NewWithEvict(m.size, s.onEvicted)
+	return s
+}
+
+func (m *memory) Close() error {
+	return nil
+}
+
+type memoryStore struct {
+	sync.Mutex
+
+	cache *lru.Cache
+	// downstream maps the destination IDs to the canonical ID in the cache.
+	downstream map[string]string
+}
+
+func (s *memoryStore) Contains(key string) bool {
+	return s.cache.Contains(key)
+}
+
+func (s *memoryStore) Get(key string) ([]DestID, bool) {
+	res, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return res.([]DestID), true
+}
+
+func (s *memoryStore) Add(key string, ids []DestID) {
+	s.Lock()
+	defer s.Unlock()
+	if old, ok := s.cache.Peek(key); ok {
+		s.unindex(key, old.([]DestID))
+	}
+	// adding can evict the oldest entry, onEvicted runs while we hold the lock.
+	s.cache.Add(key, ids)
+	for _, id := range ids {
+		s.downstream[id.ID] = key
+	}
+}
+
+func (s *memoryStore) FindCanonical(id string) string {
+	if s.cache.Contains(id) {
+		return id
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.downstream[id]
+}
+
+// onEvicted is called by the cache with the lock held when key gets evicted.
+func (s *memoryStore) onEvicted(key interface{}, value interface{}) {
+	s.unindex(key.(string), value.([]DestID))
+}
+
+func (s *memoryStore) unindex(key string, ids []DestID) {
+	for _, id := range ids {
+		// only remove the entry if it wasn't overwritten by another message
+		if s.downstream[id.ID] == key {
+			delete(s.downstream, id.ID)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package msgstore keeps track of the mapping between the canonical ID of a
+// relayed message and the IDs it got on every destination bridge. The gateway
+// uses it to relay edits, deletes and threaded replies.
+package msgstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCacheSize is the number of messages kept per gateway by the memory store.
+const DefaultCacheSize = 5000
+
+// DestID is the ID a message got on a destination bridge.
+type DestID struct {
+	// Account is the destination bridge account (eg irc.freenode).
+	Account string
+	// ID is the message ID on the destination, prefixed with the protocol.
+	ID string
+	// ChannelID is the ID of the destination channel (channel name + account).
+	ChannelID string
+}
+
+// Store is a per-gateway mapping of canonical message IDs to destination IDs.
+// Canonical IDs are "<protocol> <id>" of the message on the bridge it
+// originated from.
+type Store interface {
+	// Contains returns true if the canonical ID is known.
+	Contains(key string) bool
+	// Get returns the destination IDs of the canonical ID.
+	Get(key string) ([]DestID, bool)
+	// Add stores the destination IDs for the canonical ID, replacing existing ones.
+	Add(key string, ids []DestID)
+	// FindCanonical returns the canonical ID of a downstream ID, or an empty string.
+	FindCanonical(id string) string
+}
+
+// Backend creates a Store for every gateway and owns the resources they share.
+type Backend interface {
+	// Scope returns the store of the given gateway.
+	Scope(gateway string) Store
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// Open returns the backend configured with MessageStore in the [general] section.
+func Open(logger *logrus.Entry, general *config.Protocol) (Backend, error) {
+	switch general.MessageStore {
+	case "", "memory":
+		return NewMemory(DefaultCacheSize), nil
+	case "sqlite":
+		if general.MessageStorePath == "" {
+			return nil, fmt.Errorf("MessageStore sqlite needs MessageStorePath to be set")
+		}
+		retention := time.Duration(general.MessageStoreRetention) * time.Hour
+		return NewSQLite(logger, general.MessageStorePath, retention)
+	}
+	return nil, fmt.Errorf("unknown MessageStore %s", general.MessageStore)
+}
@@ -0,0 +1,54 @@
+package msgstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testIDs = []DestID{
+	{Account: "irc.zzz", ID: "irc 1", ChannelID: "#mainirc.zzz"},
+	{Account: "slack.zzz", ID: "slack 1.234", ChannelID: "generalslack.zzz"},
+}
+
+func testStore(t *testing.T, s Store) {
+	assert.False(t, s.Contains("discord 1"))
+	_, ok := s.Get("discord 1")
+	assert.False(t, ok)
+
+	s.Add("discord 1", testIDs)
+	s.Add("discord 2", nil)
+	assert.True(t, s.Contains("discord 1"))
+	assert.True(t, s.Contains("discord 2"))
+	ids, ok := s.Get("discord 1")
+	assert.True(t, ok)
+	assert.Equal(t, testIDs, ids)
+
+	assert.Equal(t, "discord 1", s.FindCanonical("discord 1"))
+	assert.Equal(t, "discord 1", s.FindCanonical("slack 1.234"))
+	assert.Equal(t, "discord 2", s.FindCanonical("discord 2"))
+	assert.Equal(t, "", s.FindCanonical("slack 5.678"))
+
+	s.Add("discord 1", testIDs[:1])
+	ids, _ = s.Get("discord 1")
+	assert.Equal(t, testIDs[:1], ids)
+	assert.Equal(t, "", s.FindCanonical("slack 1.234"))
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory(10).Scope("gw"))
+}
+
+func TestMemoryEviction(t *testing.T) {
+	s := NewMemory(2).Scope("gw")
+	s.Add("discord 1", testIDs[:1])
+	s.Add("discord 2", testIDs[1:])
+	assert.Equal(t, "discord 1", s.FindCanonical("irc 1"))
+
+	// evicts discord 1 and its downstream IDs
+	s.Add("discord 3", nil)
+	assert.False(t, s.Contains("discord 1"))
+	assert.Equal(t, "", s.FindCanonical("irc 1"))
+	assert.Equal(t, "discord 2", s.FindCanonical("slack 1.234"))
+	assert.Len(t, s.(*memoryStore).downstream, 1)
+}
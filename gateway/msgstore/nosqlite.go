@@ -0,0 +1,16 @@
+//go:build !sqlite
+// +build !sqlite
+
+package msgstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewSQLite returns an error as matterbridge is built without sqlite support.
+func NewSQLite(logger *logrus.Entry, path string, retention time.Duration) (Backend, error) {
+	return nil, fmt.Errorf("sqlite support not compiled in, rebuild matterbridge with -tags sqlite")
+}
@@ -0,0 +1,207 @@
+//go:build sqlite
+// +build sqlite
+
+package msgstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // needed for sqlite
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	gateway   TEXT NOT NULL,
+	id        TEXT NOT NULL,
+	created   INTEGER NOT NULL,
+	PRIMARY KEY (gateway, id)
+);
+CREATE TABLE IF NOT EXISTS destinations (
+	gateway    TEXT NOT NULL,
+	canonical  TEXT NOT NULL,
+	account    TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	dest_id    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS destinations_canonical ON destinations (gateway, canonical);
+CREATE INDEX IF NOT EXISTS destinations_dest_id ON destinations (gateway, dest_id);
+CREATE INDEX IF NOT EXISTS messages_created ON messages (created);
+`
+
+// pruneInterval is how often messages older than the retention are removed.
+const pruneInterval = time.Hour
+
+type sqliteBackend struct {
+	db        *sql.DB
+	logger    *logrus.Entry
+	retention time.Duration
+	done      chan struct{}
+}
+
+// NewSQLite returns a backend storing the message mapping of all gateways in
+// the SQLite database at path. Mappings older than retention are removed
+// periodically, a retention of 0 keeps them forever.
+func NewSQLite(logger *logrus.Entry, path string, retention time.Duration) (Backend, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows one writer, serialize access instead of retrying on SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	b := &sqliteBackend{
+		db:        db,
+		logger:    logger,
+		retention: retention,
+		done:      make(chan struct{}),
+	}
+	if retention > 0 {
+		b.prune()
+		go b.pruneLoop()
+	}
+	return b, nil
+}
+
+func (b *sqliteBackend) Scope(gateway string) Store {
+	return &sqliteStore{backend: b, gateway: gateway}
+}
+
+func (b *sqliteBackend) Close() error {
+	close(b.done)
+	return b.db.Close()
+}
+
+func (b *sqliteBackend) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.prune()
+		}
+	}
+}
+
+// prune removes all messages older than the retention.
+func (b *sqliteBackend) prune() {
+	before := time.Now().Add(-b.retention).Unix()
+	tx, err := b.db.Begin()
+	if err != nil {
+		b.logger.Errorf("msgstore prune failed: %s", err)
+		return
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.Exec(`DELETE FROM destinations WHERE EXISTS (
+		SELECT 1 FROM messages m WHERE m.gateway = destinations.gateway
+		AND m.id = destinations.canonical AND m.created < ?)`, before); err != nil {
+		b.logger.Errorf("msgstore prune failed: %s", err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE created < ?`, before)
+	if err != nil {
+		b.logger.Errorf("msgstore prune failed: %s", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		b.logger.Errorf("msgstore prune failed: %s", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		b.logger.Debugf("msgstore pruned %d messages", n)
+	}
+}
+
+type sqliteStore struct {
+	backend *sqliteBackend
+	gateway string
+}
+
+func (s *sqliteStore) Contains(key string) bool {
+	var n int
+	err := s.backend.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE gateway = ? AND id = ?`,
+		s.gateway, key).Scan(&n)
+	if err != nil {
+		s.backend.logger.Errorf("msgstore lookup of %s failed: %s", key, err)
+		return false
+	}
+	return n > 0
+}
+
+func (s *sqliteStore) Get(key string) ([]DestID, bool) {
+	if !s.Contains(key) {
+		return nil, false
+	}
+	rows, err := s.backend.db.Query(`SELECT account, dest_id, channel_id FROM destinations
+		WHERE gateway = ? AND canonical = ? ORDER BY rowid`, s.gateway, key)
+	if err != nil {
+		s.backend.logger.Errorf("msgstore lookup of %s failed: %s", key, err)
+		return nil, false
+	}
+	defer rows.Close()
+	ids := []DestID{}
+	for rows.Next() {
+		var id DestID
+		if err := rows.Scan(&id.Account, &id.ID, &id.ChannelID); err != nil {
+			s.backend.logger.Errorf("msgstore lookup of %s failed: %s", key, err)
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		s.backend.logger.Errorf("msgstore lookup of %s failed: %s", key, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+func (s *sqliteStore) Add(key string, ids []DestID) {
+	if err := s.add(key, ids); err != nil {
+		s.backend.logger.Errorf("msgstore add of %s failed: %s", key, err)
+	}
+}
+
+func (s *sqliteStore) add(key string, ids []DestID) error {
+	tx, err := s.backend.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO messages (gateway, id, created) VALUES (?, ?, ?)`,
+		s.gateway, key, time.Now().Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM destinations WHERE gateway = ? AND canonical = ?`,
+		s.gateway, key); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec(`INSERT INTO destinations (gateway, canonical, account, channel_id, dest_id)
+			VALUES (?, ?, ?, ?, ?)`, s.gateway, key, id.Account, id.ChannelID, id.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) FindCanonical(id string) string {
+	if s.Contains(id) {
+		return id
+	}
+	var key string
+	err := s.backend.db.QueryRow(`SELECT canonical FROM destinations WHERE gateway = ? AND dest_id = ? LIMIT 1`,
+		s.gateway, id).Scan(&key)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			s.backend.logger.Errorf("msgstore lookup of %s failed: %s", id, err)
+		}
+		return ""
+	}
+	return key
+}
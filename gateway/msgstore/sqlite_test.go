@@ -0,0 +1,58 @@
+//go:build sqlite
+// +build sqlite
+
+package msgstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return logrus.NewEntry(logger)
+}
+
+func TestSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	b, err := NewSQLite(testLogger(), path, 0)
+	require.NoError(t, err)
+	testStore(t, b.Scope("gw"))
+
+	// gateways don't see each others messages
+	assert.False(t, b.Scope("other").Contains("discord 1"))
+	require.NoError(t, b.Close())
+
+	// the mapping survives a restart
+	b, err = NewSQLite(testLogger(), path, 0)
+	require.NoError(t, err)
+	defer b.Close()
+	ids, ok := b.Scope("gw").Get("discord 1")
+	assert.True(t, ok)
+	assert.Equal(t, testIDs[:1], ids)
+}
+
+func TestSQLitePrune(t *testing.T) {
+	b, err := NewSQLite(testLogger(), filepath.Join(t.TempDir(), "messages.db"), time.Hour)
+	require.NoError(t, err)
+	defer b.Close()
+	s := b.Scope("gw")
+	s.Add("discord 1", testIDs)
+
+	sb := b.(*sqliteBackend)
+	_, err = sb.db.Exec(`UPDATE messages SET created = ?`, time.Now().Add(-2*time.Hour).Unix())
+	require.NoError(t, err)
+	s.Add("discord 2", testIDs[1:])
+	sb.prune()
+
+	assert.False(t, s.Contains("discord 1"))
+	assert.True(t, s.Contains("discord 2"))
+	assert.Equal(t, "discord 2", s.FindCanonical("slack 1.234"))
+}
@@ -0,0 +1,412 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/jpillora/backoff"
+)
+
+// maxQueueLength is the maximum number of messages waiting for a destination channel.
+const maxQueueLength = 1000
+
+// queueSaveInterval is how often the changed queues are written to
+// OutboundQueuePath.
+const queueSaveInterval = time.Second
+
+// queuedMessage is a message that failed to be sent to a destination channel
+// and is waiting to be retried.
+type queuedMessage struct {
+	Gateway  string
+	Account  string
+	Channel  config.ChannelInfo
+	ParentID string
+	// Msg keeps the extras with plain json values, the ones with types of the
+	// bridges, like slack_attachment, don't survive a restart.
+	Msg config.Message
+	// Files holds the files of Msg.Extra, which don't survive a json roundtrip.
+	// Their data isn't kept, only the URL on the mediaserver.
+	Files    []config.FileInfo
+	Attempts int
+	Retries  int
+}
+
+// outQueue holds the messages waiting for a single destination channel in order.
+type outQueue struct {
+	items   []*queuedMessage
+	running bool
+}
+
+// outbox retries messages that failed to be sent with OutboundQueueRetries
+// configured. Messages are kept in order per destination channel and
+// optionally persisted in OutboundQueuePath.
+type outbox struct {
+	sync.Mutex
+
+	router  *Router
+	queues  map[string]*outQueue
+	backoff backoff.Backoff
+	// stopped is set when the router stops, the remaining messages are kept
+	// in OutboundQueuePath for the next run.
+	stopped bool
+	// dirty holds the accounts whose queues changed since they were saved.
+	dirty map[string]bool
+	// saveLock keeps saves from writing the same file at the same time.
+	saveLock sync.Mutex
+}
+
+func newOutbox(r *Router) *outbox {
+	return &outbox{
+		router: r,
+		queues: make(map[string]*outQueue),
+		dirty:  make(map[string]bool),
+		backoff: backoff.Backoff{
+			Min:    time.Second,
+			Max:    5 * time.Minute,
+			Jitter: true,
+		},
+	}
+}
+
+func queueKey(account string, channel *config.ChannelInfo) string {
+	return account + " " + channel.ID
+}
+
+// pending returns true if messages are waiting to be sent to the channel.
+// New messages need to wait behind them to keep the order.
+func (o *outbox) pending(dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	o.Lock()
+	defer o.Unlock()
+	q, ok := o.queues[queueKey(dest.Account, channel)]
+	return ok && len(q.items) > 0
+}
+
+// enqueue queues the message for a retry if the destination has retries
+// enabled and returns true if it was queued.
+func (o *outbox) enqueue(gw *Gateway, rmsg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo, canonicalParentMsgID string, attempts int) bool {
+	retries := dest.GetInt("OutboundQueueRetries")
+	if retries <= 0 {
+		return false
+	}
+	// typing notifications are useless when they arrive late
+	if rmsg.Event == config.EventUserTyping {
+		return false
+	}
+	item := &queuedMessage{
+		Gateway:  gw.Name,
+		Account:  dest.Account,
+		Channel:  *channel,
+		ParentID: canonicalParentMsgID,
+		Msg:      *rmsg,
+		Attempts: attempts,
+		Retries:  retries,
+	}
+	o.Lock()
+	defer o.Unlock()
+	if o.add(gw, item) {
+		o.dirty[dest.Account] = true
+	}
+	return true
+}
+
+// add queues item and starts retrying its queue. Returns false when the
+// queue is full. Must be called with the outbox lock held.
+func (o *outbox) add(gw *Gateway, item *queuedMessage) bool {
+	key := queueKey(item.Account, &item.Channel)
+	q, ok := o.queues[key]
+	if !ok {
+		q = &outQueue{}
+		o.queues[key] = q
+	}
+	if len(q.items) >= maxQueueLength {
+		gw.logger.Errorf("Outbound queue for %s (%s) is full, dropping message", item.Account, item.Channel.Name)
+		return false
+	}
+	q.items = append(q.items, item)
+	gw.logger.Debugf("Queued message for %s (%s), %d waiting", item.Account, item.Channel.Name, len(q.items))
+	if !q.running && !o.stopped {
+		q.running = true
+		go o.run(key, q)
+	}
+	return true
+}
+
+// run sends the messages of the queue until it's empty.
+func (o *outbox) run(key string, q *outQueue) {
+	for {
+		o.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			delete(o.queues, key)
+			o.Unlock()
+			return
+		}
+		item := q.items[0]
+		o.Unlock()
+
+		if item.Attempts > 0 {
+			time.Sleep(o.backoff.ForAttempt(float64(item.Attempts - 1)))
+		}
+		o.Lock()
+		if o.stopped {
+			q.running = false
+			o.Unlock()
+			return
+		}
+		o.Unlock()
+		retry, err := o.router.deliver(item)
+
+		o.Lock()
+		switch {
+		case err == nil:
+			q.items = q.items[1:]
+		case !retry:
+			o.router.logger.Errorf("Dropping queued message for %s (%s): %s", item.Account, item.Channel.Name, err)
+			q.items = q.items[1:]
+		default:
+			var fileErr *fileSendError
+			if errors.As(err, &fileErr) {
+				item.Msg = *fileErr.unsent(&item.Msg)
+			}
+			item.Attempts++
+			o.router.logger.Errorf("Retry %d of message for %s (%s) failed: %s", item.Attempts, item.Account, item.Channel.Name, err)
+			if item.Attempts > item.Retries {
+				o.router.logger.Errorf("Giving up on message for %s (%s) after %d retries", item.Account, item.Channel.Name, item.Attempts)
+				q.items = q.items[1:]
+			}
+		}
+		o.dirty[item.Account] = true
+		stopped := o.stopped
+		o.Unlock()
+		// the router doesn't save the queues anymore
+		if stopped {
+			o.save()
+		}
+	}
+}
+
+// stop stops retrying the queued messages.
+func (o *outbox) stop() {
+	o.Lock()
+	defer o.Unlock()
+	o.stopped = true
+}
+
+// deliver sends a queued message and records the message ID for edits and
+// threading. Returns if the message can be retried on failure.
+func (r *Router) deliver(item *queuedMessage) (bool, error) {
+	r.RLock()
+	gw, ok := r.Gateways[item.Gateway]
+	var dest *bridge.Bridge
+	if ok {
+		dest = gw.Bridges[item.Account]
+	}
+	r.RUnlock()
+	if !ok {
+		return false, fmt.Errorf("gateway %s doesn't exist anymore", item.Gateway)
+	}
+	if dest == nil {
+		return false, fmt.Errorf("bridge %s isn't part of gateway %s anymore", item.Account, item.Gateway)
+	}
+	mID, err := gw.SendMessage(&item.Msg, dest, &item.Channel, item.ParentID)
+	gw.addDestMsgID(&item.Msg, dest, &item.Channel, mID)
+	return err != nil, err
+}
+
+func (o *outbox) path() string {
+	return o.router.BridgeValues().General.OutboundQueuePath
+}
+
+// save writes the queues that changed to OutboundQueuePath.
+func (o *outbox) save() {
+	o.saveLock.Lock()
+	defer o.saveLock.Unlock()
+	o.Lock()
+	if len(o.dirty) == 0 || o.path() == "" {
+		o.Unlock()
+		return
+	}
+	queues := make(map[string][]queuedMessage, len(o.dirty))
+	for account := range o.dirty {
+		queues[account] = o.persisted(account)
+	}
+	o.dirty = make(map[string]bool)
+	o.Unlock()
+
+	for account, items := range queues {
+		o.write(account, items)
+	}
+}
+
+// persisted returns the queued messages of the account as they are saved.
+// Must be called with the outbox lock held.
+func (o *outbox) persisted(account string) []queuedMessage {
+	var items []queuedMessage
+	for key, q := range o.queues {
+		if !strings.HasPrefix(key, account+" ") {
+			continue
+		}
+		for _, item := range q.items {
+			persisted := *item
+			persisted.Msg.Extra = nil
+			persisted.Files = nil
+			for key, values := range item.Msg.Extra {
+				if key == "file" {
+					continue
+				}
+				if !jsonValue(values) {
+					o.router.logger.Debugf("Not saving extra %s of message for %s (%s)", key, item.Account, item.Channel.Name)
+					continue
+				}
+				if persisted.Msg.Extra == nil {
+					persisted.Msg.Extra = make(map[string][]interface{})
+				}
+				persisted.Msg.Extra[key] = values
+			}
+			for _, f := range item.Msg.Extra["file"] {
+				if fi, ok := f.(config.FileInfo); ok {
+					fi.Data = nil
+					persisted.Files = append(persisted.Files, fi)
+				}
+			}
+			items = append(items, persisted)
+		}
+	}
+	return items
+}
+
+// jsonValue returns true if v decodes from json to the same value.
+func jsonValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil, string, bool, float64:
+		return true
+	case []interface{}:
+		for _, value := range v {
+			if !jsonValue(value) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, value := range v {
+			if !jsonValue(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// write writes the queued messages of the account to OutboundQueuePath.
+func (o *outbox) write(account string, items []queuedMessage) {
+	file := filepath.Join(o.path(), account+".json")
+	if len(items) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			o.router.logger.Errorf("Removing outbound queue %s failed: %s", file, err)
+		}
+		return
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		o.router.logger.Errorf("Encoding outbound queue of %s failed: %s", account, err)
+		return
+	}
+	// write to a temporary file first so a crash doesn't leave a partial queue.
+	if err := ioutil.WriteFile(file+".tmp", data, 0o600); err != nil {
+		o.router.logger.Errorf("Writing outbound queue %s failed: %s", file, err)
+		return
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		o.router.logger.Errorf("Writing outbound queue %s failed: %s", file, err)
+	}
+}
+
+// load queues the messages persisted in OutboundQueuePath by a previous run.
+// The files are only written again when messages of them can't be queued
+// anymore, because their gateway or account was removed or the account
+// doesn't have retries enabled.
+func (o *outbox) load() error {
+	if o.path() == "" {
+		return nil
+	}
+	if err := os.MkdirAll(o.path(), 0o700); err != nil {
+		return fmt.Errorf("creating OutboundQueuePath failed: %s", err)
+	}
+	files, err := filepath.Glob(filepath.Join(o.path(), "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var items []queuedMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			o.router.logger.Errorf("Ignoring corrupt outbound queue %s: %s", file, err)
+			continue
+		}
+		queued := 0
+		for idx := range items {
+			item := &items[idx]
+			gw, ok := o.router.Gateways[item.Gateway]
+			if !ok {
+				continue
+			}
+			dest, ok := gw.Bridges[item.Account]
+			if !ok {
+				continue
+			}
+			item.Retries = dest.GetInt("OutboundQueueRetries")
+			if item.Retries <= 0 {
+				continue
+			}
+			if len(item.Files) > 0 {
+				if item.Msg.Extra == nil {
+					item.Msg.Extra = make(map[string][]interface{})
+				}
+				for _, fi := range item.Files {
+					item.Msg.Extra["file"] = append(item.Msg.Extra["file"], fi)
+				}
+			}
+			o.Lock()
+			if o.add(gw, item) {
+				queued++
+			}
+			o.Unlock()
+		}
+		if queued < len(items) {
+			// the next save drops the messages that weren't queued
+			o.Lock()
+			o.dirty[strings.TrimSuffix(filepath.Base(file), ".json")] = true
+			o.Unlock()
+		}
+		o.router.logger.Infof("Loaded %d of %d queued messages from %s", queued, len(items), file)
+	}
+	return nil
+}
+
+// saveOutbox writes the changed queues to OutboundQueuePath every
+// queueSaveInterval until the router stops.
+func (r *Router) saveOutbox() {
+	ticker := time.NewTicker(queueSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.outbox.save()
+	}
+}
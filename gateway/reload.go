@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"reflect"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Reload applies a changed configuration to the running gateways.
+// Gateways that are added, removed or changed are set up again, new bridges
+// get connected, newly mapped channels get joined and bridges that aren't used
+// anymore are disconnected. Bridges that are still used stay connected.
+// Changes to the connection settings of a running bridge and to the [general]
+// section it uses need a restart.
+func (r *Router) Reload() {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	r.RLock()
+	stopped := r.stopped
+	r.RUnlock()
+	if stopped {
+		return
+	}
+
+	gwconfigs, err := gatewayConfigs(r.Config)
+	if err != nil {
+		r.logger.Errorf("Reload failed: %s", err)
+		return
+	}
+	if err := r.checkReloadConfig(gwconfigs); err != nil {
+		r.logger.Errorf("Reload failed: %s", err)
+		return
+	}
+
+	r.Lock()
+	oldBridges := r.bridges()
+	gateways := r.stageGateways(gwconfigs)
+	newBridges := make(map[string]*bridge.Bridge)
+	for _, gw := range gateways {
+		for _, br := range gw.Bridges {
+			newBridges[br.Account] = br
+		}
+	}
+	r.Unlock()
+
+	for account, br := range newBridges {
+		if _, ok := oldBridges[account]; ok {
+			continue
+		}
+		r.logger.Infof("Starting bridge: %s ", account)
+		if err := br.Connect(); err != nil {
+			r.logger.Errorf("Bridge %s failed to start: %v", account, err)
+			delete(newBridges, account)
+			continue
+		}
+		br.SetConnected(true)
+	}
+
+	r.Lock()
+	for name, gw := range gateways {
+		for account := range gw.Bridges {
+			if _, ok := newBridges[account]; !ok {
+				r.logger.Errorf("removing failed bridge %s from gateway %s", account, name)
+				delete(gw.Bridges, account)
+			}
+		}
+	}
+	for name := range r.Gateways {
+		if _, ok := gateways[name]; !ok {
+			r.logger.Infof("Removing gateway %s", name)
+		}
+	}
+	r.Gateways = gateways
+	r.staged = nil
+	for _, br := range newBridges {
+		r.remapChannels(br)
+	}
+	r.Unlock()
+
+	for account, br := range newBridges {
+		if err := br.JoinChannels(); err != nil {
+			r.logger.Errorf("Bridge %s failed to join channel: %v", account, err)
+		}
+		if _, ok := oldBridges[account]; !ok {
+			r.backfill(br)
+		}
+	}
+	for account, br := range oldBridges {
+		if _, ok := newBridges[account]; ok {
+			continue
+		}
+		r.logger.Infof("Stopping bridge: %s", account)
+		br.SetConnected(false)
+		if err := br.Disconnect(); err != nil {
+			r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+		}
+	}
+}
+
+// checkReloadConfig returns an error for configuration mistakes that would make
+// setting up the gateways exit the program.
+func (r *Router) checkReloadConfig(gwconfigs []*config.Gateway) error {
+	for _, gwconfig := range gwconfigs {
+		if problems := checkGatewayConfig(r.Config, r.BridgeMap, gwconfig); len(problems) > 0 {
+			return problems[0]
+		}
+	}
+	return nil
+}
+
+// stageGateways returns the gateways for gwconfigs, reusing the running
+// gateways whose configuration didn't change.
+func (r *Router) stageGateways(gwconfigs []*config.Gateway) map[string]*Gateway {
+	r.staged = make(map[string]*Gateway)
+	for _, gwconfig := range gwconfigs {
+		old, ok := r.Gateways[gwconfig.Name]
+		if ok && reflect.DeepEqual(old.MyConfig, gwconfig) {
+			r.staged[gwconfig.Name] = old
+			continue
+		}
+		if ok {
+			r.logger.Infof("Updating gateway %s", gwconfig.Name)
+		} else {
+			r.logger.Infof("Adding gateway %s", gwconfig.Name)
+		}
+		gw := New(r.rootLogger, gwconfig, r)
+		if ok {
+			// keep the message mapping so edits and threads keep working
+			gw.Messages = old.Messages
+			gw.texts = old.texts
+		}
+		r.staged[gwconfig.Name] = gw
+	}
+	return r.staged
+}
+
+// bridges returns all the bridges of the running gateways by account.
+func (r *Router) bridges() map[string]*bridge.Bridge {
+	m := make(map[string]*bridge.Bridge)
+	for _, gw := range r.Gateways {
+		for _, br := range gw.Bridges {
+			m[br.Account] = br
+		}
+	}
+	return m
+}
+
+// remapChannels sets the channels of the bridge to the ones mapped by the gateways.
+// Channels that aren't mapped anymore are forgotten so they get joined again
+// when they're added back.
+func (r *Router) remapChannels(br *bridge.Bridge) {
+	channels := make(map[string]config.ChannelInfo)
+	for _, gw := range r.Gateways {
+		for ID, channel := range gw.Channels {
+			if channel.Account == br.Account {
+				channels[ID] = *channel
+			}
+		}
+	}
+	br.Channels = channels
+	for ID := range br.Joined {
+		if _, ok := channels[ID]; !ok {
+			delete(br.Joined, ID)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package gateway
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/mediastore"
+	"github.com/42wim/matterbridge/gateway/msgstore"
 	"github.com/42wim/matterbridge/gateway/samechannel"
+	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,7 +24,25 @@ type Router struct {
 	Message          chan config.Message
 	MattermostPlugin chan config.Message
 
-	logger *logrus.Entry
+	// staged holds the gateways being set up during a reload.
+	staged      map[string]*Gateway
+	reloadLock  sync.Mutex
+	dispatcher  *dispatcher
+	outbox      *outbox
+	msgStore    msgstore.Backend
+	mediaStore  mediastore.Store
+	checkpoints *checkpoints
+	// msgIDLock serializes updates of the message ID's by the workers.
+	msgIDLock sync.Mutex
+	// stopped is set by Stop, received messages are ignored afterwards.
+	stopped bool
+	// done is closed by Stop to end the background jobs.
+	done          chan struct{}
+	adminServer   *echo.Echo
+	metricsServer *http.Server
+	mediaServer   *http.Server
+	rootLogger    *logrus.Logger
+	logger        *logrus.Entry
 }
 
 // NewRouter initializes a new Matterbridge router for the specified configuration and
@@ -34,11 +56,43 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 		Message:          make(chan config.Message),
 		MattermostPlugin: make(chan config.Message),
 		Gateways:         make(map[string]*Gateway),
+		done:             make(chan struct{}),
+		rootLogger:       rootLogger,
 		logger:           logger,
 	}
+	general := cfg.BridgeValues().General
+	store, err := msgstore.Open(logger, &general)
+	if err != nil {
+		return nil, fmt.Errorf("opening message store failed: %s", err)
+	}
+	r.msgStore = store
+	r.mediaStore, err = mediastore.Open(logger, &general)
+	if err != nil {
+		return nil, fmt.Errorf("opening media store failed: %s", err)
+	}
+	r.checkpoints, err = loadCheckpoints(general.BackfillPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading BackfillPath failed: %s", err)
+	}
+	r.dispatcher = newDispatcher(r)
+	r.outbox = newOutbox(r)
+	gwconfigs, err := gatewayConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range gwconfigs {
+		r.Gateways[entry.Name] = New(rootLogger, entry, r)
+	}
+	return r, nil
+}
+
+// gatewayConfigs returns the configuration of all enabled gateways, including
+// the samechannelgateways.
+func gatewayConfigs(cfg config.Config) ([]*config.Gateway, error) {
+	var res []*config.Gateway
 	sgw := samechannel.New(cfg)
 	gwconfigs := append(sgw.GetConfig(), cfg.BridgeValues().Gateway...)
-
+	names := make(map[string]bool)
 	for idx := range gwconfigs {
 		entry := &gwconfigs[idx]
 		if !entry.Enable {
@@ -47,12 +101,13 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 		if entry.Name == "" {
 			return nil, fmt.Errorf("%s", "Gateway without name found")
 		}
-		if _, ok := r.Gateways[entry.Name]; ok {
+		if names[entry.Name] {
 			return nil, fmt.Errorf("Gateway with name %s already exists", entry.Name)
 		}
-		r.Gateways[entry.Name] = New(rootLogger, entry, r)
+		names[entry.Name] = true
+		res = append(res, entry)
 	}
-	return r, nil
+	return res, nil
 }
 
 // Start will connect all gateways belonging to this router and subsequently route messages
@@ -81,6 +136,7 @@ func (r *Router) Start() error {
 			}
 			return e
 		}
+		br.SetConnected(true)
 		err = br.JoinChannels()
 		if err != nil {
 			e := fmt.Errorf("Bridge %s failed to join channel: %v", br.Account, err)
@@ -99,8 +155,24 @@ func (r *Router) Start() error {
 			}
 		}
 	}
+	if err := r.outbox.load(); err != nil {
+		return err
+	}
+	if err := r.startAdmin(); err != nil {
+		return err
+	}
+	r.startMetrics()
+	if err := r.startMediaServer(); err != nil {
+		return err
+	}
 	go r.handleReceive()
-	//go r.updateChannelMembers()
+	go r.updateChannelMembers()
+	go r.saveCheckpoints()
+	go r.saveOutbox()
+	for _, br := range m {
+		r.backfill(br)
+	}
+	r.Config.OnReload(r.Reload)
 	return nil
 }
 
@@ -124,70 +196,142 @@ func (r *Router) getBridge(account string) *bridge.Bridge {
 			return br
 		}
 	}
+	for _, gw := range r.staged {
+		if br, ok := gw.Bridges[account]; ok {
+			return br
+		}
+	}
 	return nil
 }
 
 func (r *Router) handleReceive() {
 	for msg := range r.Message {
 		msg := msg // scopelint
+		r.RLock()
+		if r.stopped {
+			r.RUnlock()
+			continue
+		}
 		r.handleEventGetChannelMembers(&msg)
 		r.handleEventFailure(&msg)
 		r.handleEventRejoinChannels(&msg)
+		r.handleEventBackfill(&msg)
+		r.handleMessage(&msg)
+		r.RUnlock()
+	}
+}
+
+// handleMessage relays msg to all the gateways.
+func (r *Router) handleMessage(msg *config.Message) {
+	// Set message protocol based on the account it came from
+	br := r.getBridge(msg.Account)
+	if br == nil {
+		// the bridge can be removed by a reload while its messages are still queued
+		r.logger.Debugf("ignoring message from unknown account %s", msg.Account)
+		return
+	}
+	msg.Protocol = br.Protocol
+	switch msg.Event {
+	case config.EventFailure, config.EventGetChannelMembers, config.EventRejoinChannels, config.EventBackfill:
+	default:
+		metricMessagesReceived.Inc(msg.Account)
+	}
 
-		// Set message protocol based on the account it came from
-		msg.Protocol = r.getBridge(msg.Account).Protocol
+	// ReceivedAt is when the gateway got the message, Timestamp keeps the
+	// native time of the protocol when the bridge set it
+	msg.ReceivedAt = time.Now()
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = msg.ReceivedAt
+	}
 
-		filesHandled := false
-		for _, gw := range r.Gateways {
-			// record all the message ID's of the different bridges
-			var msgIDs []*BrMsgID
-			if gw.ignoreMessage(&msg) {
-				continue
-			}
-			msg.Timestamp = time.Now()
-			gw.modifyMessage(&msg)
-			if !filesHandled {
-				gw.handleFiles(&msg)
-				filesHandled = true
-			}
-			for _, br := range gw.Bridges {
-				msgIDs = append(msgIDs, gw.handleMessage(&msg, br)...)
-			}
+	if r.handleCommand(msg) {
+		return
+	}
+
+	filesHandled := false
+	isNew := false
+	for _, gw := range r.Gateways {
+		if gw.disabled || gw.ignoreMessage(msg) {
+			continue
+		}
+		// backfills overlap with the messages that were relayed already
+		if msg.Backfill && gw.Messages.Contains(msg.Protocol+" "+msg.ID) {
+			continue
+		}
+		gw.modifyMessage(msg)
+		gw.rememberText(msg)
+		if !filesHandled {
+			gw.handleFiles(msg)
+			filesHandled = true
+		}
 
-			if msg.ID != "" {
-				_, exists := gw.Messages.Get(msg.Protocol + " " + msg.ID)
-
-				// Only add the message ID if it doesn't already exist
-				//
-				// For some bridges we always add/update the message ID.
-				// This is necessary as msgIDs will change if a bridge returns
-				// a different ID in response to edits.
-				if !exists {
-					gw.Messages.Add(msg.Protocol+" "+msg.ID, msgIDs)
-				}
+		// Add the message ID before dispatching, the workers add the ID's
+		// of the destinations when they've sent it.
+		// Edits keep the ID's of the original message.
+		if msg.ID != "" {
+			r.msgIDLock.Lock()
+			if !gw.Messages.Contains(msg.Protocol + " " + msg.ID) {
+				gw.Messages.Add(msg.Protocol+" "+msg.ID, nil)
+				isNew = true
 			}
+			r.msgIDLock.Unlock()
 		}
+		for _, dest := range gw.Bridges {
+			gw.handleMessage(msg, dest)
+		}
+	}
+	if isNew && (msg.Event == "" || msg.Event == config.EventUserAction) {
+		r.checkpoints.update(msg)
 	}
 }
 
-// updateChannelMembers sends every minute an GetChannelMembers event to all bridges.
+// defaultChannelMembersInterval is the time between the updates of the
+// channel members when ChannelMembersInterval isn't set.
+const defaultChannelMembersInterval = 5 * time.Minute
+
+// updateChannelMembers asks the bridges for their channel members every
+// ChannelMembersInterval minutes until the router stops.
 func (r *Router) updateChannelMembers() {
-	// TODO sleep a minute because slack can take a while
-	// fix this by having actually connectionDone events send to the router
-	time.Sleep(time.Minute)
+	interval := time.Duration(r.BridgeValues().General.ChannelMembersInterval) * time.Minute
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultChannelMembersInterval
+	}
+	// the first update waits at most a minute, the bridges take a while to
+	// learn their channels and users after connecting
+	wait := interval
+	if wait > time.Minute {
+		wait = time.Minute
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 	for {
-		for _, gw := range r.Gateways {
-			for _, br := range gw.Bridges {
-				// only for slack now
-				if br.Protocol != "slack" {
-					continue
-				}
-				r.logger.Debugf("sending %s to %s", config.EventGetChannelMembers, br.Account)
-				if _, err := br.Send(config.Message{Event: config.EventGetChannelMembers}); err != nil {
-					r.logger.Errorf("updateChannelMembers: %s", err)
-				}
-			}
+		select {
+		case <-r.done:
+			return
+		case <-timer.C:
+		}
+		r.requestChannelMembers()
+		timer.Reset(interval)
+	}
+}
+
+// requestChannelMembers sends an EventGetChannelMembers to the connected
+// bridges that can list their channel members. They answer with an
+// EventGetChannelMembers message.
+func (r *Router) requestChannelMembers() {
+	r.RLock()
+	bridges := r.bridges()
+	r.RUnlock()
+	for _, br := range bridges {
+		if _, ok := br.Bridger.(bridge.MemberLister); !ok || !br.Connected() {
+			continue
+		}
+		r.logger.Debugf("sending %s to %s", config.EventGetChannelMembers, br.Account)
+		if _, err := br.Send(config.Message{Event: config.EventGetChannelMembers, Account: br.Account}); err != nil {
+			r.logger.Errorf("updateChannelMembers: %s", err)
 		}
-		time.Sleep(time.Minute)
 	}
 }
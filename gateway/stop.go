@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+)
+
+// Stop shuts the router down. Messages received from the bridges are ignored,
+// the messages that are being relayed are sent and all bridges are
+// disconnected. Messages waiting for a retry are kept in OutboundQueuePath.
+// Returns the error of ctx when it's done before the shutdown is finished.
+func (r *Router) Stop(ctx context.Context) error {
+	r.Lock()
+	if r.stopped {
+		r.Unlock()
+		return nil
+	}
+	r.stopped = true
+	r.Unlock()
+	close(r.done)
+
+	if r.adminServer != nil {
+		if err := r.adminServer.Shutdown(ctx); err != nil {
+			r.logger.Errorf("Stopping admin API failed: %s", err)
+		}
+	}
+	if r.metricsServer != nil {
+		if err := r.metricsServer.Shutdown(ctx); err != nil {
+			r.logger.Errorf("Stopping metrics failed: %s", err)
+		}
+	}
+	if r.mediaServer != nil {
+		if err := r.mediaServer.Shutdown(ctx); err != nil {
+			r.logger.Errorf("Stopping media server failed: %s", err)
+		}
+	}
+
+	r.logger.Info("Sending the remaining messages")
+	drainErr := r.dispatcher.drain(ctx)
+	if drainErr != nil {
+		r.logger.Errorf("Not all messages could be sent: %s", drainErr)
+	}
+	r.outbox.stop()
+	r.outbox.save()
+
+	// wait for reloads in progress so they don't connect bridges behind our back
+	r.reloadLock.Lock()
+	r.RLock()
+	bridges := r.bridges()
+	r.RUnlock()
+	r.reloadLock.Unlock()
+
+	var wg sync.WaitGroup
+	for account, br := range bridges {
+		if br.Bridger == nil || !br.Connected() {
+			continue
+		}
+		account, br := account, br
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.logger.Infof("Stopping bridge: %s", account)
+			br.SetConnected(false)
+			if err := br.Disconnect(); err != nil {
+				r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := r.checkpoints.save(); err != nil {
+		r.logger.Errorf("Saving BackfillPath failed: %s", err)
+	}
+	if err := r.msgStore.Close(); err != nil {
+		r.logger.Errorf("Closing message store failed: %s", err)
+	}
+	if r.mediaStore != nil {
+		if err := r.mediaStore.Close(); err != nil {
+			r.logger.Errorf("Closing media store failed: %s", err)
+		}
+	}
+	return drainErr
+}
@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/mediastore"
+	"github.com/42wim/matterbridge/gateway/samechannel"
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+// extraKeys are the configuration keys read by the bridges that aren't part of
+// config.Protocol. TestExtraKeys checks that the keys the bridges read are in
+// config.Protocol or here, new keys are better added to config.Protocol.
+var extraKeys = []string{
+	"Anonymous", "AutoWebhooks", "Bind", "Community", "ExtractNicks",
+	"GuestSuffix", "Homeserver", "KeepQuotedReply", "MessageClipped", "Number",
+	"PingDelay", "QrOnWhiteTerminal", "SeparateDisplayName", "SpoofUsername",
+	"TLSCACertificate", "TLSClientCertificate", "TLSClientKey", "TokenBot",
+	"UseFullName", "UseRelayMsg", "UserComment", "UserID", "V6",
+}
+
+// tengoVariables are the variables the tengo scripts get, by configuration key.
+var tengoVariables = map[string][]string{
+	"InMessage": {"msgText", "msgUsername", "msgUserID", "msgAccount", "msgChannel"},
+	"RemoteNickFormat": {
+		"result", "msgText", "msgUsername", "msgUserID", "nick", "msgAccount", "msgChannel",
+		"channel", "msgProtocol", "remoteAccount", "protocol", "bridge", "gateway",
+	},
+	"OutMessage": {
+		"inAccount", "inProtocol", "inChannel", "inGateway", "inEvent", "outAccount", "outProtocol",
+		"outChannel", "outGateway", "outEvent", "msgText", "msgUsername", "msgUserID", "msgDrop",
+	},
+}
+
+// Validate checks the configuration without connecting to anything. It returns
+// all the problems that keep matterbridge from starting or relaying messages,
+// and warnings for configuration keys that aren't used.
+func Validate(cfg config.Config, bridgeMap map[string]bridge.Factory) ([]error, []string) {
+	var problems []error
+	problems = append(problems, validateGateways(cfg, bridgeMap)...)
+	for _, section := range configSections(cfg, bridgeMap) {
+		problems = append(problems, validateRegexps(cfg, section)...)
+	}
+	problems = append(problems, validateTengo(cfg.BridgeValues())...)
+	if general := cfg.BridgeValues().General; general.MediaServerBindAddress != "" && general.MediaDownloadPath == "" {
+		problems = append(problems, fmt.Errorf("MediaServerBindAddress is configured without a MediaDownloadPath"))
+	}
+	if err := mediastore.Check(&cfg.BridgeValues().General); err != nil {
+		problems = append(problems, err)
+	}
+	return problems, unknownKeys(cfg, bridgeMap)
+}
+
+// validateGateways checks the enabled gateways like NewRouter and Start do.
+func validateGateways(cfg config.Config, bridgeMap map[string]bridge.Factory) []error {
+	var problems []error
+	gwconfigs := append(samechannel.New(cfg).GetConfig(), cfg.BridgeValues().Gateway...)
+	names := make(map[string]bool)
+	for idx := range gwconfigs {
+		gwconfig := &gwconfigs[idx]
+		if !gwconfig.Enable {
+			continue
+		}
+		switch {
+		case gwconfig.Name == "":
+			problems = append(problems, fmt.Errorf("gateway without name found"))
+		case names[gwconfig.Name]:
+			problems = append(problems, fmt.Errorf("gateway with name %s already exists", gwconfig.Name))
+		}
+		names[gwconfig.Name] = true
+		if len(gwconfig.In)+len(gwconfig.Out)+len(gwconfig.InOut) == 0 {
+			problems = append(problems, fmt.Errorf("no bridges configured for gateway %s", gwconfig.Name))
+		}
+		problems = append(problems, checkGatewayConfig(cfg, bridgeMap, gwconfig)...)
+	}
+	if len(names) == 0 {
+		problems = append(problems, fmt.Errorf("no [[gateway]] configured"))
+	}
+	return problems
+}
+
+// checkGatewayConfig returns the accounts of the gateway that are incorrect,
+// use an unknown protocol or aren't configured.
+func checkGatewayConfig(cfg config.Config, bridgeMap map[string]bridge.Factory, gwconfig *config.Gateway) []error {
+	var problems []error
+	keys := cfg.Viper().AllKeys()
+	for _, br := range append(gwconfig.In, append(gwconfig.InOut, gwconfig.Out...)...) {
+		accInfo := strings.Split(br.Account, ".")
+		if len(accInfo) != 2 {
+			problems = append(problems, fmt.Errorf("account incorrect: %s", br.Account))
+			continue
+		}
+		if _, ok := bridgeMap[accInfo[0]]; !ok {
+			problems = append(problems, fmt.Errorf("incorrect protocol %s specified in gateway configuration %s", accInfo[0], br.Account))
+			continue
+		}
+		found := false
+		for _, key := range keys {
+			if strings.HasPrefix(key, strings.ToLower(br.Account)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Errorf("account %s defined in gateway %s but no configuration found", br.Account, gwconfig.Name))
+		}
+	}
+	return problems
+}
+
+// configSections returns general and the configured accounts of known protocols.
+func configSections(cfg config.Config, bridgeMap map[string]bridge.Factory) []string {
+	sections := map[string]bool{"general": true}
+	for _, key := range cfg.Viper().AllKeys() {
+		parts := strings.Split(key, ".")
+		if _, ok := bridgeMap[parts[0]]; ok && len(parts) > 2 {
+			sections[parts[0]+"."+parts[1]] = true
+		}
+	}
+	res := make([]string, 0, len(sections))
+	for section := range sections {
+		res = append(res, section)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// validateRegexps compiles the regular expressions configured in the section.
+func validateRegexps(cfg config.Config, section string) []error {
+	var problems []error
+	check := func(key, expr string) {
+		if _, err := regexp.Compile(expr); err != nil {
+			problems = append(problems, fmt.Errorf("%s.%s: %s", section, key, err))
+		}
+	}
+	for _, key := range []string{"IgnoreNicks", "IgnoreMessages"} {
+		value, _ := cfg.GetString(section + "." + key)
+		for _, expr := range strings.Fields(value) {
+			check(key, expr)
+		}
+	}
+	for _, key := range []string{"ReplaceMessages", "ReplaceNicks", "ExtractNicks"} {
+		pairs, _ := cfg.GetStringSlice2D(section + "." + key)
+		for _, pair := range pairs {
+			if len(pair) != 2 {
+				problems = append(problems, fmt.Errorf("%s.%s: %q must be a search and a replacement", section, key, pair))
+				continue
+			}
+			check(key, pair[0])
+			// ExtractNicks has a second regexp to find the nick in the text
+			if key == "ExtractNicks" {
+				check(key, pair[1])
+			}
+		}
+	}
+	if section == "general" {
+		for _, expr := range cfg.BridgeValues().General.MediaDownloadBlackList {
+			check("MediaDownloadBlackList", expr)
+		}
+	}
+	return problems
+}
+
+// validateTengo compiles the configured tengo scripts.
+func validateTengo(values *config.BridgeValues) []error {
+	scripts := []struct {
+		key, filename string
+		variables     []string
+	}{
+		{"general.TengoModifyMessage", values.General.TengoModifyMessage, tengoVariables["InMessage"]},
+		{"tengo.InMessage", values.Tengo.InMessage, tengoVariables["InMessage"]},
+		{"tengo.Message", values.Tengo.Message, tengoVariables["InMessage"]},
+		{"tengo.RemoteNickFormat", values.Tengo.RemoteNickFormat, tengoVariables["RemoteNickFormat"]},
+		{"tengo.OutMessage", values.Tengo.OutMessage, tengoVariables["OutMessage"]},
+	}
+	var problems []error
+	for _, script := range scripts {
+		if script.filename == "" {
+			continue
+		}
+		if err := compileTengo(script.filename, script.variables); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %s", script.key, err))
+		}
+	}
+	return problems
+}
+
+func compileTengo(filename string, variables []string) error {
+	res, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	s := tengo.NewScript(res)
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	for _, name := range variables {
+		_ = s.Add(name, "")
+	}
+	_, err = s.Compile()
+	return err
+}
+
+// unknownKeys returns a warning for every configuration key matterbridge doesn't use.
+func unknownKeys(cfg config.Config, bridgeMap map[string]bridge.Factory) []string {
+	known := make(map[string]bool)
+	for _, key := range extraKeys {
+		known[strings.ToLower(key)] = true
+	}
+	for _, v := range []interface{}{config.Protocol{}, config.Tengo{}} {
+		t := reflect.TypeOf(v)
+		for i := 0; i < t.NumField(); i++ {
+			known[strings.ToLower(t.Field(i).Name)] = true
+		}
+	}
+	var warnings []string
+	for _, key := range cfg.Viper().AllKeys() {
+		parts := strings.Split(key, ".")
+		switch {
+		case parts[0] == "gateway" || parts[0] == "samechannelgateway":
+		case parts[0] == "general" || parts[0] == "tengo":
+			if len(parts) < 2 {
+				warnings = append(warnings, fmt.Sprintf("key %s must be a section", key))
+			} else if !known[parts[1]] {
+				warnings = append(warnings, fmt.Sprintf("unknown key %s", key))
+			}
+		case bridgeMap[parts[0]] == nil:
+			warnings = append(warnings, fmt.Sprintf("unknown protocol %s in key %s", parts[0], key))
+		case len(parts) < 3:
+			warnings = append(warnings, fmt.Sprintf("key %s doesn't belong to an account", key))
+		case !known[parts[2]]:
+			warnings = append(warnings, fmt.Sprintf("unknown key %s", key))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	problems, warnings := Validate(config.NewConfigFromString(logger, slowTestConfig), bridgemap.FullMap)
+	assert.Empty(t, problems)
+	assert.Empty(t, warnings)
+
+	script := filepath.Join(t.TempDir(), "out.tengo")
+	assert.NoError(t, ioutil.WriteFile(script, []byte("msgText = unknown"), 0o600))
+	cfg := config.NewConfigFromString(logger, []byte(`
+[general]
+MediaDownloadBlackList=["[.html"]
+[tengo]
+OutMessage="`+script+`"
+[irc.zzz]
+IgnoreNicks="bot (spam"
+ReplaceMessages=[["a(", "b"], ["c"]]
+ExtractNicks=[["bot", "<(.*"]]
+Colour=true
+[unknown.zzz]
+server=""
+
+[[gateway]]
+name="bridge1"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="unknown.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="irc"
+    channel="main"
+
+[[gateway]]
+name="bridge1"
+enable=true
+`))
+	problems, warnings = Validate(cfg, bridgemap.FullMap)
+	var msgs []string
+	for _, problem := range problems {
+		msgs = append(msgs, problem.Error())
+	}
+	assert.Len(t, msgs, 11)
+	assert.Contains(t, msgs, "account slack.zzz defined in gateway bridge1 but no configuration found")
+	assert.Contains(t, msgs, "incorrect protocol unknown specified in gateway configuration unknown.zzz")
+	assert.Contains(t, msgs, "account incorrect: irc")
+	assert.Contains(t, msgs, "gateway with name bridge1 already exists")
+	assert.Contains(t, msgs, "no bridges configured for gateway bridge1")
+	assert.Contains(t, msgs, "irc.zzz.IgnoreNicks: error parsing regexp: missing closing ): `(spam`")
+	assert.Contains(t, msgs, "irc.zzz.ReplaceMessages: error parsing regexp: missing closing ): `a(`")
+	assert.Contains(t, msgs, `irc.zzz.ReplaceMessages: ["c"] must be a search and a replacement`)
+	assert.Contains(t, msgs, "irc.zzz.ExtractNicks: error parsing regexp: missing closing ): `<(.*`")
+	assert.Contains(t, msgs, "general.MediaDownloadBlackList: error parsing regexp: missing closing ]: `[.html`")
+	assert.Contains(t, msgs, "tengo.OutMessage: Compile Error: unresolved reference 'unknown'\n\tat (main):1:11")
+	assert.Equal(t, []string{"unknown key irc.zzz.colour", "unknown protocol unknown in key unknown.zzz.server"}, warnings)
+}
+
+func TestUnknownKeysScalarSection(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.NewConfigFromString(logger, []byte(""))
+	cfg.Viper().Set("general", "x")
+	cfg.Viper().Set("tengo", "y")
+	assert.Equal(t, []string{"key general must be a section", "key tengo must be a section"}, unknownKeys(cfg, bridgemap.FullMap))
+}
+
+// TestExtraKeys checks that the keys the bridges read with a literal are known
+// to unknownKeys, and that extraKeys only has keys the bridges use.
+func TestExtraKeys(t *testing.T) {
+	protocol := make(map[string]bool)
+	typ := reflect.TypeOf(config.Protocol{})
+	for i := 0; i < typ.NumField(); i++ {
+		protocol[strings.ToLower(typ.Field(i).Name)] = true
+	}
+	extra := make(map[string]bool)
+	for _, key := range extraKeys {
+		assert.False(t, protocol[strings.ToLower(key)], "%s is part of config.Protocol", key)
+		extra[strings.ToLower(key)] = true
+	}
+
+	read := regexp.MustCompile(`\.(?:GetString|GetBool|GetInt|GetStringSlice|GetStringSlice2D|IsKeySet)\("(\w+)"\)`)
+	literal := regexp.MustCompile(`"(\w+)"`)
+	literals := make(map[string]bool)
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") ||
+			strings.HasSuffix(path, "_test.go") || path == "validate.go" {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range read.FindAllStringSubmatch(string(src), -1) {
+			key := strings.ToLower(m[1])
+			assert.True(t, protocol[key] || extra[key], "%s reads %s, add it to config.Protocol or extraKeys", path, m[1])
+		}
+		for _, m := range literal.FindAllStringSubmatch(string(src), -1) {
+			literals[strings.ToLower(m[1])] = true
+		}
+		return nil
+	}
+	// the gateway reads some keys of the accounts too
+	assert.NoError(t, filepath.Walk("../bridge", walk))
+	assert.NoError(t, filepath.Walk(".", walk))
+	for _, key := range extraKeys {
+		assert.True(t, literals[strings.ToLower(key)], "%s in extraKeys isn't used by the bridges", key)
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/gateway"
@@ -17,12 +21,17 @@ import (
 )
 
 var (
-	flagConfig  = flag.String("conf", "matterbridge.toml", "config file")
-	flagDebug   = flag.Bool("debug", false, "enable debug")
-	flagVersion = flag.Bool("version", false, "show version")
-	flagGops    = flag.Bool("gops", false, "enable gops agent")
+	flagConfig   = flag.String("conf", "matterbridge.toml", "config file")
+	flagDebug    = flag.Bool("debug", false, "enable debug")
+	flagVersion  = flag.Bool("version", false, "show version")
+	flagGops     = flag.Bool("gops", false, "enable gops agent")
+	flagValidate = flag.Bool("validate", false, "check the config file and exit")
 )
 
+// shutdownTimeout is the time the bridges get to send the remaining messages
+// and disconnect on SIGINT or SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	flag.Parse()
 	if *flagVersion {
@@ -33,6 +42,13 @@ func main() {
 	rootLogger := setupLogger()
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "main"})
 
+	if *flagValidate {
+		if !validateConfig(rootLogger) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *flagGops {
 		if err := agent.Listen(agent.Options{}); err != nil {
 			logger.Errorf("Failed to start gops agent: %#v", err)
@@ -66,7 +82,44 @@ func main() {
 		logger.Fatalf("Starting gateway failed: %s", err)
 	}
 	logger.Printf("Gateway(s) started successfully. Now relaying messages")
-	select {}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	logger.Printf("Received %s, shutting down", <-sig)
+	// a second signal skips the graceful shutdown
+	go func() {
+		<-sig
+		logger.Fatalf("Received second signal, exiting")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := r.Stop(ctx); err != nil {
+		logger.Errorf("Shutdown didn't finish cleanly: %s", err)
+	}
+}
+
+// validateConfig checks the config file without connecting to anything, logs
+// all the problems found and returns false if there are any.
+func validateConfig(rootLogger *logrus.Logger) bool {
+	logger := rootLogger.WithFields(logrus.Fields{"prefix": "validate"})
+	cfg, err := config.LoadConfig(rootLogger, *flagConfig)
+	if err != nil {
+		logger.Error(err)
+		return false
+	}
+	problems, warnings := gateway.Validate(cfg, bridgemap.FullMap)
+	for _, warning := range warnings {
+		logger.Warn(warning)
+	}
+	for _, problem := range problems {
+		logger.Error(problem)
+	}
+	if len(problems) > 0 {
+		logger.Errorf("%s has %d problem(s)", *flagConfig, len(problems))
+		return false
+	}
+	logger.Infof("%s is valid", *flagConfig)
+	return true
 }
 
 func setupLogger() *logrus.Logger {